@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"time"
 
+	pubsub "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub"
 	"github.com/libp2p/go-libp2p-core/host"
 
 	"github.com/libp2p/go-libp2p-core/peer"
@@ -24,12 +26,25 @@ func runPublisher() {
 		}
 	}()
 
-	ps, err := createPubSubs([]host.Host{h}) // Must create pubsub before connecting hosts
+	// Retain the last messages for subscribers catching up after an outage
+	store, err := pubsub.NewMemoryMessageStore(1000, time.Minute)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
+	ps, err := createPubSubs([]host.Host{h}, pubsub.WithMessageHistory(store)) // Must create pubsub before connecting hosts
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	// Serve the pubsub state for debugging while publishing
+	http.Handle("/debug/pubsub", pubsub.NewInspectHandler(ps[0]))
+	go func() {
+		log.Println(http.ListenAndServe("localhost:6060", nil))
+	}()
+
 	time.Sleep(10 * time.Second) // For subscriber to run
 
 	data := make([]byte, 10000)
@@ -70,6 +85,14 @@ func runSubscriber(maddr string) {
 		return
 	}
 
+	// Catch up on what was published while we were offline
+	n, err := ps[0].RequestHistory(ctx, addrInfo.ID, pubsub.HistoryQuery{Topic: "art"})
+	if err != nil {
+		log.Println(err)
+	} else {
+		log.Printf("recovered %d messages\n", n)
+	}
+
 	select {}
 }
 
@@ -0,0 +1,101 @@
+package pubsub
+
+import (
+	"fmt"
+
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// topicAuth is the authentication policy of a topic, as specified by the AuthOpts of
+// its TopicDescriptor.
+// In KEY mode, the descriptor lists the public keys (serialized with crypto.MarshalPublicKey)
+// trusted to publish in the topic; only messages signed by one of these keys are
+// delivered or forwarded.
+// In WOT mode, the keys are the roots of a web of trust, which delegate publishing
+// rights with certificates.
+type topicAuth struct {
+	mode pb.TopicDescriptor_AuthOpts_AuthMode
+	keys map[peer.ID]struct{}
+	wot  *wotTrust
+}
+
+// newTopicAuth creates the authentication policy for a topic descriptor.
+// It returns nil if the topic does not require authentication.
+func newTopicAuth(td *pb.TopicDescriptor) (*topicAuth, error) {
+	switch td.GetAuth().GetMode() {
+	case pb.TopicDescriptor_AuthOpts_NONE:
+		return nil, nil
+
+	case pb.TopicDescriptor_AuthOpts_KEY, pb.TopicDescriptor_AuthOpts_WOT:
+		mode := td.GetAuth().GetMode()
+		keys := td.GetAuth().GetKeys()
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("auth mode %s requires at least one key", mode)
+		}
+
+		auth := &topicAuth{
+			mode: mode,
+			keys: make(map[peer.ID]struct{}, len(keys)),
+		}
+
+		for _, kb := range keys {
+			pubk, err := crypto.UnmarshalPublicKey(kb)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth key: %s", err)
+			}
+
+			pid, err := peer.IDFromPublicKey(pubk)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth key: %s", err)
+			}
+
+			auth.keys[pid] = struct{}{}
+		}
+
+		if mode == pb.TopicDescriptor_AuthOpts_WOT {
+			auth.wot = newWotTrust(td, auth.keys)
+		}
+
+		return auth, nil
+
+	default:
+		return nil, fmt.Errorf("auth mode %s not yet supported", td.GetAuth().GetMode())
+	}
+}
+
+// authorized returns whether a publisher is allowed to publish in the topic.
+func (a *topicAuth) authorized(from peer.ID) bool {
+	if a.wot != nil {
+		return a.wot.authorized(from)
+	}
+
+	_, ok := a.keys[from]
+	return ok
+}
+
+// authorizeMsg checks a message against the authentication policy of the topics it is published
+// in; the message must be signed by a key trusted in every authenticated topic.
+// The signature itself is verified in the validation pipeline, which also checks the web of
+// trust certificates of WOT topics.
+// Only called from processLoop.
+func (p *PubSub) authorizeMsg(msg *Message) bool {
+	for _, topic := range msg.GetTopicIDs() {
+		t, ok := p.myTopics[topic]
+		if !ok || t.auth == nil {
+			continue
+		}
+
+		if msg.Signature == nil {
+			return false
+		}
+
+		if t.auth.wot == nil && !t.auth.authorized(msg.GetFrom()) {
+			return false
+		}
+	}
+
+	return true
+}
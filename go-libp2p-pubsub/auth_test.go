@@ -0,0 +1,148 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/host"
+
+	proto "github.com/gogo/protobuf/proto"
+)
+
+func keyTopicDescriptor(t *testing.T, topic string, hosts ...host.Host) *pb.TopicDescriptor {
+	mode := pb.TopicDescriptor_AuthOpts_KEY
+	td := &pb.TopicDescriptor{
+		Name: &topic,
+		Auth: &pb.TopicDescriptor_AuthOpts{Mode: &mode},
+	}
+
+	for _, h := range hosts {
+		kb, err := crypto.MarshalPublicKey(h.Peerstore().PubKey(h.ID()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		td.Auth.Keys = append(td.Auth.Keys, kb)
+	}
+
+	return td
+}
+
+func TestTopicAuthKey(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 4)
+	psubs := getPubsubs(ctx, hosts)
+
+	// host 0 is the trusted publisher; host 3 is unaware of the descriptor
+	td := keyTopicDescriptor(t, "foobar", hosts[0])
+
+	var subs []*Subscription
+	for _, ps := range psubs[:3] {
+		sub, err := ps.SubscribeByTopicDescriptor(td)
+		if err != nil {
+			t.Fatal(err)
+		}
+		subs = append(subs, sub)
+	}
+
+	sub3, err := psubs[3].Subscribe("foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connectAll(t, hosts)
+	time.Sleep(time.Millisecond * 100)
+
+	// the descriptor is retrievable per topic
+	rtd, err := psubs[1].GetTopicDescriptor("foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(rtd, td) {
+		t.Fatal("descriptor mismatch")
+	}
+
+	_, err = psubs[1].GetTopicDescriptor("barfoo")
+	if err == nil {
+		t.Fatal("expected an error for a topic we have not joined")
+	}
+
+	// messages signed by the trusted key are delivered
+	err = psubs[0].Publish("foobar", []byte("trusted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sub := range subs {
+		assertReceive(t, sub, []byte("trusted"))
+	}
+	assertReceive(t, sub3, []byte("trusted"))
+
+	// local publishes with an untrusted key fail
+	err = psubs[1].Publish("foobar", []byte("untrusted"))
+	if err == nil {
+		t.Fatal("expected publishing with an untrusted key to fail")
+	}
+
+	// messages signed by an untrusted key are neither delivered nor forwarded
+	err = psubs[3].Publish("foobar", []byte("untrusted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertReceive(t, sub3, []byte("untrusted"))
+
+	for _, sub := range subs {
+		ctx, cancel := context.WithTimeout(ctx, time.Millisecond*100)
+		msg, err := sub.Next(ctx)
+		cancel()
+		if err == nil {
+			t.Fatalf("unexpected message: %s", msg.GetData())
+		}
+	}
+}
+
+func TestTopicAuthDescriptorMismatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 2)
+	ps := getPubsub(ctx, hosts[0])
+
+	// KEY mode needs trusted keys
+	_, err := ps.JoinByTopicDescriptor(keyTopicDescriptor(t, "empty"))
+	if err == nil {
+		t.Fatal("expected an error for a KEY descriptor without keys")
+	}
+
+	td := keyTopicDescriptor(t, "foobar", hosts[0])
+	topic, err := ps.JoinByTopicDescriptor(td)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !proto.Equal(topic.Descriptor(), td) {
+		t.Fatal("descriptor mismatch")
+	}
+
+	// a plain subscription uses the existing handle, with its descriptor
+	_, err = ps.Subscribe("foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same descriptor can be used to subscribe
+	_, err = ps.SubscribeByTopicDescriptor(td)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// but not a different one
+	_, err = ps.SubscribeByTopicDescriptor(keyTopicDescriptor(t, "foobar", hosts[1]))
+	if err == nil {
+		t.Fatal("expected an error for a mismatched descriptor")
+	}
+}
@@ -1,16 +1,47 @@
 package pubsub
 
 import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/libp2p/go-libp2p-core/peer"
 )
 
 // Blacklist is an interface for peer blacklisting.
+// Blacklists are only accessed from the pubsub event loop, so implementations
+// need not be safe for concurrent use.
 type Blacklist interface {
 	Add(peer.ID)
 	Contains(peer.ID) bool
 }
 
+// RemovableBlacklist is an optional interface for blacklists that support removing peers,
+// which is required by PubSub.UnblacklistPeer.
+type RemovableBlacklist interface {
+	Blacklist
+	Remove(peer.ID)
+}
+
+// IterableBlacklist is an optional interface for blacklists that can enumerate the
+// blacklisted peers, which is required by PubSub.ListBlacklisted.
+type IterableBlacklist interface {
+	Blacklist
+	Iterate(func(peer.ID))
+}
+
+// ExpiringBlacklist is an optional interface for blacklists whose entries expire. Connected
+// peers blacklisted through PubSub.BlacklistPeer are re-admitted when their entry expires.
+type ExpiringBlacklist interface {
+	Blacklist
+	Expiry(peer.ID) (time.Time, bool)
+}
+
 // MapBlacklist is a blacklist implementation using a perfect map
 type MapBlacklist map[peer.ID]struct{}
 
@@ -28,6 +59,16 @@ func (b MapBlacklist) Contains(p peer.ID) bool {
 	return ok
 }
 
+func (b MapBlacklist) Remove(p peer.ID) {
+	delete(b, p)
+}
+
+func (b MapBlacklist) Iterate(f func(peer.ID)) {
+	for p := range b {
+		f(p)
+	}
+}
+
 // LRUBlacklist is a blacklist implementation using an LRU cache
 type LRUBlacklist struct {
 	lru *lru.Cache
@@ -51,3 +92,189 @@ func (b LRUBlacklist) Add(p peer.ID) {
 func (b LRUBlacklist) Contains(p peer.ID) bool {
 	return b.lru.Contains(p)
 }
+
+func (b LRUBlacklist) Remove(p peer.ID) {
+	b.lru.Remove(p)
+}
+
+func (b LRUBlacklist) Iterate(f func(peer.ID)) {
+	for _, k := range b.lru.Keys() {
+		f(k.(peer.ID))
+	}
+}
+
+// TimedBlacklist is a blacklist implementation where entries expire, so that peers
+// are only banned for a period of time. Peers that are still connected when their entry
+// expires are re-admitted.
+type TimedBlacklist struct {
+	ttl     time.Duration
+	entries map[peer.ID]time.Time
+}
+
+// NewTimedBlacklist creates a new TimedBlacklist where peers are blacklisted for ttl
+func NewTimedBlacklist(ttl time.Duration) *TimedBlacklist {
+	return &TimedBlacklist{
+		ttl:     ttl,
+		entries: make(map[peer.ID]time.Time),
+	}
+}
+
+func (b *TimedBlacklist) Add(p peer.ID) {
+	b.AddWithTTL(p, b.ttl)
+}
+
+// AddWithTTL blacklists a peer for a specific period of time
+func (b *TimedBlacklist) AddWithTTL(p peer.ID, ttl time.Duration) {
+	b.entries[p] = time.Now().Add(ttl)
+}
+
+func (b *TimedBlacklist) Contains(p peer.ID) bool {
+	expire, ok := b.entries[p]
+	if !ok {
+		return false
+	}
+
+	if !time.Now().Before(expire) {
+		delete(b.entries, p)
+		return false
+	}
+
+	return true
+}
+
+// Expiry returns the time the entry of a peer expires, or false if the peer is not blacklisted.
+func (b *TimedBlacklist) Expiry(p peer.ID) (time.Time, bool) {
+	if !b.Contains(p) {
+		return time.Time{}, false
+	}
+	return b.entries[p], true
+}
+
+func (b *TimedBlacklist) Remove(p peer.ID) {
+	delete(b.entries, p)
+}
+
+func (b *TimedBlacklist) Iterate(f func(peer.ID)) {
+	now := time.Now()
+	for p, expire := range b.entries {
+		if !now.Before(expire) {
+			delete(b.entries, p)
+			continue
+		}
+		f(p)
+	}
+}
+
+// FileBlacklist is a blacklist implementation persisted in a file, so that it survives restarts.
+// The file contains one peer ID per line; it is rewritten whenever the blacklist changes.
+type FileBlacklist struct {
+	path   string
+	peers  map[peer.ID]struct{}
+	logger Logger
+}
+
+// NewFileBlacklist creates a new FileBlacklist stored at path, loading the peers
+// already in the file, if it exists.
+func NewFileBlacklist(path string) (*FileBlacklist, error) {
+	b := &FileBlacklist{
+		path:   path,
+		peers:  make(map[peer.ID]struct{}),
+		logger: newGoLogger(log),
+	}
+
+	f, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err):
+		return b, nil
+	case err != nil:
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		p, err := peer.IDB58Decode(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer ID in blacklist file %s: %s", path, err)
+		}
+
+		b.peers[p] = struct{}{}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (b *FileBlacklist) Add(p peer.ID) {
+	if _, ok := b.peers[p]; ok {
+		return
+	}
+
+	b.peers[p] = struct{}{}
+	b.save()
+}
+
+func (b *FileBlacklist) Contains(p peer.ID) bool {
+	_, ok := b.peers[p]
+	return ok
+}
+
+func (b *FileBlacklist) Remove(p peer.ID) {
+	if _, ok := b.peers[p]; !ok {
+		return
+	}
+
+	delete(b.peers, p)
+	b.save()
+}
+
+func (b *FileBlacklist) Iterate(f func(peer.ID)) {
+	for p := range b.peers {
+		f(p)
+	}
+}
+
+func (b *FileBlacklist) setLogger(l Logger) {
+	b.logger = l
+}
+
+// save writes the blacklist to a temporary file and renames it into place, so that the file
+// is never left partially written.
+func (b *FileBlacklist) save() {
+	var sb strings.Builder
+	for p := range b.peers {
+		sb.WriteString(peer.IDB58Encode(p))
+		sb.WriteString("\n")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(b.path), filepath.Base(b.path)+".tmp")
+	if err != nil {
+		b.logger.Warnw("error saving blacklist", "path", b.path, "error", err)
+		return
+	}
+
+	_, err = tmp.WriteString(sb.String())
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), b.path)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		b.logger.Warnw("error saving blacklist", "path", b.path, "error", err)
+	}
+}
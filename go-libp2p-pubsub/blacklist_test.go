@@ -2,6 +2,9 @@ package pubsub
 
 import (
 	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -124,3 +127,187 @@ func TestBlacklist3(t *testing.T) {
 		t.Fatal("got message from blacklisted peer")
 	}
 }
+
+func TestTimedBlacklist(t *testing.T) {
+	b := NewTimedBlacklist(100 * time.Millisecond)
+
+	p := peer.ID("test")
+	p2 := peer.ID("test2")
+
+	b.Add(p)
+	b.AddWithTTL(p2, time.Hour)
+	if !b.Contains(p) || !b.Contains(p2) {
+		t.Fatal("peer not in the blacklist")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if b.Contains(p) {
+		t.Fatal("peer still in the blacklist after expiry")
+	}
+
+	var peers []peer.ID
+	b.Iterate(func(p peer.ID) {
+		peers = append(peers, p)
+	})
+	if len(peers) != 1 || peers[0] != p2 {
+		t.Fatal("unexpected blacklisted peers")
+	}
+
+	b.Remove(p2)
+	if b.Contains(p2) {
+		t.Fatal("peer still in the blacklist after removal")
+	}
+}
+
+func TestTimedBlacklistReadmit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 2)
+	psubs := []*PubSub{
+		getPubsub(ctx, hosts[0]),
+		getPubsub(ctx, hosts[1], WithBlacklist(NewTimedBlacklist(time.Millisecond*200))),
+	}
+	connect(t, hosts[0], hosts[1])
+
+	sub, err := psubs[0].Subscribe("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	psubs[1].BlacklistPeer(hosts[0].ID())
+	time.Sleep(time.Millisecond * 50)
+
+	psubs[1].Publish("test", []byte("blacklisted"))
+	assertNoReceive(t, ctx, sub)
+
+	// the peer is re-admitted once its entry expires, while still connected
+	time.Sleep(time.Millisecond * 300)
+	psubs[1].Publish("test", []byte("readmitted"))
+	assertReceive(t, sub, []byte("readmitted"))
+}
+
+func TestFileBlacklist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blacklist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "blacklist")
+
+	hosts := getNetHosts(t, context.Background(), 2)
+	p1 := hosts[0].ID()
+	p2 := hosts[1].ID()
+
+	b, err := NewFileBlacklist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.Add(p1)
+	b.Add(p2)
+	b.Remove(p2)
+
+	// the blacklist survives restarts
+	b, err = NewFileBlacklist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !b.Contains(p1) {
+		t.Fatal("peer not in the blacklist")
+	}
+	if b.Contains(p2) {
+		t.Fatal("removed peer in the blacklist")
+	}
+}
+
+func TestUnblacklistPeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 2)
+	psubs := getPubsubs(ctx, hosts)
+	connect(t, hosts[0], hosts[1])
+
+	sub0, err := psubs[0].Subscribe("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub1, err := psubs[1].Subscribe("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Millisecond * 100)
+	psubs[1].BlacklistPeer(hosts[0].ID())
+	time.Sleep(time.Millisecond * 100)
+
+	blacklisted, err := psubs[1].ListBlacklisted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blacklisted) != 1 || blacklisted[0] != hosts[0].ID() {
+		t.Fatal("expected the peer to be blacklisted")
+	}
+
+	psubs[0].Publish("test", []byte("message"))
+
+	wctx, wcancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer wcancel()
+	_, err = sub1.Next(wctx)
+	if err == nil {
+		t.Fatal("got message from blacklisted peer")
+	}
+
+	// the peer is re-admitted while still connected
+	err = psubs[1].UnblacklistPeer(hosts[0].ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	blacklisted, err = psubs[1].ListBlacklisted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blacklisted) != 0 {
+		t.Fatal("expected no blacklisted peers")
+	}
+
+	assertReceive(t, sub0, []byte("message"))
+
+	psubs[0].Publish("test", []byte("message2"))
+	assertReceive(t, sub0, []byte("message2"))
+	assertReceive(t, sub1, []byte("message2"))
+
+	psubs[1].Publish("test", []byte("message3"))
+	assertReceive(t, sub0, []byte("message3"))
+	assertReceive(t, sub1, []byte("message3"))
+}
+
+func TestUnblacklistPeerUnsupported(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 1)
+	ps := getPubsub(ctx, hosts[0], WithBlacklist(addOnlyBlacklist{}))
+
+	err := ps.UnblacklistPeer(peer.ID("test"))
+	if err == nil {
+		t.Fatal("expected an error for a blacklist without removal")
+	}
+
+	_, err = ps.ListBlacklisted()
+	if err == nil {
+		t.Fatal("expected an error for a blacklist without iteration")
+	}
+}
+
+type addOnlyBlacklist struct{}
+
+func (addOnlyBlacklist) Add(peer.ID)           {}
+func (addOnlyBlacklist) Contains(peer.ID) bool { return false }
@@ -0,0 +1,337 @@
+package pubsub
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"time"
+
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	proto "github.com/gogo/protobuf/proto"
+)
+
+// Limits on the chunked messages from other publishers being reassembled at any time; fragments
+// of further messages are dropped until some complete or expire. Our own chunked messages are
+// not subject to the limits.
+const (
+	// maxPendingReassemblies is the maximum number of chunked messages being reassembled
+	maxPendingReassemblies = 256
+	// maxPublisherReassemblies is the maximum number of chunked messages of a publisher
+	// being reassembled
+	maxPublisherReassemblies = 16
+	// maxPeerReassemblies is the maximum number of chunked messages being reassembled
+	// from fragments first received from a peer
+	maxPeerReassemblies = 64
+)
+
+// WithMessageChunking is an option to enable the chunking of large messages.
+// When enabled, the data of messages larger than chunkSize bytes is split in numbered fragments,
+// which are published, routed and deduplicated as separate messages. Receivers reassemble the
+// fragments before validation and delivery, and only forward the fragments once the reassembled
+// message has been validated. Messages whose data exceeds maxSize bytes can't be published, and
+// fragments of messages exceeding it are dropped; incomplete messages are dropped after timeout.
+// The chunk size must leave room for the message envelope within the maximum message size.
+// Note that peers without chunking treat fragments as regular messages.
+func WithMessageChunking(chunkSize, maxSize int, timeout time.Duration) Option {
+	return func(p *PubSub) error {
+		if chunkSize <= 0 {
+			return errors.New("invalid chunk size; must be positive")
+		}
+		if maxSize < chunkSize {
+			return errors.New("invalid max chunked message size; must be at least the chunk size")
+		}
+		if timeout <= 0 {
+			return errors.New("invalid reassembly timeout; must be positive")
+		}
+
+		p.chunker = &chunker{
+			chunkSize: chunkSize,
+			maxSize:   maxSize,
+			timeout:   timeout,
+			pending:   make(map[string]*reassembly),
+			byFrom:    make(map[string]int),
+			byPeer:    make(map[peer.ID]int),
+		}
+		return nil
+	}
+}
+
+// chunker splits the data of large messages in chunks and reassembles the fragments of
+// received messages. The reassembly state is only accessed from the event loop.
+type chunker struct {
+	chunkSize int
+	maxSize   int
+	timeout   time.Duration
+
+	pending map[string]*reassembly
+	// number of pending chunked messages from other publishers, in total, by publisher
+	// and by the peer that sent the first fragment
+	remote int
+	byFrom map[string]int
+	byPeer map[peer.ID]int
+
+	// logger of the pubsub instance, set once the options have been applied
+	logger Logger
+}
+
+// reassembly is a chunked message being reassembled
+type reassembly struct {
+	from     string
+	peer     peer.ID
+	local    bool
+	topics   []string
+	total    uint32
+	frags    map[uint32]*Message
+	size     int
+	deadline time.Time
+}
+
+// split splits data in chunks of at most chunkSize bytes
+func (c *chunker) split(data []byte) [][]byte {
+	chunks := make([][]byte, 0, (len(data)+c.chunkSize-1)/c.chunkSize)
+	for len(data) > c.chunkSize {
+		chunks = append(chunks, data[:c.chunkSize])
+		data = data[c.chunkSize:]
+	}
+	return append(chunks, data)
+}
+
+// add adds a fragment to its chunked message, returning the reassembled message once
+// all the fragments have been received. Local fragments are those of our own messages.
+func (c *chunker) add(msg *Message, local bool) *Message {
+	frag := msg.GetFragment()
+	if len(msg.GetData()) == 0 {
+		c.logger.Debugw("dropping empty fragment", "peer", msg.ReceivedFrom)
+		return nil
+	}
+
+	// fragments carry at least a byte, so a message within the maximum size can't have
+	// more fragments than bytes
+	idx, total := frag.GetIndex(), frag.GetTotal()
+	if total == 0 || idx >= total || int(total) > c.maxSize {
+		c.logger.Debugw("dropping fragment with invalid index", "peer", msg.ReceivedFrom, "index", idx, "total", total)
+		return nil
+	}
+
+	from := string(msg.Message.GetFrom())
+	key := from + string(frag.GetId())
+	r, ok := c.pending[key]
+	if !ok {
+		if !local && !c.admit(from, msg.ReceivedFrom) {
+			return nil
+		}
+
+		r = &reassembly{
+			from:     from,
+			peer:     msg.ReceivedFrom,
+			local:    local,
+			topics:   msg.GetTopicIDs(),
+			total:    total,
+			frags:    make(map[uint32]*Message),
+			deadline: time.Now().Add(c.timeout),
+		}
+		c.track(key, r)
+	}
+
+	if r.total != total || !sameTopics(r.topics, msg.GetTopicIDs()) {
+		c.logger.Debugw("dropping chunked message with inconsistent fragments", "peer", msg.ReceivedFrom)
+		c.untrack(key, r)
+		return nil
+	}
+
+	if _, ok := r.frags[idx]; ok {
+		return nil
+	}
+
+	r.size += len(msg.GetData())
+	if r.size > c.maxSize {
+		c.logger.Debugw("dropping chunked message exceeding the max size", "peer", msg.ReceivedFrom)
+		c.untrack(key, r)
+		return nil
+	}
+
+	r.frags[idx] = msg
+	if len(r.frags) < int(r.total) {
+		return nil
+	}
+
+	c.untrack(key, r)
+
+	frags := make([]*Message, r.total)
+	recovered := false
+	var data bytes.Buffer
+	data.Grow(r.size)
+	for i := range frags {
+		frags[i] = r.frags[uint32(i)]
+		data.Write(frags[i].GetData())
+		recovered = recovered || frags[i].recovered
+	}
+
+	first := frags[0].Message
+	return &Message{
+		Message: &pb.Message{
+			From:       first.From,
+			Data:       data.Bytes(),
+			Seqno:      frag.GetId(),
+			TopicSeqno: first.TopicSeqno,
+			TopicIDs:   first.TopicIDs,
+			Key:        first.Key,
+			Certs:      first.Certs,
+		},
+		ReceivedFrom: msg.ReceivedFrom,
+		fragments:    frags,
+		topics:       msg.topics,
+		recovered:    recovered,
+	}
+}
+
+// admit returns whether a new chunked message from another publisher can be reassembled
+// within the limits.
+func (c *chunker) admit(from string, src peer.ID) bool {
+	switch {
+	case c.remote >= maxPendingReassemblies:
+		c.logger.Warnw("too many chunked messages being reassembled; dropping fragment", "peer", src)
+		return false
+	case c.byFrom[from] >= maxPublisherReassemblies:
+		c.logger.Debugw("too many chunked messages from publisher being reassembled; dropping fragment",
+			"peer", src, "from", peer.ID(from))
+		return false
+	case c.byPeer[src] >= maxPeerReassemblies:
+		c.logger.Debugw("too many chunked messages from peer being reassembled; dropping fragment", "peer", src)
+		return false
+	}
+	return true
+}
+
+// track adds a chunked message to the pending messages
+func (c *chunker) track(key string, r *reassembly) {
+	c.pending[key] = r
+	if r.local {
+		return
+	}
+
+	c.remote++
+	c.byFrom[r.from]++
+	c.byPeer[r.peer]++
+}
+
+// untrack removes a chunked message from the pending messages
+func (c *chunker) untrack(key string, r *reassembly) {
+	delete(c.pending, key)
+	if r.local {
+		return
+	}
+
+	c.remote--
+	if c.byFrom[r.from]--; c.byFrom[r.from] == 0 {
+		delete(c.byFrom, r.from)
+	}
+	if c.byPeer[r.peer]--; c.byPeer[r.peer] == 0 {
+		delete(c.byPeer, r.peer)
+	}
+}
+
+// expire drops the chunked messages that have not been reassembled in time
+func (c *chunker) expire(now time.Time) {
+	for key, r := range c.pending {
+		if now.After(r.deadline) {
+			c.logger.Debugw("dropping incomplete chunked message",
+				"topic", strings.Join(r.topics, ","), "received", len(r.frags), "total", r.total)
+			c.untrack(key, r)
+		}
+	}
+}
+
+func sameTopics(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// chunkMessage splits the data of a message that is about to be published in fragments,
+// if chunking is enabled and the data exceeds the chunk size.
+// The fragments must be signed individually.
+func (p *PubSub) chunkMessage(m *pb.Message) ([]*pb.Message, error) {
+	c := p.chunker
+	if c == nil || len(m.Data) <= c.chunkSize {
+		return []*pb.Message{m}, nil
+	}
+
+	if len(m.Data) > c.maxSize {
+		return nil, ErrMessageTooLarge
+	}
+
+	chunks := c.split(m.Data)
+	total := uint32(len(chunks))
+	msgs := make([]*pb.Message, 0, len(chunks))
+	for i, chunk := range chunks {
+		fm := *m
+		fm.Data = chunk
+		seqno, err := p.nextSeqno()
+		if err != nil {
+			return nil, err
+		}
+		fm.Seqno = seqno
+		fm.Fragment = &pb.Fragment{
+			Id:    m.Seqno,
+			Index: proto.Uint32(uint32(i)),
+			Total: proto.Uint32(total),
+		}
+		msgs = append(msgs, &fm)
+	}
+
+	return msgs, nil
+}
+
+// isFragment returns whether a message is a fragment that must be reassembled before
+// validation and delivery.
+func (p *PubSub) isFragment(msg *Message) bool {
+	return p.chunker != nil && msg.Fragment != nil
+}
+
+// reassemble adds a validated fragment to its chunked message, and pushes the reassembled
+// message in the validation pipeline once complete.
+// Only called from processLoop.
+func (p *PubSub) reassemble(msg *Message) {
+	full := p.chunker.add(msg, msg.ReceivedFrom == p.host.ID())
+	if full == nil {
+		return
+	}
+
+	if p.val.Push(full.ReceivedFrom, full) {
+		p.publishMessage(full)
+	}
+}
+
+// expireChunks periodically drops the chunked messages that have not been reassembled in time
+func (p *PubSub) expireChunks() {
+	interval := p.chunker.timeout / 2
+	if interval <= 0 {
+		interval = p.chunker.timeout
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			select {
+			case p.eval <- func() { p.chunker.expire(time.Now()) }:
+			case <-p.done:
+				return
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
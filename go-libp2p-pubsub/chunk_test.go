@@ -0,0 +1,275 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func makeData(t *testing.T, size int) []byte {
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 3)
+	psubs := getPubsubs(ctx, hosts[:2], WithMaxMessageSize(4096))
+	psubs = append(psubs, getPubsub(ctx, hosts[2]))
+
+	var subs []*Subscription
+	for _, ps := range psubs {
+		sub, err := ps.Subscribe("foobar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		subs = append(subs, sub)
+	}
+
+	connect(t, hosts[0], hosts[1])
+	time.Sleep(time.Millisecond * 100)
+
+	err := psubs[0].Publish("foobar", makeData(t, 8192))
+	if err != ErrMessageTooLarge {
+		t.Fatalf("expected ErrMessageTooLarge, got %v", err)
+	}
+
+	data := makeData(t, 2048)
+	err = psubs[0].Publish("foobar", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertReceive(t, subs[0], data)
+	assertReceive(t, subs[1], data)
+
+	// the default limit applies to both reading and publishing
+	err = psubs[2].Publish("foobar", makeData(t, DefaultMaxMessageSize+1))
+	if err != ErrMessageTooLarge {
+		t.Fatalf("expected ErrMessageTooLarge, got %v", err)
+	}
+
+	data = makeData(t, DefaultMaxMessageSize/2)
+	err = psubs[2].Publish("foobar", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertReceive(t, subs[2], data)
+}
+
+func TestMessageChunking(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 3)
+	psubs := getPubsubs(ctx, hosts, WithMaxMessageSize(4096), WithMessageChunking(2048, 1<<16, time.Second))
+
+	var subs []*Subscription
+	for _, ps := range psubs {
+		sub, err := ps.Subscribe("foobar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		subs = append(subs, sub)
+	}
+
+	// validators see the reassembled message
+	err := psubs[1].RegisterTopicValidator("foobar", func(ctx context.Context, p peer.ID, msg *Message) bool {
+		return len(msg.GetData()) == 10000
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 0 - 1 - 2; fragments are forwarded by host 1
+	connect(t, hosts[0], hosts[1])
+	connect(t, hosts[1], hosts[2])
+	time.Sleep(time.Millisecond * 100)
+
+	data := makeData(t, 10000)
+	err = psubs[0].Publish("foobar", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sub := range subs {
+		assertReceive(t, sub, data)
+		assertNoReceive(t, ctx, sub)
+	}
+
+	// a chunked message rejected by validation is not forwarded
+	err = psubs[1].RegisterTopicValidator("barfoo", func(ctx context.Context, p peer.ID, msg *Message) bool {
+		return false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var bsubs []*Subscription
+	for _, ps := range psubs {
+		sub, err := ps.Subscribe("barfoo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		bsubs = append(bsubs, sub)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	err = psubs[0].Publish("barfoo", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertReceive(t, bsubs[0], data)
+	assertNoReceive(t, ctx, bsubs[1])
+	assertNoReceive(t, ctx, bsubs[2])
+
+	// messages exceeding the max chunked message size can't be published
+	err = psubs[0].Publish("foobar", makeData(t, 1<<16+1))
+	if err != ErrMessageTooLarge {
+		t.Fatalf("expected ErrMessageTooLarge, got %v", err)
+	}
+}
+
+func TestChunkReassembly(t *testing.T) {
+	c := &chunker{
+		chunkSize: 4,
+		maxSize:   16,
+		timeout:   time.Second,
+		pending:   make(map[string]*reassembly),
+		byFrom:    make(map[string]int),
+		byPeer:    make(map[peer.ID]int),
+		logger:    newGoLogger(log),
+	}
+
+	fragment := func(id string, idx, total uint32, data string) *Message {
+		return &Message{
+			Message: &pb.Message{
+				From:     []byte("a"),
+				Data:     []byte(data),
+				TopicIDs: []string{"foobar"},
+				Fragment: &pb.Fragment{Id: []byte(id), Index: &idx, Total: &total},
+			},
+		}
+	}
+
+	chunks := c.split([]byte("0123456789"))
+	if len(chunks) != 3 || string(chunks[0]) != "0123" || string(chunks[2]) != "89" {
+		t.Fatalf("unexpected chunks: %q", chunks)
+	}
+
+	// fragments can arrive in any order
+	if c.add(fragment("1", 2, 3, "89"), false) != nil {
+		t.Fatal("unexpected reassembly")
+	}
+	if c.add(fragment("1", 0, 3, "0123"), false) != nil {
+		t.Fatal("unexpected reassembly")
+	}
+	msg := c.add(fragment("1", 1, 3, "4567"), false)
+	if msg == nil {
+		t.Fatal("expected reassembly")
+	}
+	if !bytes.Equal(msg.GetData(), []byte("0123456789")) || string(msg.GetSeqno()) != "1" || len(msg.fragments) != 3 {
+		t.Fatal("unexpected reassembled message")
+	}
+
+	// invalid and oversized messages are dropped
+	if c.add(fragment("2", 3, 3, "0123"), false) != nil {
+		t.Fatal("unexpected reassembly")
+	}
+	for i := uint32(0); i < 4; i++ {
+		c.add(fragment("3", i, 5, "0123"), false)
+	}
+	if c.add(fragment("3", 4, 5, "0"), false) != nil {
+		t.Fatal("unexpected reassembly of oversized message")
+	}
+
+	// incomplete messages expire
+	c.add(fragment("4", 0, 2, "0123"), false)
+	if len(c.pending) != 1 {
+		t.Fatalf("expected 1 pending message, got %d", len(c.pending))
+	}
+	c.expire(time.Now())
+	if len(c.pending) != 1 {
+		t.Fatal("unexpected expiration")
+	}
+	c.expire(time.Now().Add(2 * time.Second))
+	if len(c.pending) != 0 {
+		t.Fatal("expected incomplete message to expire")
+	}
+}
+
+func TestChunkReassemblyLimits(t *testing.T) {
+	c := &chunker{
+		chunkSize: 4,
+		maxSize:   16,
+		timeout:   time.Second,
+		pending:   make(map[string]*reassembly),
+		byFrom:    make(map[string]int),
+		byPeer:    make(map[peer.ID]int),
+		logger:    newGoLogger(log),
+	}
+
+	fragment := func(from string, src peer.ID, id string) *Message {
+		idx, total := uint32(0), uint32(2)
+		return &Message{
+			Message: &pb.Message{
+				From:     []byte(from),
+				Data:     []byte("0123"),
+				TopicIDs: []string{"foobar"},
+				Fragment: &pb.Fragment{Id: []byte(id), Index: &idx, Total: &total},
+			},
+			ReceivedFrom: src,
+		}
+	}
+
+	// the chunked messages of a publisher are limited
+	for i := 0; i < maxPublisherReassemblies+1; i++ {
+		c.add(fragment("a", "p1", string(rune('A'+i))), false)
+	}
+	if c.byFrom["a"] != maxPublisherReassemblies {
+		t.Fatalf("expected %d pending messages from a, got %d", maxPublisherReassemblies, c.byFrom["a"])
+	}
+
+	// the chunked messages first sent by a peer are limited
+	for i := 0; i < maxPeerReassemblies; i++ {
+		c.add(fragment(string(rune('b'+i)), "p1", "A"), false)
+	}
+	if c.byPeer["p1"] != maxPeerReassemblies {
+		t.Fatalf("expected %d pending messages from p1, got %d", maxPeerReassemblies, c.byPeer["p1"])
+	}
+
+	// our own chunked messages are not limited
+	for i := 0; i < maxPublisherReassemblies+1; i++ {
+		c.add(fragment("a", "self", string(rune('a'+i))), true)
+	}
+	if len(c.pending) != maxPeerReassemblies+maxPublisherReassemblies+1 || c.remote != maxPeerReassemblies {
+		t.Fatalf("unexpected pending messages: %d, %d remote", len(c.pending), c.remote)
+	}
+
+	c.expire(time.Now().Add(2 * time.Second))
+	if len(c.pending) != 0 || c.remote != 0 || len(c.byFrom) != 0 || len(c.byPeer) != 0 {
+		t.Fatal("expected the pending messages to expire")
+	}
+
+	// empty fragments can't hold a reassembly pending, whatever total they announce
+	for _, total := range []uint32{uint32(c.maxSize), 1 << 30} {
+		msg := fragment("e", "p2", "E")
+		msg.Data = nil
+		msg.Fragment.Total = &total
+		if c.add(msg, false) != nil || len(c.pending) != 0 {
+			t.Fatalf("expected an empty fragment with total %d to be dropped", total)
+		}
+	}
+}
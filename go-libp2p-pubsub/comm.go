@@ -9,9 +9,9 @@ import (
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
 
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
 	ggio "github.com/gogo/protobuf/io"
 	proto "github.com/gogo/protobuf/proto"
-	pb "github.com/libp2p/go-libp2p-pubsub/pb"
 
 	ms "github.com/multiformats/go-multistream"
 )
@@ -19,7 +19,19 @@ import (
 // get the initial RPC containing all of our subscriptions to send to new peers
 func (p *PubSub) getHelloPacket() *RPC {
 	var rpc RPC
-	for t := range p.myTopics {
+	for t := range p.mySubs {
+		as := &pb.RPC_SubOpts{
+			Topicid:   proto.String(t),
+			Subscribe: proto.Bool(true),
+		}
+		rpc.Subscriptions = append(rpc.Subscriptions, as)
+	}
+
+	for t := range p.myRelays {
+		if _, ok := p.mySubs[t]; ok {
+			continue
+		}
+
 		as := &pb.RPC_SubOpts{
 			Topicid:   proto.String(t),
 			Subscribe: proto.Bool(true),
@@ -30,14 +42,14 @@ func (p *PubSub) getHelloPacket() *RPC {
 }
 
 func (p *PubSub) handleNewStream(s network.Stream) {
-	r := ggio.NewDelimitedReader(s, 1<<20)
+	r := ggio.NewDelimitedReader(p.streamReader(s), p.maxMessageSize)
 	for {
 		rpc := new(RPC)
 		err := r.ReadMsg(&rpc.RPC)
 		if err != nil {
 			if err != io.EOF {
 				s.Reset()
-				log.Infof("error reading rpc from %s: %s", s.Conn().RemotePeer(), err)
+				p.logger.Infow("error reading rpc", "peer", s.Conn().RemotePeer(), "error", err)
 			} else {
 				// Just be nice. They probably won't read this
 				// but it doesn't hurt to send it.
@@ -57,10 +69,10 @@ func (p *PubSub) handleNewStream(s network.Stream) {
 	}
 }
 
-func (p *PubSub) handleNewPeer(ctx context.Context, pid peer.ID, outgoing <-chan *RPC) {
-	s, err := p.host.NewStream(p.ctx, pid, p.rt.Protocols()...)
+func (p *PubSub) handleNewPeer(ctx context.Context, pid peer.ID, outgoing *rpcQueue) {
+	s, err := p.host.NewStream(p.ctx, pid, p.protocols()...)
 	if err != nil {
-		log.Warning("opening new stream to peer: ", err, pid)
+		p.logger.Warnw("error opening new stream to peer", "peer", pid, "error", err)
 
 		var ch chan peer.ID
 		if err == ms.ErrNotSupported {
@@ -69,6 +81,8 @@ func (p *PubSub) handleNewPeer(ctx context.Context, pid peer.ID, outgoing <-chan
 			ch = p.peerDead
 		}
 
+		p.writers.Done()
+
 		select {
 		case ch <- pid:
 		case <-ctx.Done():
@@ -85,7 +99,7 @@ func (p *PubSub) handleNewPeer(ctx context.Context, pid peer.ID, outgoing <-chan
 }
 
 func (p *PubSub) handlePeerEOF(ctx context.Context, s network.Stream) {
-	r := ggio.NewDelimitedReader(s, 1<<20)
+	r := ggio.NewDelimitedReader(p.streamReader(s), p.maxMessageSize)
 	rpc := new(RPC)
 	for {
 		err := r.ReadMsg(&rpc.RPC)
@@ -96,43 +110,163 @@ func (p *PubSub) handlePeerEOF(ctx context.Context, s network.Stream) {
 			}
 			return
 		}
-		log.Warning("unexpected message from ", s.Conn().RemotePeer())
+		p.logger.Warnw("unexpected message on outbound stream", "peer", s.Conn().RemotePeer())
+	}
+}
+
+// flushWriter is a buffered writer for a stream
+type flushWriter interface {
+	io.Writer
+	Flush() error
+}
+
+// streamReader returns the reader for the RPCs of a stream, decompressing compressed streams.
+func (p *PubSub) streamReader(s network.Stream) io.Reader {
+	if p.compression != nil && isCompressedProtocol(s.Protocol()) {
+		return newCompressedReader(s, s.Conn().RemotePeer(), p.compression)
 	}
+	return s
 }
 
-func (p *PubSub) handleSendingMessages(ctx context.Context, s network.Stream, outgoing <-chan *RPC) {
-	bufw := bufio.NewWriter(s)
+// streamWriter returns the writer for the RPCs of a stream, compressing compressed streams.
+func (p *PubSub) streamWriter(s network.Stream) flushWriter {
+	if p.compression != nil && isCompressedProtocol(s.Protocol()) {
+		return newCompressedWriter(s, s.Conn().RemotePeer(), p.compression)
+	}
+	return bufio.NewWriter(s)
+}
+
+func (p *PubSub) handleSendingMessages(ctx context.Context, s network.Stream, outgoing *rpcQueue) {
+	bufw := p.streamWriter(s)
 	wc := ggio.NewDelimitedWriter(bufw)
 
-	writeMsg := func(msg proto.Message) error {
-		err := wc.WriteMsg(msg)
-		if err != nil {
-			return err
+	writeRPC := func(rpc *RPC) error {
+		for _, out := range p.splitRPC(rpc) {
+			err := wc.WriteMsg(&out.RPC)
+			if err != nil {
+				return err
+			}
 		}
 
 		return bufw.Flush()
 	}
 
+	// the batch budget can't exceed the size of the RPCs our peers accept
+	maxBytes := p.batchMaxBytes
+	if maxBytes <= 0 || maxBytes > p.maxMessageSize {
+		maxBytes = p.maxMessageSize
+	}
+
+	defer p.writers.Done()
 	defer helpers.FullClose(s)
+
+	// next is an RPC popped from the queue that didn't fit in the previous batch
+	var next *RPC
 	for {
-		select {
-		case rpc, ok := <-outgoing:
+		rpc := next
+		next = nil
+		if rpc == nil {
+			var ok bool
+			rpc, ok = outgoing.Pop(ctx)
 			if !ok {
 				return
 			}
+		}
 
-			err := writeMsg(&rpc.RPC)
-			if err != nil {
-				s.Reset()
-				log.Infof("writing message to %s: %s", s.Conn().RemotePeer(), err)
-				return
+		// coalesce whatever else is queued, within the batch budget
+		batch := []*RPC{rpc}
+		size := rpc.Size()
+		for len(batch) < p.batchMaxRPCs {
+			more, ok := outgoing.TryPop()
+			if !ok {
+				break
 			}
-		case <-ctx.Done():
+
+			msize := more.Size()
+			if size+msize > maxBytes {
+				next = more
+				break
+			}
+
+			batch = append(batch, more)
+			size += msize
+		}
+
+		err := writeRPC(mergeRPCs(batch))
+		if err != nil {
+			s.Reset()
+			p.logger.Infow("error writing rpc", "peer", s.Conn().RemotePeer(), "error", err)
 			return
 		}
 	}
 }
 
+// mergeRPCs merges a batch of RPCs into a single RPC, concatenating their subscriptions,
+// messages and control messages. The RPCs in the batch are not modified, as they may be
+// shared with other peers.
+func mergeRPCs(batch []*RPC) *RPC {
+	if len(batch) == 1 {
+		return batch[0]
+	}
+
+	out := new(RPC)
+	var ctl *pb.ControlMessage
+	for _, rpc := range batch {
+		out.Subscriptions = append(out.Subscriptions, rpc.Subscriptions...)
+		out.Publish = append(out.Publish, rpc.Publish...)
+
+		if rpc.Control == nil {
+			continue
+		}
+
+		if ctl == nil {
+			ctl = new(pb.ControlMessage)
+		}
+		ctl.Ihave = append(ctl.Ihave, rpc.Control.Ihave...)
+		ctl.Iwant = append(ctl.Iwant, rpc.Control.Iwant...)
+		ctl.Graft = append(ctl.Graft, rpc.Control.Graft...)
+		ctl.Prune = append(ctl.Prune, rpc.Control.Prune...)
+		if rpc.Control.PeerRecord != nil {
+			ctl.PeerRecord = rpc.Control.PeerRecord
+		}
+	}
+	out.Control = ctl
+
+	return out
+}
+
+// splitRPC splits an RPC that exceeds the maximum message size, which would reset the stream
+// on the receiving side, into RPCs with the subscriptions, the control messages and each of the
+// messages. Parts that exceed the maximum size on their own are dropped.
+func (p *PubSub) splitRPC(rpc *RPC) []*RPC {
+	limit := p.maxMessageSize
+	if rpc.Size() <= limit {
+		return []*RPC{rpc}
+	}
+
+	var parts []*RPC
+	if len(rpc.Subscriptions) > 0 {
+		parts = append(parts, rpcWithSubs(rpc.Subscriptions...))
+	}
+	for _, msg := range rpc.Publish {
+		parts = append(parts, rpcWithMessages(msg))
+	}
+	if rpc.Control != nil {
+		parts = append(parts, &RPC{RPC: pb.RPC{Control: rpc.Control}})
+	}
+
+	res := parts[:0]
+	for _, part := range parts {
+		if part.Size() > limit {
+			p.logger.Warnw("dropping RPC part exceeding the max message size", "size", part.Size())
+			continue
+		}
+		res = append(res, part)
+	}
+
+	return res
+}
+
 func rpcWithSubs(subs ...*pb.RPC_SubOpts) *RPC {
 	return &RPC{
 		RPC: pb.RPC{
@@ -0,0 +1,183 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
+
+	libp2p "github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestMergeRPCs(t *testing.T) {
+	topic := "foobar"
+	yes := true
+
+	sub := rpcWithSubs(&pb.RPC_SubOpts{Topicid: &topic, Subscribe: &yes})
+	msgs := rpcWithMessages(&pb.Message{Data: []byte("a")}, &pb.Message{Data: []byte("b")})
+	graft := rpcWithControl(nil, nil, nil, []*pb.ControlGraft{{TopicID: &topic}}, nil)
+	gossip := rpcWithControl([]*pb.Message{{Data: []byte("c")}},
+		[]*pb.ControlIHave{{TopicID: &topic, MessageIDs: []string{"x"}}},
+		[]*pb.ControlIWant{{MessageIDs: []string{"y"}}},
+		nil,
+		[]*pb.ControlPrune{{TopicID: &topic}})
+
+	batch := []*RPC{sub, msgs, graft, gossip}
+	size := 0
+	for _, rpc := range batch {
+		size += rpc.Size()
+	}
+
+	out := mergeRPCs(batch)
+	if len(out.Subscriptions) != 1 || len(out.Publish) != 3 {
+		t.Fatalf("unexpected merged RPC: %s", out.String())
+	}
+	if string(out.Publish[0].Data) != "a" || string(out.Publish[2].Data) != "c" {
+		t.Fatal("expected messages to retain their order")
+	}
+
+	ctl := out.GetControl()
+	if len(ctl.Ihave) != 1 || len(ctl.Iwant) != 1 || len(ctl.Graft) != 1 || len(ctl.Prune) != 1 {
+		t.Fatalf("unexpected merged control: %s", ctl.String())
+	}
+
+	// the merged RPC is no larger than the batch
+	if out.Size() > size {
+		t.Fatalf("merged RPC size %d exceeds the batch size %d", out.Size(), size)
+	}
+
+	// the batch is not modified
+	if len(msgs.Publish) != 2 || graft.Control.Ihave != nil {
+		t.Fatal("expected the batched RPCs to be left unmodified")
+	}
+
+	if mergeRPCs([]*RPC{msgs}) != msgs {
+		t.Fatal("expected a single RPC to be returned as is")
+	}
+}
+
+func TestRPCBatching(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// signing is disabled, as bursts of signed messages are throttled by validation
+	tracer := newTestTracer()
+	hosts := getNetHosts(t, ctx, 2)
+	psubs := []*PubSub{
+		getPubsub(ctx, hosts[0],
+			WithMessageSigning(false),
+			WithRPCBatching(16, 4096),
+			WithOutboundQueue(PriorityPublish, 1024, DropNewest)),
+		getPubsub(ctx, hosts[1], WithMessageSigning(false), WithEventTracer(tracer)),
+	}
+
+	_, err := psubs[1].Subscribe("foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connect(t, hosts[0], hosts[1])
+	time.Sleep(time.Millisecond * 100)
+
+	// publish a burst, with messages that don't all fit in the byte budget
+	count := 1000
+	rpcs := tracer.count(pb.TraceEvent_RECV_RPC)
+	for i := 0; i < count; i++ {
+		err := psubs[0].Publish("foobar", []byte(fmt.Sprintf("message %d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !waitDelivered(tracer, count, time.Second*10) {
+		t.Fatalf("expected %d messages, got %d", count, tracer.count(pb.TraceEvent_DELIVER_MESSAGE))
+	}
+
+	rpcs = tracer.count(pb.TraceEvent_RECV_RPC) - rpcs
+	if rpcs >= count {
+		t.Fatalf("expected messages to be coalesced; received %d RPCs for %d messages", rpcs, count)
+	}
+}
+
+// waitDelivered waits for a number of messages to be delivered, as reported by a tracer.
+// Subscriptions drop messages when their buffer is full, which happens with bursts.
+func waitDelivered(tracer *testTracer, count int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for tracer.count(pb.TraceEvent_DELIVER_MESSAGE) < count {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
+
+func BenchmarkRPCBatching(b *testing.B) {
+	for _, bc := range []struct {
+		name    string
+		maxRPCs int
+	}{
+		{"unbatched", 1},
+		{"batched", DefaultBatchMaxRPCs},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			benchmarkPublishBurst(b, WithRPCBatching(bc.maxRPCs, 0))
+		})
+	}
+}
+
+// benchmarkPublishBurst measures the throughput of publishing small messages to a peer in a
+// burst, until they are all delivered; signing is disabled so that the benchmark is dominated
+// by the wire.
+func benchmarkPublishBurst(b *testing.B, opt Option) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tracer := newTestTracer()
+	var psubs []*PubSub
+	for i, opts := range [][]Option{
+		{opt, WithOutboundQueue(PriorityPublish, b.N, DropNewest)},
+		{WithEventTracer(tracer)},
+	} {
+		h, err := libp2p.New(ctx, libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		ps, err := NewFloodSub(ctx, h, append(opts, WithMessageSigning(false))...)
+		if err != nil {
+			b.Fatal(err)
+		}
+		psubs = append(psubs, ps)
+
+		if i > 0 {
+			a := psubs[0].host
+			err = h.Connect(ctx, peer.AddrInfo{ID: a.ID(), Addrs: a.Addrs()})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	_, err := psubs[1].Subscribe("foobar")
+	if err != nil {
+		b.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	data := make([]byte, 64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := psubs[0].Publish("foobar", data)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	if !waitDelivered(tracer, b.N, time.Second*30) {
+		b.Fatalf("expected %d messages, got %d", b.N, tracer.count(pb.TraceEvent_DELIVER_MESSAGE))
+	}
+}
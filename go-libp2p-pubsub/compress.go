@@ -0,0 +1,218 @@
+package pubsub
+
+import (
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+
+	"github.com/golang/snappy"
+)
+
+// CompressedProtocolSuffix is appended to the router protocol IDs to form the IDs of the
+// compressed protocols, where RPCs are framed in a snappy stream.
+const CompressedProtocolSuffix = "/snappy"
+
+// WithCompression is an option to enable snappy compression of our streams.
+// Compression is negotiated per stream with a distinct protocol ID for each router protocol,
+// which we prefer when opening streams, so peers without compression keep working. Compression
+// is applied to the framed RPCs, so message signatures cover the uncompressed messages.
+func WithCompression(enabled bool) Option {
+	return func(p *PubSub) error {
+		if enabled {
+			p.compression = newCompressionTracker()
+		} else {
+			p.compression = nil
+		}
+		return nil
+	}
+}
+
+// CompressionStats are the traffic counters of the compressed streams with a peer.
+type CompressionStats struct {
+	// BytesIn and BytesOut count the uncompressed bytes read and written
+	BytesIn, BytesOut uint64
+	// WireBytesIn and WireBytesOut count the compressed bytes read and written
+	WireBytesIn, WireBytesOut uint64
+}
+
+// RatioIn returns the compression ratio of the inbound traffic, as the uncompressed
+// size over the compressed size.
+func (s CompressionStats) RatioIn() float64 {
+	return ratio(s.BytesIn, s.WireBytesIn)
+}
+
+// RatioOut returns the compression ratio of the outbound traffic, as the uncompressed
+// size over the compressed size.
+func (s CompressionStats) RatioOut() float64 {
+	return ratio(s.BytesOut, s.WireBytesOut)
+}
+
+func ratio(raw, wire uint64) float64 {
+	if wire == 0 {
+		return 0
+	}
+	return float64(raw) / float64(wire)
+}
+
+// CompressionStats returns the traffic counters of the compressed streams with our peers.
+// Peers are removed when they disconnect; the result is empty when compression is disabled.
+func (p *PubSub) CompressionStats() map[peer.ID]CompressionStats {
+	if p.compression == nil {
+		return map[peer.ID]CompressionStats{}
+	}
+
+	return p.compression.snapshot()
+}
+
+// compressionTracker tracks the traffic counters of the compressed streams; the counters
+// are updated by the stream reader and writer goroutines.
+type compressionTracker struct {
+	mx    sync.Mutex
+	stats map[peer.ID]*CompressionStats
+}
+
+func newCompressionTracker() *compressionTracker {
+	return &compressionTracker{stats: make(map[peer.ID]*CompressionStats)}
+}
+
+func (c *compressionTracker) add(p peer.ID, in, wireIn, out, wireOut int) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	s, ok := c.stats[p]
+	if !ok {
+		s = new(CompressionStats)
+		c.stats[p] = s
+	}
+
+	s.BytesIn += uint64(in)
+	s.WireBytesIn += uint64(wireIn)
+	s.BytesOut += uint64(out)
+	s.WireBytesOut += uint64(wireOut)
+}
+
+func (c *compressionTracker) remove(p peer.ID) {
+	c.mx.Lock()
+	delete(c.stats, p)
+	c.mx.Unlock()
+}
+
+func (c *compressionTracker) snapshot() map[peer.ID]CompressionStats {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	res := make(map[peer.ID]CompressionStats, len(c.stats))
+	for p, s := range c.stats {
+		res[p] = *s
+	}
+	return res
+}
+
+// protocols returns the protocol IDs we speak, with the compressed protocols first
+// when compression is enabled.
+func (p *PubSub) protocols() []protocol.ID {
+	protos := p.rt.Protocols()
+	if p.compression == nil {
+		return protos
+	}
+
+	res := make([]protocol.ID, 0, 2*len(protos))
+	for _, id := range protos {
+		res = append(res, id+CompressedProtocolSuffix)
+	}
+	return append(res, protos...)
+}
+
+// isCompressedProtocol returns whether a protocol ID is the ID of a compressed protocol.
+func isCompressedProtocol(id protocol.ID) bool {
+	return strings.HasSuffix(string(id), CompressedProtocolSuffix)
+}
+
+// baseProtocol returns the router protocol ID of a possibly compressed protocol.
+func baseProtocol(id protocol.ID) protocol.ID {
+	return protocol.ID(strings.TrimSuffix(string(id), CompressedProtocolSuffix))
+}
+
+// countingReader counts the bytes read from a reader
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += n
+	return n, err
+}
+
+// countingWriter counts the bytes written to a writer
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += n
+	return n, err
+}
+
+// compressedReader decompresses a stream and reports the traffic counters of each read.
+type compressedReader struct {
+	wire *countingReader
+	raw  *countingReader
+	peer peer.ID
+	c    *compressionTracker
+}
+
+func newCompressedReader(r io.Reader, pid peer.ID, c *compressionTracker) *compressedReader {
+	wire := &countingReader{r: r}
+	return &compressedReader{
+		wire: wire,
+		raw:  &countingReader{r: snappy.NewReader(wire)},
+		peer: pid,
+		c:    c,
+	}
+}
+
+func (r *compressedReader) Read(b []byte) (int, error) {
+	n, err := r.raw.Read(b)
+	r.c.add(r.peer, r.raw.n, r.wire.n, 0, 0)
+	r.raw.n, r.wire.n = 0, 0
+	return n, err
+}
+
+// compressedWriter compresses a stream and reports the traffic counters of each flush.
+type compressedWriter struct {
+	wire *countingWriter
+	sw   *snappy.Writer
+	raw  int
+	peer peer.ID
+	c    *compressionTracker
+}
+
+func newCompressedWriter(w io.Writer, pid peer.ID, c *compressionTracker) *compressedWriter {
+	wire := &countingWriter{w: w}
+	return &compressedWriter{
+		wire: wire,
+		sw:   snappy.NewBufferedWriter(wire),
+		peer: pid,
+		c:    c,
+	}
+}
+
+func (w *compressedWriter) Write(b []byte) (int, error) {
+	n, err := w.sw.Write(b)
+	w.raw += n
+	return n, err
+}
+
+func (w *compressedWriter) Flush() error {
+	err := w.sw.Flush()
+	w.c.add(w.peer, 0, 0, w.raw, w.wire.n)
+	w.raw, w.wire.n = 0, 0
+	return err
+}
@@ -0,0 +1,130 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// streamProtocols returns the protocols of the streams between two hosts
+func streamProtocols(a, b host.Host) []protocol.ID {
+	var res []protocol.ID
+	for _, c := range a.Network().ConnsToPeer(b.ID()) {
+		for _, s := range c.GetStreams() {
+			res = append(res, s.Protocol())
+		}
+	}
+	return res
+}
+
+func TestCompression(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 3)
+	psubs := getPubsubs(ctx, hosts[:2], WithCompression(true))
+	psubs = append(psubs, getPubsub(ctx, hosts[2]))
+
+	var subs []*Subscription
+	for _, ps := range psubs {
+		sub, err := ps.Subscribe("foobar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		subs = append(subs, sub)
+	}
+
+	// the peers are only connected through the first peer, so that messages between the
+	// compressing peers are not relayed by the peer without compression
+	connect(t, hosts[0], hosts[1])
+	connect(t, hosts[0], hosts[2])
+	time.Sleep(time.Millisecond * 100)
+
+	for _, id := range streamProtocols(hosts[0], hosts[1]) {
+		if id != FloodSubID+CompressedProtocolSuffix {
+			t.Fatalf("expected compressed stream between compressing peers, got %s", id)
+		}
+	}
+	for _, id := range streamProtocols(hosts[0], hosts[2]) {
+		if id != FloodSubID {
+			t.Fatalf("expected uncompressed stream with peer without compression, got %s", id)
+		}
+	}
+
+	data := bytes.Repeat([]byte(`{"key": "value", "counter": 12345}`), 300)
+	for i, ps := range psubs {
+		err := ps.Publish("foobar", data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, sub := range subs {
+			assertReceive(t, sub, data)
+		}
+
+		if i == 0 {
+			stats := psubs[0].CompressionStats()[hosts[1].ID()]
+			if stats.BytesOut <= stats.WireBytesOut || stats.RatioOut() <= 1 {
+				t.Fatalf("expected outbound traffic to be compressed; got %+v", stats)
+			}
+
+			stats = psubs[1].CompressionStats()[hosts[0].ID()]
+			if stats.BytesIn <= stats.WireBytesIn || stats.RatioIn() <= 1 {
+				t.Fatalf("expected inbound traffic to be compressed; got %+v", stats)
+			}
+		}
+	}
+
+	if _, ok := psubs[0].CompressionStats()[hosts[2].ID()]; ok {
+		t.Fatal("unexpected compression stats for peer without compression")
+	}
+	if len(psubs[2].CompressionStats()) != 0 {
+		t.Fatal("unexpected compression stats with compression disabled")
+	}
+}
+
+func TestGossipsubCompression(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 10)
+	psubs := getGossipsubs(ctx, hosts, WithCompression(true))
+
+	var subs []*Subscription
+	for _, ps := range psubs {
+		sub, err := ps.Subscribe("foobar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		subs = append(subs, sub)
+	}
+
+	denseConnect(t, hosts)
+
+	// wait for heartbeats to build mesh
+	time.Sleep(time.Second * 2)
+
+	for _, id := range streamProtocols(hosts[0], hosts[1]) {
+		if id != GossipSubID+CompressedProtocolSuffix {
+			t.Fatalf("expected compressed gossipsub stream, got %s", id)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		msg := []byte(fmt.Sprintf("%d it's not a floooooood %d", i, i))
+
+		err := psubs[i].Publish("foobar", msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, sub := range subs {
+			assertReceive(t, sub, msg)
+		}
+	}
+}
@@ -19,9 +19,12 @@
 // to your peers; the implementation relies on ambient peer discovery, leaving bootstrap
 // and active peer discovery up to the client.
 //
-// To publish a message to some topic, use Publish; you don't need to be subscribed
+// To interact with a topic, use Join; this will give you a Topic handle from which
+// you can publish, subscribe, list peers and register validators for the topic.
+//
+// To publish a message to some topic, use Topic.Publish; you don't need to be subscribed
 // to the topic in order to publish.
 //
-// To subscribe to a topic, use Subscribe; this will give you a subscription interface
+// To subscribe to a topic, use Topic.Subscribe; this will give you a subscription interface
 // from which new messages can be pumped.
 package pubsub
@@ -0,0 +1,213 @@
+package pubsub
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
+)
+
+// SharedKeySize is the size of the shared keys used for topic encryption; the payload
+// is encrypted with AES-256-GCM.
+const SharedKeySize = 32
+
+// SharedKeyHashPrefix is the salt prefix of the shared key hashes; the topic name is
+// appended to it, so that the same key has different hashes in different topics.
+const SharedKeyHashPrefix = "libp2p-pubsub-shared-key:"
+
+var (
+	errUnknownSharedKey = errors.New("unknown shared key")
+	errNoSharedKey      = errors.New("no shared key")
+)
+
+// SharedKeyHash computes the salted hash that identifies a shared key in a topic,
+// as listed in the EncOpts.KeyHashes of the topic descriptor.
+func SharedKeyHash(topic string, key []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(SharedKeyHashPrefix))
+	h.Write([]byte(topic))
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+// sharedKey is a shared key, with the AEAD for the key
+type sharedKey struct {
+	hash []byte
+	aead cipher.AEAD
+}
+
+// sharedKeyring holds the shared keys of a topic with SHAREDKEY encryption.
+// All keys in the ring are accepted for decryption, while the newest key is used
+// for encryption; this allows keys to be rotated without interrupting the topic.
+// The encrypted payload is the hash of the key, followed by the nonce and the sealed data;
+// the key hash is also authenticated as additional data.
+// Only keys whose hashes are listed in the topic descriptor can be set.
+type sharedKeyring struct {
+	topic   string
+	allowed [][]byte // key hashes listed in the topic descriptor
+
+	mx   sync.RWMutex
+	keys []*sharedKey // newest last
+}
+
+func newSharedKeyring(topic string, allowed [][]byte) *sharedKeyring {
+	return &sharedKeyring{topic: topic, allowed: allowed}
+}
+
+// isAllowed returns whether a key hash is listed in the topic descriptor
+func (r *sharedKeyring) isAllowed(hash []byte) bool {
+	for _, h := range r.allowed {
+		if bytes.Equal(h, hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// setKeys replaces the keys of the ring; the last key is the newest.
+func (r *sharedKeyring) setKeys(keys [][]byte) error {
+	skeys := make([]*sharedKey, 0, len(keys))
+	for _, key := range keys {
+		if len(key) != SharedKeySize {
+			return fmt.Errorf("invalid shared key size; must be %d bytes", SharedKeySize)
+		}
+
+		hash := SharedKeyHash(r.topic, key)
+		if !r.isAllowed(hash) {
+			return fmt.Errorf("shared key %x is not listed in the descriptor of topic %s", hash, r.topic)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return err
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return err
+		}
+
+		skeys = append(skeys, &sharedKey{hash: hash, aead: aead})
+	}
+
+	r.mx.Lock()
+	r.keys = skeys
+	r.mx.Unlock()
+
+	return nil
+}
+
+// encrypt encrypts data with the newest key
+func (r *sharedKeyring) encrypt(data []byte) ([]byte, error) {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	if len(r.keys) == 0 {
+		return nil, errNoSharedKey
+	}
+	key := r.keys[len(r.keys)-1]
+
+	nonce := make([]byte, key.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(key.hash)+len(nonce)+len(data)+key.aead.Overhead())
+	out = append(out, key.hash...)
+	out = append(out, nonce...)
+	return key.aead.Seal(out, nonce, data, key.hash), nil
+}
+
+// decrypt decrypts data with the key identified by the key hash in the payload
+func (r *sharedKeyring) decrypt(data []byte) ([]byte, error) {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	if len(data) < sha256.Size {
+		return nil, fmt.Errorf("encrypted payload too short")
+	}
+
+	hash := data[:sha256.Size]
+	for _, key := range r.keys {
+		if !bytes.Equal(hash, key.hash) {
+			continue
+		}
+
+		data = data[sha256.Size:]
+		if len(data) < key.aead.NonceSize() {
+			return nil, fmt.Errorf("encrypted payload too short")
+		}
+
+		nonce := data[:key.aead.NonceSize()]
+		return key.aead.Open(nil, nonce, data[key.aead.NonceSize():], hash)
+	}
+
+	return nil, errUnknownSharedKey
+}
+
+// decryptMessage decrypts the payload of a message published in topics with shared key encryption,
+// trying the keyrings of the topics in turn. On success, the decrypted message is attached
+// to the message for delivery to validators and subscribers, while the original message is
+// retained for forwarding.
+func decryptMessage(keyrings []*sharedKeyring, msg *Message) error {
+	err := errUnknownSharedKey
+	for _, r := range keyrings {
+		var data []byte
+		data, err = r.decrypt(msg.GetData())
+		if err == errUnknownSharedKey {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		xm := *msg.Message
+		xm.Data = data
+		msg.local = &Message{Message: &xm, ReceivedFrom: msg.ReceivedFrom}
+		return nil
+	}
+
+	return err
+}
+
+// SetKeys sets the shared keys of a topic with SHAREDKEY encryption, replacing any
+// previous keys. Messages encrypted with any of the keys are accepted, while the last
+// key is used to encrypt published messages. To rotate keys, add the new key after the
+// old one and drop the old key once all publishers have switched over.
+// The topic descriptor is authoritative: each key must have its hash listed in the
+// EncOpts.KeyHashes of the descriptor the topic was joined with.
+func (t *Topic) SetKeys(keys ...[]byte) error {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	if t.closed {
+		return ErrTopicClosed
+	}
+
+	if t.keys == nil {
+		return fmt.Errorf("topic %s does not use shared key encryption", t.topic)
+	}
+
+	return t.keys.setKeys(keys)
+}
+
+// newTopicKeyring creates the keyring for a topic descriptor.
+// It returns nil if the topic does not use encryption.
+func newTopicKeyring(td *pb.TopicDescriptor) (*sharedKeyring, error) {
+	switch td.GetEnc().GetMode() {
+	case pb.TopicDescriptor_EncOpts_NONE:
+		return nil, nil
+
+	case pb.TopicDescriptor_EncOpts_SHAREDKEY:
+		return newSharedKeyring(td.GetName(), td.GetEnc().GetKeyHashes()), nil
+
+	default:
+		return nil, fmt.Errorf("encryption mode %s not yet supported", td.GetEnc().GetMode())
+	}
+}
@@ -0,0 +1,205 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func sharedKeyTopicDescriptor(topic string, keys ...[]byte) *pb.TopicDescriptor {
+	mode := pb.TopicDescriptor_EncOpts_SHAREDKEY
+	var hashes [][]byte
+	for _, key := range keys {
+		hashes = append(hashes, SharedKeyHash(topic, key))
+	}
+	return &pb.TopicDescriptor{
+		Name: &topic,
+		Enc:  &pb.TopicDescriptor_EncOpts{Mode: &mode, KeyHashes: hashes},
+	}
+}
+
+func makeSharedKey(t *testing.T) []byte {
+	key := make([]byte, SharedKeySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func assertNoReceive(t *testing.T, ctx context.Context, sub *Subscription) {
+	ctx, cancel := context.WithTimeout(ctx, time.Millisecond*100)
+	defer cancel()
+	msg, err := sub.Next(ctx)
+	if err == nil {
+		t.Fatalf("unexpected message: %s", msg.GetData())
+	}
+}
+
+func TestSharedKeyEncryption(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 4)
+	psubs := getPubsubs(ctx, hosts)
+
+	k1 := makeSharedKey(t)
+	k2 := makeSharedKey(t)
+
+	// hosts 0 and 1 have the key, host 2 doesn't, and host 3 is unaware of the encryption
+	td := sharedKeyTopicDescriptor("foobar", k1, k2)
+	var topics []*Topic
+	var subs []*Subscription
+	for _, ps := range psubs[:3] {
+		topic, err := ps.JoinByTopicDescriptor(td)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sub, err := topic.Subscribe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		topics = append(topics, topic)
+		subs = append(subs, sub)
+	}
+
+	sub3, err := psubs[3].Subscribe("foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = topics[0].Publish(ctx, []byte("secret"))
+	if err == nil {
+		t.Fatal("expected publishing without a key to fail")
+	}
+
+	for _, topic := range topics[:2] {
+		err = topic.SetKeys(k1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// validators see the decrypted payload
+	err = psubs[1].RegisterTopicValidator("foobar", func(ctx context.Context, p peer.ID, msg *Message) bool {
+		return bytes.HasPrefix(msg.GetData(), []byte("secret"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connectAll(t, hosts)
+	time.Sleep(time.Millisecond * 100)
+
+	err = topics[0].Publish(ctx, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertReceive(t, subs[0], []byte("secret"))
+	assertReceive(t, subs[1], []byte("secret"))
+	assertNoReceive(t, ctx, subs[2])
+
+	// the message travels encrypted
+	select {
+	case msg := <-sub3.ch:
+		if bytes.Contains(msg.GetData(), []byte("secret")) {
+			t.Fatal("expected the payload to be encrypted")
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for encrypted message")
+	}
+
+	// rotate the key on the publisher; host 1 doesn't have the new key yet
+	err = topics[0].SetKeys(k1, k2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the descriptor keeps listing the key hashes it was joined with
+	hashes := topics[0].Descriptor().GetEnc().GetKeyHashes()
+	if len(hashes) != 2 || !bytes.Equal(hashes[0], SharedKeyHash("foobar", k1)) || !bytes.Equal(hashes[1], SharedKeyHash("foobar", k2)) {
+		t.Fatal("expected descriptor to list the hashes of the keys")
+	}
+
+	err = topics[0].Publish(ctx, []byte("secret2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertReceive(t, subs[0], []byte("secret2"))
+	assertNoReceive(t, ctx, subs[1])
+
+	// once the new key is distributed, messages with both keys are accepted
+	err = topics[1].SetKeys(k1, k2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = topics[0].Publish(ctx, []byte("secret3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertReceive(t, subs[0], []byte("secret3"))
+	assertReceive(t, subs[1], []byte("secret3"))
+
+	err = topics[1].SetKeys(k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = topics[1].Publish(ctx, []byte("secret4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertReceive(t, subs[0], []byte("secret4"))
+	assertReceive(t, subs[1], []byte("secret4"))
+}
+
+func TestSharedKeyInvalid(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 1)
+	ps := getPubsub(ctx, hosts[0])
+
+	key := makeSharedKey(t)
+	topic, err := ps.JoinByTopicDescriptor(sharedKeyTopicDescriptor("foobar", key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = topic.SetKeys([]byte("too short"))
+	if err == nil {
+		t.Fatal("expected an error for an invalid key")
+	}
+
+	// only keys listed in the descriptor can be set
+	err = topic.SetKeys(key, makeSharedKey(t))
+	if err == nil {
+		t.Fatal("expected an error for a key not listed in the descriptor")
+	}
+	err = topic.SetKeys(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ps.JoinByTopicDescriptor(sharedKeyTopicDescriptor("foobar", key, makeSharedKey(t)))
+	if err == nil {
+		t.Fatal("expected an error joining with different key hashes")
+	}
+
+	plain, err := ps.Join("barfoo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = plain.SetKeys(makeSharedKey(t))
+	if err == nil {
+		t.Fatal("expected an error for a topic without encryption")
+	}
+}
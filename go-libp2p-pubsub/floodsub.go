@@ -2,9 +2,8 @@ package pubsub
 
 import (
 	"context"
-	"fmt"
 
-	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
 
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/peer"
@@ -45,6 +44,10 @@ func (fs *FloodSubRouter) AddPeer(peer.ID, protocol.ID) {}
 
 func (fs *FloodSubRouter) RemovePeer(peer.ID) {}
 
+func (fs *FloodSubRouter) AcceptFrom(peer.ID) bool {
+	return true
+}
+
 func (fs *FloodSubRouter) HandleRPC(rpc *RPC) {}
 
 func (fs *FloodSubRouter) Publish(from peer.ID, msg *pb.Message) {
@@ -61,22 +64,20 @@ func (fs *FloodSubRouter) Publish(from peer.ID, msg *pb.Message) {
 	}
 
 	out := rpcWithMessages(msg)
+	class := fs.p.messageClass(from)
 	for pid := range tosend {
 		if pid == from || pid == peer.ID(msg.GetFrom()) {
 			continue
 		}
 
-		mch, ok := fs.p.peers[pid]
-		if !ok {
+		if _, ok := fs.p.peers[pid]; !ok {
 			continue
 		}
 
-		select {
-		case mch <- out:
-			fmt.Printf("%v flooding %v with msg %s\n", fs.p.host.ID(), pid, msg.Data)
-		default:
-			log.Infof("dropping message to peer %s: queue full", pid)
-			// Drop it. The peer is too slow.
+		// if the queue is full, the peer is too slow and the message is dropped
+		if fs.p.sendRPC(pid, out, class) != out {
+			fs.p.logger.Debugw("dropping message to slow peer", "peer", pid,
+				"topic", topicsField(msg.GetTopicIDs()), "msgid", fs.p.logMsgID(msg))
 		}
 	}
 }
@@ -84,3 +85,7 @@ func (fs *FloodSubRouter) Publish(from peer.ID, msg *pb.Message) {
 func (fs *FloodSubRouter) Join(topic string) {}
 
 func (fs *FloodSubRouter) Leave(topic string) {}
+
+func (fs *FloodSubRouter) inspect(s *Snapshot) {
+	s.Router = "floodsub"
+}
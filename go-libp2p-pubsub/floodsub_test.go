@@ -254,7 +254,7 @@ func TestReconnects(t *testing.T) {
 		t.Fatal("timed out waiting for B chan to be closed")
 	}
 
-	nSubs := len(psubs[2].myTopics["cats"])
+	nSubs := len(psubs[2].mySubs["cats"])
 	if nSubs > 0 {
 		t.Fatal(`B should have 0 subscribers for channel "cats", has`, nSubs)
 	}
@@ -435,6 +435,99 @@ func TestValidate(t *testing.T) {
 	}
 }
 
+func TestValidateEx(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 2)
+
+	var penaltyMx sync.Mutex
+	penalties := make(map[peer.ID]int)
+	hook := func(p peer.ID, msg *Message) {
+		penaltyMx.Lock()
+		defer penaltyMx.Unlock()
+		penalties[p]++
+	}
+
+	psubs := []*PubSub{
+		getPubsub(ctx, hosts[0]),
+		getPubsub(ctx, hosts[1], WithPeerPenaltyHook(hook)),
+	}
+
+	connect(t, hosts[0], hosts[1])
+	topic := "foobar"
+
+	err := psubs[1].RegisterTopicValidator(topic, func(ctx context.Context, from peer.ID, msg *Message) ValidationResult {
+		switch {
+		case bytes.Contains(msg.Data, []byte("illegal")):
+			return ValidationReject
+		case bytes.Contains(msg.Data, []byte("stale")):
+			return ValidationIgnore
+		default:
+			return ValidationAccept
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := psubs[1].Subscribe(topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Millisecond * 50)
+
+	msgs := []struct {
+		msg       []byte
+		validates bool
+	}{
+		{msg: []byte("this is a legal message"), validates: true},
+		{msg: []byte("this is a stale message"), validates: false},
+		{msg: []byte("openly illegal content will be censored"), validates: false},
+	}
+
+	for _, tc := range msgs {
+		err := psubs[0].Publish(topic, tc.msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case msg := <-sub.ch:
+			if !tc.validates {
+				t.Log(msg)
+				t.Error("expected message validation to filter out the message")
+			}
+		case <-time.After(333 * time.Millisecond):
+			if tc.validates {
+				t.Error("expected message validation to accept the message")
+			}
+		}
+	}
+
+	penaltyMx.Lock()
+	defer penaltyMx.Unlock()
+	if len(penalties) != 1 || penalties[hosts[0].ID()] != 1 {
+		t.Fatalf("expected exactly one penalty for %s, got %v", hosts[0].ID(), penalties)
+	}
+}
+
+func TestRegisterBogusValidator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 1)
+	psubs := getPubsubs(ctx, hosts)
+
+	err := psubs[0].RegisterTopicValidator("foo", func(context.Context, peer.ID, *Message) int {
+		return 0
+	})
+	if err == nil {
+		t.Fatal("registered validator of unknown type")
+	}
+}
+
 func TestValidateOverload(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -1398,3 +1491,78 @@ func readAllQueuedEvents(ctx context.Context, t *testing.T, sub *Subscription) m
 	}
 	return peerState
 }
+
+func TestPubSubClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 2)
+	psubs := getPubsubs(ctx, hosts)
+
+	var subs []*Subscription
+	for _, ps := range psubs {
+		sub, err := ps.Subscribe("foobar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		subs = append(subs, sub)
+	}
+
+	connect(t, hosts[0], hosts[1])
+	time.Sleep(time.Millisecond * 100)
+
+	if len(psubs[0].ListPeers("foobar")) != 1 {
+		t.Fatal("expected the peer to be subscribed")
+	}
+
+	cctx, ccancel := context.WithTimeout(ctx, 5*time.Second)
+	defer ccancel()
+	err := psubs[1].Close(cctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the subscription is closed with a distinguishable error
+	_, err = subs[1].Next(ctx)
+	if err != ErrPubSubClosed {
+		t.Fatalf("expected ErrPubSubClosed, got %v", err)
+	}
+	subs[1].Cancel()
+
+	// the peer has been told that we left the topic
+	time.Sleep(time.Millisecond * 100)
+	if len(psubs[0].ListPeers("foobar")) != 0 {
+		t.Fatal("expected the peer to have unsubscribed")
+	}
+
+	// the stream handlers have been removed from the host
+	for _, proto := range hosts[1].Mux().Protocols() {
+		if proto == string(FloodSubID) {
+			t.Fatal("expected the floodsub stream handler to be removed")
+		}
+	}
+
+	// all further operations fail
+	err = psubs[1].Publish("foobar", []byte("hello"))
+	if err != ErrPubSubClosed {
+		t.Fatalf("expected ErrPubSubClosed from Publish, got %v", err)
+	}
+	_, err = psubs[1].Subscribe("foobar")
+	if err != ErrPubSubClosed {
+		t.Fatalf("expected ErrPubSubClosed from Subscribe, got %v", err)
+	}
+	if psubs[1].ListPeers("foobar") != nil {
+		t.Fatal("expected no peers after close")
+	}
+	err = psubs[1].Close(cctx)
+	if err != ErrPubSubClosed {
+		t.Fatalf("expected ErrPubSubClosed from Close, got %v", err)
+	}
+
+	// the other side keeps working
+	err = psubs[0].Publish("foobar", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertReceive(t, subs[0], []byte("hello"))
+}
@@ -4,19 +4,33 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"sort"
 	"time"
 
-	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
 
 	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
 	"github.com/libp2p/go-libp2p-core/protocol"
+
+	ma "github.com/multiformats/go-multiaddr"
 )
 
 const (
-	GossipSubID = protocol.ID("/meshsub/1.0.0")
+	// GossipSubID_v10 is the protocol ID for version 1.0.0 of the gossipsub protocol.
+	GossipSubID_v10 = protocol.ID("/meshsub/1.0.0")
+	// GossipSubID_v11 is the protocol ID for version 1.1.0 of the gossipsub protocol,
+	// which adds PRUNE backoff and peer exchange.
+	GossipSubID_v11 = protocol.ID("/meshsub/1.1.0")
+
+	// GossipSubID is the protocol ID for the latest version of the gossipsub protocol.
+	GossipSubID = GossipSubID_v11
 )
 
+// Default gossipsub parameters; see GossipSubParams.
+// These are only read by DefaultGossipSubParams, when a router is constructed.
 var (
 	// overlay parameters
 	GossipSubD   = 6
@@ -33,6 +47,21 @@ var (
 
 	// fanout ttl
 	GossipSubFanoutTTL = 60 * time.Second
+
+	// backoff time for pruned peers; peers are not allowed to re-GRAFT before it expires
+	GossipSubPruneBackoff = time.Minute
+
+	// number of peers to include in PRUNE peer exchange, and maximum number of
+	// peers we attempt to connect to from a single PRUNE
+	GossipSubPrunePeers = 16
+
+	// number of goroutines connecting to peers learned through peer exchange,
+	// and maximum number of pending connection attempts
+	GossipSubConnectors            = 8
+	GossipSubMaxPendingConnections = 128
+
+	// timeout for connecting to peers learned through peer exchange
+	GossipSubConnectionTimeout = 30 * time.Second
 )
 
 // NewGossipSub returns a new PubSub object using GossipSubRouter as the router.
@@ -44,45 +73,149 @@ func NewGossipSub(ctx context.Context, h host.Host, opts ...Option) (*PubSub, er
 		lastpub: make(map[string]int64),
 		gossip:  make(map[peer.ID][]*pb.ControlIHave),
 		control: make(map[peer.ID]*pb.ControlMessage),
-		mcache:  NewMessageCache(GossipSubHistoryGossip, GossipSubHistoryLength),
+		backoff: make(map[string]map[peer.ID]time.Time),
+		records: make(map[peer.ID][]byte),
+		protos:  []protocol.ID{GossipSubID_v11, GossipSubID_v10, FloodSubID},
+		params:  DefaultGossipSubParams(),
 	}
 	return NewPubSub(ctx, h, rt, opts...)
 }
 
+// WithPeerScore is a gossipsub router option that enables peer scoring.
+// Peers are scored according to params; the thresholds determine how low-scoring
+// peers are treated: peers below the gossip threshold are excluded from gossip,
+// peers below the publish threshold are not sent our publishes, and peers below
+// the graylist threshold have their RPCs ignored altogether. Peers with a negative
+// score are never grafted to the mesh.
+func WithPeerScore(params *PeerScoreParams, thresholds *PeerScoreThresholds) Option {
+	return func(ps *PubSub) error {
+		gs, ok := ps.rt.(*GossipSubRouter)
+		if !ok {
+			return fmt.Errorf("pubsub router is not gossipsub")
+		}
+
+		err := params.validate()
+		if err != nil {
+			return err
+		}
+
+		err = thresholds.validate()
+		if err != nil {
+			return err
+		}
+
+		gs.score = newPeerScore(params)
+		gs.gossipThreshold = thresholds.GossipThreshold
+		gs.publishThreshold = thresholds.PublishThreshold
+		gs.graylistThreshold = thresholds.GraylistThreshold
+		gs.acceptPXThreshold = thresholds.AcceptPXThreshold
+
+		// hook the message lifecycle into the score
+		ps.tracer.internal = append(ps.tracer.internal, gs.score)
+
+		return nil
+	}
+}
+
+// WithPeerExchange is a gossipsub router option that enables Peer eXchange on PRUNE.
+// When enabled, PRUNE messages sent to gossipsub v1.1 peers carry a list of other
+// peers in the topic, with the records of their addresses they signed and sent us, so
+// that the pruned peer can find replacements. This should generally be enabled in bootstrappers
+// and well connected/trusted nodes used for bootstrapping.
+func WithPeerExchange(doPX bool) Option {
+	return func(ps *PubSub) error {
+		gs, ok := ps.rt.(*GossipSubRouter)
+		if !ok {
+			return fmt.Errorf("pubsub router is not gossipsub")
+		}
+
+		gs.doPX = doPX
+
+		return nil
+	}
+}
+
 // GossipSubRouter is a router that implements the gossipsub protocol.
 // For each topic we have joined, we maintain an overlay through which
 // messages flow; this is the mesh map.
 // For each topic we publish to without joining, we maintain a list of peers
 // to use for injecting our messages in the overlay with stable routes; this
 // is the fanout map. Fanout peer lists are expired if we don't publish any
-// messages to their topic for GossipSubFanoutTTL.
+// messages to their topic for the FanoutTTL of the router parameters.
 type GossipSubRouter struct {
 	p       *PubSub
-	peers   map[peer.ID]protocol.ID         // peer protocols
-	mesh    map[string]map[peer.ID]struct{} // topic meshes
-	fanout  map[string]map[peer.ID]struct{} // topic fanout
-	lastpub map[string]int64                // last publish time for fanout topics
-	gossip  map[peer.ID][]*pb.ControlIHave  // pending gossip
-	control map[peer.ID]*pb.ControlMessage  // pending control messages
+	peers   map[peer.ID]protocol.ID          // peer protocols
+	mesh    map[string]map[peer.ID]struct{}  // topic meshes
+	fanout  map[string]map[peer.ID]struct{}  // topic fanout
+	lastpub map[string]int64                 // last publish time for fanout topics
+	gossip  map[peer.ID][]*pb.ControlIHave   // pending gossip
+	control map[peer.ID]*pb.ControlMessage   // pending control messages
+	backoff map[string]map[peer.ID]time.Time // prune backoff
+	records map[peer.ID][]byte               // signed peer records of our peers, offered in PX
+	connect chan connectInfo                 // px connection requests
+	protos  []protocol.ID                    // supported protocols, in order of preference
 	mcache  *MessageCache
+
+	// router parameters; immutable after construction
+	params GossipSubParams
+
+	tracer *pubsubTracer
+
+	// whether to include peer exchange in our PRUNEs
+	doPX bool
+
+	// peer score; nil when scoring is disabled
+	score *peerScore
+
+	// score thresholds; with scoring disabled all peers score 0 and pass
+	gossipThreshold   float64
+	publishThreshold  float64
+	graylistThreshold float64
+	acceptPXThreshold float64
+}
+
+type connectInfo struct {
+	p     peer.ID
+	addrs []ma.Multiaddr
 }
 
 func (gs *GossipSubRouter) Protocols() []protocol.ID {
-	return []protocol.ID{GossipSubID, FloodSubID}
+	return gs.protos
 }
 
 func (gs *GossipSubRouter) Attach(p *PubSub) {
 	gs.p = p
+	gs.tracer = p.tracer
+	gs.mcache = NewMessageCache(gs.params.HistoryGossip, gs.params.HistoryLength)
+	gs.connect = make(chan connectInfo, gs.params.MaxPendingConnections)
+	// use the same message ID function as the pubsub instance, so that gossip
+	// and the seen messages cache agree on message identity
+	gs.mcache.SetMsgIdFn(p.msgID)
+	gs.score.Start(gs)
 	go gs.heartbeatTimer()
+	for i := 0; i < gs.params.Connectors; i++ {
+		go gs.connector()
+	}
 }
 
 func (gs *GossipSubRouter) AddPeer(p peer.ID, proto protocol.ID) {
-	log.Debugf("PEERUP: Add new peer %s using %s", p, proto)
+	gs.p.logger.Debugw("PEERUP: add new peer", "peer", p, "protocol", proto)
 	gs.peers[p] = proto
+	gs.score.AddPeer(p, proto)
+
+	// offer our signed peer record, so that the peer can include it in its PX
+	if proto == GossipSubID_v11 {
+		rec := gs.makePeerRecord()
+		if rec != nil {
+			out := &RPC{RPC: pb.RPC{Control: &pb.ControlMessage{PeerRecord: rec}}}
+			gs.sendRPC(p, out, PriorityControl)
+		}
+	}
 }
 
 func (gs *GossipSubRouter) RemovePeer(p peer.ID) {
-	log.Debugf("PEERDOWN: Remove disconnected peer %s", p)
+	gs.p.logger.Debugw("PEERDOWN: remove disconnected peer", "peer", p)
+	gs.score.RemovePeer(p)
 	delete(gs.peers, p)
 	for _, peers := range gs.mesh {
 		delete(peers, p)
@@ -92,30 +225,47 @@ func (gs *GossipSubRouter) RemovePeer(p peer.ID) {
 	}
 	delete(gs.gossip, p)
 	delete(gs.control, p)
+	delete(gs.records, p)
+}
+
+func (gs *GossipSubRouter) AcceptFrom(p peer.ID) bool {
+	return gs.score.Score(p) >= gs.graylistThreshold
 }
 
 func (gs *GossipSubRouter) HandleRPC(rpc *RPC) {
 	ctl := rpc.GetControl()
-	// fmt.Println(gs.p.host.ID(), "HandleRPC", ctl)
 	if ctl == nil {
 		return
 	}
 
+	gs.handlePeerRecord(rpc.from, ctl)
+
 	iwant := gs.handleIHave(rpc.from, ctl)
 	ihave := gs.handleIWant(rpc.from, ctl)
 	prune := gs.handleGraft(rpc.from, ctl)
 	gs.handlePrune(rpc.from, ctl)
 
-	// fmt.Printf("%v sendRPC to %v\n", gs.p.host.ID(), rpc.from)
 	if len(iwant) == 0 && len(ihave) == 0 && len(prune) == 0 {
 		return
 	}
 
+	class := PriorityGossip
+	if len(prune) > 0 {
+		class = PriorityControl
+	}
+
 	out := rpcWithControl(ihave, nil, iwant, nil, prune)
-	gs.sendRPC(rpc.from, out)
+	gs.sendRPC(rpc.from, out, class)
 }
 
 func (gs *GossipSubRouter) handleIHave(p peer.ID, ctl *pb.ControlMessage) []*pb.ControlIWant {
+	// we ignore IHAVE gossip from any peer whose score is below the gossip threshold
+	score := gs.score.Score(p)
+	if score < gs.gossipThreshold {
+		gs.p.logger.Debugw("IHAVE: ignoring peer with score below threshold", "peer", p, "score", score)
+		return nil
+	}
+
 	iwant := make(map[string]struct{})
 
 	for _, ihave := range ctl.GetIhave() {
@@ -137,7 +287,7 @@ func (gs *GossipSubRouter) handleIHave(p peer.ID, ctl *pb.ControlMessage) []*pb.
 		return nil
 	}
 
-	log.Debugf("IHAVE: Asking for %d messages from %s", len(iwant), p)
+	gs.p.logger.Debugw("IHAVE: asking for messages", "peer", p, "count", len(iwant))
 
 	iwantlst := make([]string, 0, len(iwant))
 	for mid := range iwant {
@@ -148,6 +298,13 @@ func (gs *GossipSubRouter) handleIHave(p peer.ID, ctl *pb.ControlMessage) []*pb.
 }
 
 func (gs *GossipSubRouter) handleIWant(p peer.ID, ctl *pb.ControlMessage) []*pb.Message {
+	// we don't respond to IWANT requests from any peer whose score is below the gossip threshold
+	score := gs.score.Score(p)
+	if score < gs.gossipThreshold {
+		gs.p.logger.Debugw("IWANT: ignoring peer with score below threshold", "peer", p, "score", score)
+		return nil
+	}
+
 	ihave := make(map[string]*pb.Message)
 	for _, iwant := range ctl.GetIwant() {
 		for _, mid := range iwant.GetMessageIDs() {
@@ -162,7 +319,7 @@ func (gs *GossipSubRouter) handleIWant(p peer.ID, ctl *pb.ControlMessage) []*pb.
 		return nil
 	}
 
-	log.Debugf("IWANT: Sending %d messages to %s", len(ihave), p)
+	gs.p.logger.Debugw("IWANT: sending messages", "peer", p, "count", len(ihave))
 
 	msgs := make([]*pb.Message, 0, len(ihave))
 	for _, msg := range ihave {
@@ -174,16 +331,58 @@ func (gs *GossipSubRouter) handleIWant(p peer.ID, ctl *pb.ControlMessage) []*pb.
 
 func (gs *GossipSubRouter) handleGraft(p peer.ID, ctl *pb.ControlMessage) []*pb.ControlPrune {
 	var prune []string
+
+	// we don't do PX when there is an unknown topic, a backoff violation, or a negative score
+	doPX := gs.doPX
+	score := gs.score.Score(p)
+	now := time.Now()
+
 	for _, graft := range ctl.GetGraft() {
 		topic := graft.GetTopicID()
 		peers, ok := gs.mesh[topic]
 		if !ok {
+			// don't do PX when there is an unknown topic to avoid leaking our peers
+			doPX = false
 			prune = append(prune, topic)
-		} else {
-			log.Debugf("GRAFT: Add mesh link from %s in %s", p, topic)
-			peers[p] = struct{}{}
-			gs.tagPeer(p, topic)
+			continue
+		}
+
+		if _, ok := peers[p]; ok {
+			// we are already in the mesh
+			continue
+		}
+
+		// make sure we are not backing off that peer
+		expire, backoff := gs.backoff[topic][p]
+		if backoff && now.Before(expire) {
+			gs.p.logger.Debugw("GRAFT: ignoring backed off peer", "peer", p, "topic", topic)
+			// add behavioural penalty
+			gs.score.AddPenalty(p, 1)
+			// no PX
+			doPX = false
+			// refresh the backoff
+			gs.addBackoff(p, topic)
+			prune = append(prune, topic)
+			continue
+		}
+
+		// we don't GRAFT peers with negative score
+		if score < 0 {
+			gs.p.logger.Debugw("GRAFT: ignoring peer with negative score", "peer", p, "topic", topic, "score", score)
+			// we do send them PRUNE however, because it's a matter of protocol correctness
+			prune = append(prune, topic)
+			// but we won't PX to them
+			doPX = false
+			// add/refresh backoff so that we don't reGRAFT too early even if the score decays back up
+			gs.addBackoff(p, topic)
+			continue
 		}
+
+		gs.p.logger.Debugw("GRAFT: add mesh link", "peer", p, "topic", topic)
+		peers[p] = struct{}{}
+		gs.tagPeer(p, topic)
+		gs.score.Graft(p, topic)
+		gs.tracer.Graft(p, topic)
 	}
 
 	if len(prune) == 0 {
@@ -192,20 +391,201 @@ func (gs *GossipSubRouter) handleGraft(p peer.ID, ctl *pb.ControlMessage) []*pb.
 
 	cprune := make([]*pb.ControlPrune, 0, len(prune))
 	for _, topic := range prune {
-		cprune = append(cprune, &pb.ControlPrune{TopicID: &topic})
+		cprune = append(cprune, gs.makePrune(p, topic, doPX))
 	}
 
 	return cprune
 }
 
 func (gs *GossipSubRouter) handlePrune(p peer.ID, ctl *pb.ControlMessage) {
+	score := gs.score.Score(p)
+
 	for _, prune := range ctl.GetPrune() {
 		topic := prune.GetTopicID()
 		peers, ok := gs.mesh[topic]
-		if ok {
-			log.Debugf("PRUNE: Remove mesh link to %s in %s", p, topic)
-			delete(peers, p)
-			gs.untagPeer(p, topic)
+		if !ok {
+			continue
+		}
+
+		gs.p.logger.Debugw("PRUNE: remove mesh link", "peer", p, "topic", topic)
+		delete(peers, p)
+		gs.untagPeer(p, topic)
+		gs.score.Prune(p, topic)
+		gs.tracer.Prune(p, topic)
+
+		// is there a backoff specified by the peer? if so obey it.
+		backoff := prune.GetBackoff()
+		if backoff > 0 {
+			gs.doAddBackoff(p, topic, time.Duration(backoff)*time.Second)
+		} else {
+			gs.addBackoff(p, topic)
+		}
+
+		px := prune.GetPeers()
+		if len(px) > 0 {
+			// we ignore PX from peers with insufficient score
+			if score < gs.acceptPXThreshold {
+				gs.p.logger.Debugw("PRUNE: ignoring PX from peer with insufficient score", "peer", p, "topic", topic, "score", score)
+				continue
+			}
+
+			gs.pxConnect(p, px)
+		}
+	}
+}
+
+func (gs *GossipSubRouter) addBackoff(p peer.ID, topic string) {
+	gs.doAddBackoff(p, topic, gs.params.PruneBackoff)
+}
+
+func (gs *GossipSubRouter) doAddBackoff(p peer.ID, topic string, interval time.Duration) {
+	backoff, ok := gs.backoff[topic]
+	if !ok {
+		backoff = make(map[peer.ID]time.Time)
+		gs.backoff[topic] = backoff
+	}
+	expire := time.Now().Add(interval)
+	if backoff[p].Before(expire) {
+		backoff[p] = expire
+	}
+}
+
+func (gs *GossipSubRouter) backedOff(p peer.ID, topic string) bool {
+	expire, ok := gs.backoff[topic][p]
+	return ok && time.Now().Before(expire)
+}
+
+func (gs *GossipSubRouter) clearBackoff() {
+	now := time.Now()
+	for topic, backoff := range gs.backoff {
+		for p, expire := range backoff {
+			if expire.Before(now) {
+				delete(backoff, p)
+			}
+		}
+		if len(backoff) == 0 {
+			delete(gs.backoff, topic)
+		}
+	}
+}
+
+func (gs *GossipSubRouter) pxConnect(from peer.ID, peers []*pb.PeerInfo) {
+	if len(peers) > gs.params.PrunePeers {
+		shufflePeerInfo(peers)
+		peers = peers[:gs.params.PrunePeers]
+	}
+
+	toconnect := make([]connectInfo, 0, len(peers))
+
+	for _, pi := range peers {
+		p, err := peer.IDFromBytes(pi.PeerID)
+		if err != nil {
+			gs.p.logger.Debugw("PX: bogus peer ID", "peer", from, "error", err)
+			continue
+		}
+
+		// ignore self and peers we are already connected to
+		_, connected := gs.peers[p]
+		if connected || p == gs.p.host.ID() {
+			continue
+		}
+
+		var addrs []ma.Multiaddr
+		if pi.SignedPeerRecord != nil {
+			addrs, err = gs.openPeerRecord(from, p, pi.SignedPeerRecord)
+			if err != nil {
+				gs.p.logger.Debugw("PX: bad peer record", "peer", from, "record", p, "error", err)
+				continue
+			}
+		}
+
+		toconnect = append(toconnect, connectInfo{p: p, addrs: addrs})
+	}
+
+	for _, ci := range toconnect {
+		select {
+		case gs.connect <- ci:
+		default:
+			gs.p.logger.Debugw("PX: ignoring peer connection attempt; too many pending connections")
+			return
+		}
+	}
+}
+
+// handlePeerRecord keeps the signed peer record a peer sends about itself, to offer it
+// in our PX.
+func (gs *GossipSubRouter) handlePeerRecord(p peer.ID, ctl *pb.ControlMessage) {
+	data := ctl.GetPeerRecord()
+	if data == nil {
+		return
+	}
+
+	_, err := gs.openPeerRecord(p, p, data)
+	if err != nil {
+		gs.p.logger.Debugw("ignoring bad peer record", "peer", p, "error", err)
+		return
+	}
+
+	gs.records[p] = data
+}
+
+// openPeerRecord verifies a signed peer record received from a peer, either about itself
+// or in PX, and returns the addresses it contains. The record must describe the exchanged
+// peer and be signed by that peer: the peer sending it can only relay it.
+func (gs *GossipSubRouter) openPeerRecord(from, p peer.ID, data []byte) ([]ma.Multiaddr, error) {
+	var rec pb.PeerRecord
+	err := rec.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if peer.ID(rec.PeerID) != p {
+		return nil, fmt.Errorf("record is for the wrong peer %s", peer.ID(rec.PeerID))
+	}
+
+	signer, err := verifyPeerRecord(&rec)
+	if err != nil {
+		return nil, err
+	}
+
+	if signer != p {
+		return nil, fmt.Errorf("record for %s signed by another peer %s", p, signer)
+	}
+
+	addrs := make([]ma.Multiaddr, 0, len(rec.Addrs))
+	for _, b := range rec.Addrs {
+		a, err := ma.NewMultiaddrBytes(b)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, a)
+	}
+
+	return addrs, nil
+}
+
+func (gs *GossipSubRouter) connector() {
+	for {
+		select {
+		case ci := <-gs.connect:
+			if gs.p.host.Network().Connectedness(ci.p) == network.Connected {
+				continue
+			}
+
+			gs.p.logger.Debugw("PX: connecting to peer", "peer", ci.p)
+			if len(ci.addrs) > 0 {
+				gs.p.host.Peerstore().AddAddrs(ci.p, ci.addrs, peerstore.TempAddrTTL)
+			}
+
+			ctx, cancel := context.WithTimeout(gs.p.ctx, gs.params.ConnectionTimeout)
+			err := gs.p.host.Connect(ctx, peer.AddrInfo{ID: ci.p})
+			cancel()
+			if err != nil {
+				gs.p.logger.Debugw("PX: error connecting to peer", "peer", ci.p, "error", err)
+			}
+
+		case <-gs.p.ctx.Done():
+			return
 		}
 	}
 }
@@ -223,7 +603,7 @@ func (gs *GossipSubRouter) Publish(from peer.ID, msg *pb.Message) {
 
 		// floodsub peers
 		for p := range tmap {
-			if gs.peers[p] == FloodSubID {
+			if gs.peers[p] == FloodSubID && gs.score.Score(p) >= gs.publishThreshold {
 				tosend[p] = struct{}{}
 			}
 		}
@@ -234,8 +614,11 @@ func (gs *GossipSubRouter) Publish(from peer.ID, msg *pb.Message) {
 			// we are not in the mesh for topic, use fanout peers
 			gmap, ok = gs.fanout[topic]
 			if !ok || len(gmap) == 0 {
-				// we don't have any, pick some
-				peers := gs.getPeers(topic, GossipSubD, func(peer.ID) bool { return true })
+				// we don't have any, pick some with score above the publish threshold
+				d, _, _ := gs.params.overlay(topic)
+				peers := gs.getPeers(topic, d, func(p peer.ID) bool {
+					return gs.score.Score(p) >= gs.publishThreshold
+				})
 
 				if len(peers) > 0 {
 					gmap = peerListToMap(peers)
@@ -251,12 +634,13 @@ func (gs *GossipSubRouter) Publish(from peer.ID, msg *pb.Message) {
 	}
 
 	out := rpcWithMessages(msg)
+	class := gs.p.messageClass(from)
 	for pid := range tosend {
 		if pid == from || pid == peer.ID(msg.GetFrom()) {
 			continue
 		}
 
-		gs.sendRPC(pid, out)
+		gs.sendRPC(pid, out, class)
 	}
 }
 
@@ -266,23 +650,37 @@ func (gs *GossipSubRouter) Join(topic string) {
 		return
 	}
 
-	log.Debugf("JOIN %s", topic)
+	gs.p.logger.Debugw("JOIN", "topic", topic)
 
 	gmap, ok = gs.fanout[topic]
 	if ok {
+		// these peers have a score above the publish threshold, which may be negative
+		// so drop the ones with a negative score
+		for p := range gmap {
+			if gs.score.Score(p) < 0 || gs.backedOff(p, topic) {
+				delete(gmap, p)
+			}
+		}
+
 		gs.mesh[topic] = gmap
 		delete(gs.fanout, topic)
 		delete(gs.lastpub, topic)
 	} else {
-		peers := gs.getPeers(topic, GossipSubD, func(peer.ID) bool { return true })
+		d, _, _ := gs.params.overlay(topic)
+		peers := gs.getPeers(topic, d, func(p peer.ID) bool {
+			// filter peers with negative score and peers we are backing off
+			return gs.score.Score(p) >= 0 && !gs.backedOff(p, topic)
+		})
 		gmap = peerListToMap(peers)
 		gs.mesh[topic] = gmap
 	}
 
 	for p := range gmap {
-		log.Debugf("JOIN: Add mesh link to %s in %s", p, topic)
+		gs.p.logger.Debugw("JOIN: add mesh link", "peer", p, "topic", topic)
 		gs.sendGraft(p, topic)
 		gs.tagPeer(p, topic)
+		gs.score.Graft(p, topic)
+		gs.tracer.Graft(p, topic)
 	}
 }
 
@@ -292,40 +690,45 @@ func (gs *GossipSubRouter) Leave(topic string) {
 		return
 	}
 
-	log.Debugf("LEAVE %s", topic)
+	gs.p.logger.Debugw("LEAVE", "topic", topic)
 
 	delete(gs.mesh, topic)
+	gs.p.metrics.MeshSize(topic, 0)
 
 	for p := range gmap {
-		log.Debugf("LEAVE: Remove mesh link to %s in %s", p, topic)
+		gs.p.logger.Debugw("LEAVE: remove mesh link", "peer", p, "topic", topic)
 		gs.sendPrune(p, topic)
 		gs.untagPeer(p, topic)
+		gs.score.Prune(p, topic)
+		gs.tracer.Prune(p, topic)
+		gs.addBackoff(p, topic)
 	}
 }
 
 func (gs *GossipSubRouter) sendGraft(p peer.ID, topic string) {
 	graft := []*pb.ControlGraft{&pb.ControlGraft{TopicID: &topic}}
 	out := rpcWithControl(nil, nil, nil, graft, nil)
-	gs.sendRPC(p, out)
+	gs.sendRPC(p, out, PriorityControl)
 }
 
 func (gs *GossipSubRouter) sendPrune(p peer.ID, topic string) {
-	prune := []*pb.ControlPrune{&pb.ControlPrune{TopicID: &topic}}
+	prune := []*pb.ControlPrune{gs.makePrune(p, topic, gs.doPX)}
 	out := rpcWithControl(nil, nil, nil, nil, prune)
-	gs.sendRPC(p, out)
+	gs.sendRPC(p, out, PriorityControl)
 }
 
-func (gs *GossipSubRouter) sendRPC(p peer.ID, out *RPC) {
+func (gs *GossipSubRouter) sendRPC(p peer.ID, out *RPC, class RPCPriority) {
 	// do we own the RPC?
 	own := false
 
-	// piggyback control message retries
+	// piggyback control message retries, which are sent with control priority
 	ctl, ok := gs.control[p]
 	if ok {
 		out = copyRPC(out)
 		own = true
 		gs.piggybackControl(p, out, ctl)
 		delete(gs.control, p)
+		class = PriorityControl
 	}
 
 	// piggyback gossip
@@ -339,32 +742,27 @@ func (gs *GossipSubRouter) sendRPC(p peer.ID, out *RPC) {
 		delete(gs.gossip, p)
 	}
 
-	mch, ok := gs.p.peers[p]
-	if !ok {
+	dropped := gs.p.sendRPC(p, out, class)
+	if dropped == nil {
 		return
 	}
 
-	select {
-	case mch <- out:
-	default:
-		log.Infof("dropping message to peer %s: queue full", p)
-		// push control messages that need to be retried
-		ctl := out.GetControl()
-		if ctl != nil {
-			gs.pushControl(p, ctl)
-		}
+	// push control messages that need to be retried
+	ctl = dropped.GetControl()
+	if ctl != nil {
+		gs.pushControl(p, ctl)
 	}
 }
 
 func (gs *GossipSubRouter) heartbeatTimer() {
-	time.Sleep(GossipSubHeartbeatInitialDelay)
+	time.Sleep(gs.params.HeartbeatInitialDelay)
 	select {
 	case gs.p.eval <- gs.heartbeat:
 	case <-gs.p.ctx.Done():
 		return
 	}
 
-	ticker := time.NewTicker(GossipSubHeartbeatInterval)
+	ticker := time.NewTicker(gs.params.HeartbeatInterval)
 	defer ticker.Stop()
 
 	for {
@@ -384,56 +782,102 @@ func (gs *GossipSubRouter) heartbeatTimer() {
 func (gs *GossipSubRouter) heartbeat() {
 	defer log.EventBegin(gs.p.ctx, "heartbeat").Done()
 
+	start := time.Now()
+	defer func() {
+		gs.p.metrics.HeartbeatDuration(time.Since(start))
+	}()
+
 	// flush pending control message from retries and gossip
 	// that hasn't been piggybacked since the last heartbeat
 	gs.flush()
 
+	// clean up expired prune backoffs
+	gs.clearBackoff()
+
 	tograft := make(map[peer.ID][]string)
 	toprune := make(map[peer.ID][]string)
 
+	// cache scores throughout the heartbeat
+	scores := make(map[peer.ID]float64)
+	score := func(p peer.ID) float64 {
+		s, ok := scores[p]
+		if !ok {
+			s = gs.score.Score(p)
+			scores[p] = s
+		}
+		return s
+	}
+
 	// maintain the mesh for topics we have joined
 	for topic, peers := range gs.mesh {
+		d, dlo, dhi := gs.params.overlay(topic)
+
+		prunePeer := func(p peer.ID) {
+			delete(peers, p)
+			gs.untagPeer(p, topic)
+			gs.score.Prune(p, topic)
+			gs.tracer.Prune(p, topic)
+			gs.addBackoff(p, topic)
+			topics := toprune[p]
+			toprune[p] = append(topics, topic)
+		}
+
+		graftPeer := func(p peer.ID) {
+			gs.p.logger.Debugw("HEARTBEAT: add mesh link", "peer", p, "topic", topic)
+			peers[p] = struct{}{}
+			gs.tagPeer(p, topic)
+			gs.score.Graft(p, topic)
+			gs.tracer.Graft(p, topic)
+			topics := tograft[p]
+			tograft[p] = append(topics, topic)
+		}
+
+		// drop all peers with negative score
+		for p := range peers {
+			if score(p) < 0 {
+				gs.p.logger.Debugw("HEARTBEAT: prune peer with negative score", "peer", p, "topic", topic, "score", score(p))
+				prunePeer(p)
+			}
+		}
 
 		// do we have enough peers?
-		if len(peers) < GossipSubDlo {
-			ineed := GossipSubD - len(peers)
+		if len(peers) < dlo {
+			ineed := d - len(peers)
 			plst := gs.getPeers(topic, ineed, func(p peer.ID) bool {
-				// filter our current peers
+				// filter our current peers, peers we are backing off and peers with negative scores
 				_, ok := peers[p]
-				return !ok
+				return !ok && !gs.backedOff(p, topic) && score(p) >= 0
 			})
 
 			for _, p := range plst {
-				log.Debugf("HEARTBEAT: Add mesh link to %s in %s", p, topic)
-				peers[p] = struct{}{}
-				gs.tagPeer(p, topic)
-				topics := tograft[p]
-				tograft[p] = append(topics, topic)
+				graftPeer(p)
 			}
 		}
 
 		// do we have too many peers?
-		if len(peers) > GossipSubDhi {
-			idontneed := len(peers) - GossipSubD
+		if len(peers) > dhi {
 			plst := peerMapToList(peers)
+
+			// shuffle first to break ties randomly, then keep the D best scoring peers
 			shufflePeers(plst)
+			sort.SliceStable(plst, func(i, j int) bool {
+				return score(plst[i]) > score(plst[j])
+			})
 
-			for _, p := range plst[:idontneed] {
-				log.Debugf("HEARTBEAT: Remove mesh link to %s in %s", p, topic)
-				delete(peers, p)
-				gs.untagPeer(p, topic)
-				topics := toprune[p]
-				toprune[p] = append(topics, topic)
+			for _, p := range plst[d:] {
+				gs.p.logger.Debugw("HEARTBEAT: remove mesh link", "peer", p, "topic", topic)
+				prunePeer(p)
 			}
 		}
 
 		gs.emitGossip(topic, peers)
+		gs.p.metrics.MeshSize(topic, len(peers))
 	}
 
 	// expire fanout for topics we haven't published to in a while
 	now := time.Now().UnixNano()
 	for topic, lastpub := range gs.lastpub {
-		if lastpub+int64(GossipSubFanoutTTL) < now {
+		if lastpub+int64(gs.params.FanoutTTL) < now {
 			delete(gs.fanout, topic)
 			delete(gs.lastpub, topic)
 		}
@@ -441,21 +885,22 @@ func (gs *GossipSubRouter) heartbeat() {
 
 	// maintain our fanout for topics we are publishing but we have not joined
 	for topic, peers := range gs.fanout {
-		// check whether our peers are still in the topic
+		// check whether our peers are still in the topic and have a score above the publish threshold
 		for p := range peers {
 			_, ok := gs.p.topics[topic][p]
-			if !ok {
+			if !ok || score(p) < gs.publishThreshold {
 				delete(peers, p)
 			}
 		}
 
 		// do we need more peers?
-		if len(peers) < GossipSubD {
-			ineed := GossipSubD - len(peers)
+		d, _, _ := gs.params.overlay(topic)
+		if len(peers) < d {
+			ineed := d - len(peers)
 			plst := gs.getPeers(topic, ineed, func(p peer.ID) bool {
-				// filter our current peers
+				// filter our current peers and peers with score below the publish threshold
 				_, ok := peers[p]
-				return !ok
+				return !ok && score(p) >= gs.publishThreshold
 			})
 
 			for _, p := range plst {
@@ -486,24 +931,82 @@ func (gs *GossipSubRouter) sendGraftPrune(tograft, toprune map[peer.ID][]string)
 			delete(toprune, p)
 			prune = make([]*pb.ControlPrune, 0, len(pruning))
 			for _, topic := range pruning {
-				prune = append(prune, &pb.ControlPrune{TopicID: &topic})
+				prune = append(prune, gs.makePrune(p, topic, gs.doPX))
 			}
 		}
 
 		out := rpcWithControl(nil, nil, nil, graft, prune)
-		gs.sendRPC(p, out)
+		gs.sendRPC(p, out, PriorityControl)
 	}
 
 	for p, topics := range toprune {
 		prune := make([]*pb.ControlPrune, 0, len(topics))
 		for _, topic := range topics {
-			prune = append(prune, &pb.ControlPrune{TopicID: &topic})
+			prune = append(prune, gs.makePrune(p, topic, gs.doPX))
 		}
 
 		out := rpcWithControl(nil, nil, nil, nil, prune)
-		gs.sendRPC(p, out)
+		gs.sendRPC(p, out, PriorityControl)
+	}
+
+}
+
+func (gs *GossipSubRouter) makePrune(p peer.ID, topic string, doPX bool) *pb.ControlPrune {
+	if gs.peers[p] == GossipSubID_v10 {
+		// gossipsub v1.0 -- no backoff or PX, the peer won't be able to parse it anyway
+		return &pb.ControlPrune{TopicID: &topic}
+	}
+
+	backoff := uint64(gs.params.PruneBackoff / time.Second)
+	var px []*pb.PeerInfo
+	if doPX && gs.params.PrunePeers > 0 {
+		// select peers for Peer eXchange
+		peers := gs.getPeers(topic, gs.params.PrunePeers, func(xp peer.ID) bool {
+			return p != xp && gs.score.Score(xp) >= 0
+		})
+
+		px = make([]*pb.PeerInfo, 0, len(peers))
+		for _, xp := range peers {
+			px = append(px, &pb.PeerInfo{PeerID: []byte(xp), SignedPeerRecord: gs.records[xp]})
+		}
+	}
+
+	return &pb.ControlPrune{TopicID: &topic, Peers: px, Backoff: &backoff}
+}
+
+// makePeerRecord creates a record with our addresses, signed with our host key; it
+// returns nil if we have no key or no addresses.
+func (gs *GossipSubRouter) makePeerRecord() []byte {
+	h := gs.p.host
+	key := h.Peerstore().PrivKey(h.ID())
+	if key == nil {
+		return nil
+	}
+
+	addrs := h.Addrs()
+	if len(addrs) == 0 {
+		return nil
+	}
+
+	seq := uint64(time.Now().UnixNano())
+	rec := &pb.PeerRecord{PeerID: []byte(h.ID()), Seq: &seq}
+	for _, a := range addrs {
+		rec.Addrs = append(rec.Addrs, a.Bytes())
+	}
+
+	err := signPeerRecord(h.ID(), key, rec)
+	if err != nil {
+		gs.p.logger.Warnw("error signing peer record", "error", err)
+		return nil
+	}
+
+	data, err := rec.Marshal()
+	if err != nil {
+		gs.p.logger.Warnw("error marshalling peer record", "error", err)
+		return nil
 	}
 
+	return data
 }
 
 func (gs *GossipSubRouter) emitGossip(topic string, peers map[peer.ID]struct{}) {
@@ -512,7 +1015,11 @@ func (gs *GossipSubRouter) emitGossip(topic string, peers map[peer.ID]struct{})
 		return
 	}
 
-	gpeers := gs.getPeers(topic, GossipSubD, func(peer.ID) bool { return true })
+	// gossip to peers with score above the gossip threshold
+	d, _, _ := gs.params.overlay(topic)
+	gpeers := gs.getPeers(topic, d, func(p peer.ID) bool {
+		return gs.score.Score(p) >= gs.gossipThreshold
+	})
 	for _, p := range gpeers {
 		// skip mesh peers
 		_, ok := peers[p]
@@ -527,14 +1034,14 @@ func (gs *GossipSubRouter) flush() {
 	for p, ihave := range gs.gossip {
 		delete(gs.gossip, p)
 		out := rpcWithControl(nil, ihave, nil, nil, nil)
-		gs.sendRPC(p, out)
+		gs.sendRPC(p, out, PriorityGossip)
 	}
 
 	// send the remaining control messages
 	for p, ctl := range gs.control {
 		delete(gs.control, p)
 		out := rpcWithControl(nil, nil, nil, ctl.Graft, ctl.Prune)
-		gs.sendRPC(p, out)
+		gs.sendRPC(p, out, PriorityControl)
 	}
 }
 
@@ -619,7 +1126,7 @@ func (gs *GossipSubRouter) getPeers(topic string, count int, filter func(peer.ID
 
 	peers := make([]peer.ID, 0, len(tmap))
 	for p := range tmap {
-		if gs.peers[p] == GossipSubID && filter(p) {
+		if gs.isGossipSubPeer(p) && filter(p) {
 			peers = append(peers, p)
 		}
 	}
@@ -633,6 +1140,11 @@ func (gs *GossipSubRouter) getPeers(topic string, count int, filter func(peer.ID
 	return peers
 }
 
+func (gs *GossipSubRouter) isGossipSubPeer(p peer.ID) bool {
+	proto := gs.peers[p]
+	return proto == GossipSubID_v11 || proto == GossipSubID_v10
+}
+
 func (gs *GossipSubRouter) tagPeer(p peer.ID, topic string) {
 	tag := topicTag(topic)
 	gs.p.host.ConnManager().TagPeer(p, tag, 2)
@@ -669,3 +1181,66 @@ func shufflePeers(peers []peer.ID) {
 		peers[i], peers[j] = peers[j], peers[i]
 	}
 }
+
+func shufflePeerInfo(peers []*pb.PeerInfo) {
+	for i := range peers {
+		j := rand.Intn(i + 1)
+		peers[i], peers[j] = peers[j], peers[i]
+	}
+}
+
+func (gs *GossipSubRouter) inspect(s *Snapshot) {
+	s.Router = "gossipsub"
+	s.setProtocols(gs.peers)
+
+	for topic, peers := range gs.mesh {
+		s.topic(topic).Mesh = peerList(peers)
+	}
+	for topic, peers := range gs.fanout {
+		s.topic(topic).Fanout = peerList(peers)
+	}
+	for topic, lastpub := range gs.lastpub {
+		t := time.Unix(0, lastpub)
+		s.topic(topic).LastPublish = &t
+	}
+
+	gss := &GossipSubSnapshot{
+		PendingGossip:  make(map[peer.ID]int, len(gs.gossip)),
+		PendingControl: make(map[peer.ID]ControlSnapshot, len(gs.control)),
+		Backoff:        make(map[string][]peer.ID, len(gs.backoff)),
+		MessageCache: MessageCacheSnapshot{
+			Messages:      len(gs.mcache.msgs),
+			History:       make([]int, len(gs.mcache.history)),
+			GossipWindows: gs.mcache.gossip,
+		},
+	}
+
+	for pid, ihave := range gs.gossip {
+		n := 0
+		for _, ih := range ihave {
+			n += len(ih.GetMessageIDs())
+		}
+		gss.PendingGossip[pid] = n
+	}
+	for pid, ctl := range gs.control {
+		gss.PendingControl[pid] = ControlSnapshot{
+			IHave: len(ctl.GetIhave()),
+			IWant: len(ctl.GetIwant()),
+			Graft: len(ctl.GetGraft()),
+			Prune: len(ctl.GetPrune()),
+		}
+	}
+	for topic, backoff := range gs.backoff {
+		peers := make([]peer.ID, 0, len(backoff))
+		for pid := range backoff {
+			peers = append(peers, pid)
+		}
+		sortPeers(peers)
+		gss.Backoff[topic] = peers
+	}
+	for i, entries := range gs.mcache.history {
+		gss.MessageCache.History[i] = len(entries)
+	}
+
+	s.GossipSub = gss
+}
@@ -0,0 +1,193 @@
+package pubsub
+
+import (
+	"fmt"
+	"time"
+)
+
+// GossipSubParams contains the parameters of a gossipsub router.
+// The defaults are taken from the package level GossipSub* variables at the time
+// DefaultGossipSubParams is called; a router never reads those variables afterwards,
+// so routers with different parameters can coexist in the same process.
+type GossipSubParams struct {
+	// overlay parameters: D is the desired degree of the mesh, while Dlo and Dhi are
+	// the bounds below and above which the heartbeat grafts or prunes peers.
+	D   int
+	Dlo int
+	Dhi int
+
+	// gossip parameters: HistoryLength is the number of heartbeat windows retained in
+	// the message cache, HistoryGossip the number of windows we emit gossip for.
+	HistoryLength int
+	HistoryGossip int
+
+	// heartbeat initial delay and interval
+	HeartbeatInitialDelay time.Duration
+	HeartbeatInterval     time.Duration
+
+	// FanoutTTL is the time we retain the fanout peers of a topic we are not publishing to.
+	FanoutTTL time.Duration
+
+	// PruneBackoff is the time a pruned peer is not allowed to re-GRAFT.
+	PruneBackoff time.Duration
+
+	// PrunePeers is the number of peers included in PRUNE peer exchange, and the maximum
+	// number of peers we attempt to connect to from a single PRUNE.
+	PrunePeers int
+
+	// Connectors is the number of goroutines connecting to peers learned through peer
+	// exchange; MaxPendingConnections bounds the connection attempts waiting for them.
+	Connectors            int
+	MaxPendingConnections int
+
+	// ConnectionTimeout is the timeout for connecting to peers learned through peer exchange.
+	ConnectionTimeout time.Duration
+
+	// Topics contains per topic overrides of the overlay parameters.
+	Topics map[string]*GossipSubTopicParams
+}
+
+// GossipSubTopicParams overrides the overlay parameters for a single topic, for
+// instance to maintain a bigger mesh for a hot topic.
+type GossipSubTopicParams struct {
+	D   int
+	Dlo int
+	Dhi int
+}
+
+// DefaultGossipSubParams returns the default gossipsub parameters.
+func DefaultGossipSubParams() GossipSubParams {
+	return GossipSubParams{
+		D:                     GossipSubD,
+		Dlo:                   GossipSubDlo,
+		Dhi:                   GossipSubDhi,
+		HistoryLength:         GossipSubHistoryLength,
+		HistoryGossip:         GossipSubHistoryGossip,
+		HeartbeatInitialDelay: GossipSubHeartbeatInitialDelay,
+		HeartbeatInterval:     GossipSubHeartbeatInterval,
+		FanoutTTL:             GossipSubFanoutTTL,
+		PruneBackoff:          GossipSubPruneBackoff,
+		PrunePeers:            GossipSubPrunePeers,
+		Connectors:            GossipSubConnectors,
+		MaxPendingConnections: GossipSubMaxPendingConnections,
+		ConnectionTimeout:     GossipSubConnectionTimeout,
+	}
+}
+
+// WithGossipSubParams is a gossipsub router option that sets the router parameters.
+// The parameters are copied, so later changes by the caller have no effect on the router.
+func WithGossipSubParams(params GossipSubParams) Option {
+	return func(ps *PubSub) error {
+		gs, ok := ps.rt.(*GossipSubRouter)
+		if !ok {
+			return fmt.Errorf("pubsub router is not gossipsub")
+		}
+
+		err := params.validate()
+		if err != nil {
+			return err
+		}
+
+		gs.params = params.copy()
+
+		return nil
+	}
+}
+
+// GossipSubParamsOf returns a copy of the parameters of a gossipsub instance.
+func GossipSubParamsOf(ps *PubSub) (GossipSubParams, error) {
+	gs, ok := ps.rt.(*GossipSubRouter)
+	if !ok {
+		return GossipSubParams{}, fmt.Errorf("pubsub router is not gossipsub")
+	}
+
+	// the params are never modified after construction, so no need to go through the event loop
+	return gs.params.copy(), nil
+}
+
+func (p *GossipSubParams) validate() error {
+	err := validateOverlay(p.D, p.Dlo, p.Dhi)
+	if err != nil {
+		return err
+	}
+
+	for topic, params := range p.Topics {
+		err := params.validate()
+		if err != nil {
+			return fmt.Errorf("invalid gossipsub parameters for topic %s: %s", topic, err)
+		}
+	}
+
+	if p.HistoryLength < 1 {
+		return fmt.Errorf("invalid HistoryLength; must be at least 1")
+	}
+	if p.HistoryGossip < 1 || p.HistoryGossip > p.HistoryLength {
+		return fmt.Errorf("invalid HistoryGossip; must be at least 1 and <= HistoryLength")
+	}
+
+	if p.HeartbeatInitialDelay < 0 {
+		return fmt.Errorf("invalid HeartbeatInitialDelay; must be non-negative")
+	}
+	if p.HeartbeatInterval <= 0 {
+		return fmt.Errorf("invalid HeartbeatInterval; must be positive")
+	}
+	if p.FanoutTTL <= 0 {
+		return fmt.Errorf("invalid FanoutTTL; must be positive")
+	}
+
+	if p.PruneBackoff < time.Second {
+		return fmt.Errorf("invalid PruneBackoff; must be at least 1s")
+	}
+	if p.PrunePeers < 0 {
+		return fmt.Errorf("invalid PrunePeers; must be non-negative")
+	}
+	if p.Connectors < 0 {
+		return fmt.Errorf("invalid Connectors; must be non-negative")
+	}
+	if p.MaxPendingConnections < 0 {
+		return fmt.Errorf("invalid MaxPendingConnections; must be non-negative")
+	}
+	if p.ConnectionTimeout <= 0 {
+		return fmt.Errorf("invalid ConnectionTimeout; must be positive")
+	}
+
+	return nil
+}
+
+func (p *GossipSubTopicParams) validate() error {
+	return validateOverlay(p.D, p.Dlo, p.Dhi)
+}
+
+func validateOverlay(d, dlo, dhi int) error {
+	if d < 1 {
+		return fmt.Errorf("invalid D; must be at least 1")
+	}
+	if dlo < 0 || dlo > d {
+		return fmt.Errorf("invalid Dlo; must be non-negative and <= D")
+	}
+	if dhi < d {
+		return fmt.Errorf("invalid Dhi; must be >= D")
+	}
+	return nil
+}
+
+func (p *GossipSubParams) copy() GossipSubParams {
+	res := *p
+	if p.Topics != nil {
+		res.Topics = make(map[string]*GossipSubTopicParams, len(p.Topics))
+		for topic, params := range p.Topics {
+			tparams := *params
+			res.Topics[topic] = &tparams
+		}
+	}
+	return res
+}
+
+// overlay returns the overlay parameters for a topic, taking overrides into account.
+func (p *GossipSubParams) overlay(topic string) (d, dlo, dhi int) {
+	tparams, ok := p.Topics[topic]
+	if ok {
+		return tparams.D, tparams.Dlo, tparams.Dhi
+	}
+	return p.D, p.Dlo, p.Dhi
+}
@@ -3,12 +3,18 @@ package pubsub
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"fmt"
 	"math/rand"
 	"testing"
 	"time"
 
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
+
 	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
 )
 
 func getGossipsubs(ctx context.Context, hs []host.Host, opts ...Option) []*PubSub {
@@ -255,14 +261,13 @@ func TestGossipsubFanoutMaintenance(t *testing.T) {
 }
 
 func TestGossipsubFanoutExpiry(t *testing.T) {
-	GossipSubFanoutTTL = 1 * time.Second
-	defer func() { GossipSubFanoutTTL = 60 * time.Second }()
-
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	hosts := getNetHosts(t, ctx, 10)
 
-	psubs := getGossipsubs(ctx, hosts)
+	params := DefaultGossipSubParams()
+	params.FanoutTTL = 1 * time.Second
+	psubs := getGossipsubs(ctx, hosts, WithGossipSubParams(params))
 
 	var msgs []*Subscription
 	for _, ps := range psubs[1:] {
@@ -919,3 +924,555 @@ func TestGossipsubTreeTopology(t *testing.T) {
 
 	checkMessageRouting(t, "fizzbuzz", []*PubSub{psubs[9], psubs[3]}, chs)
 }
+
+func TestGossipsubContentAddressedMessageId(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hosts := getNetHosts(t, ctx, 10)
+
+	msgIdFn := func(pmsg *pb.Message) string {
+		h := sha256.Sum256(pmsg.GetData())
+		return string(h[:])
+	}
+
+	psubs := getGossipsubs(ctx, hosts, WithMessageIdFn(msgIdFn))
+
+	var msgs []*Subscription
+	for _, ps := range psubs {
+		subch, err := ps.Subscribe("foobar")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msgs = append(msgs, subch)
+	}
+
+	denseConnect(t, hosts)
+
+	// wait for heartbeats to build mesh
+	time.Sleep(time.Second * 2)
+
+	for i := 0; i < 10; i++ {
+		msg := []byte(fmt.Sprintf("%d same content from everyone %d", i, i))
+
+		// every node publishes the same content; it should be delivered once
+		for _, ps := range psubs {
+			ps.Publish("foobar", msg)
+		}
+
+		for _, sub := range msgs {
+			got, err := sub.Next(ctx)
+			if err != nil {
+				t.Fatal(sub.err)
+			}
+			if !bytes.Equal(msg, got.Data) {
+				t.Fatal("got wrong message!")
+			}
+		}
+	}
+
+	// give duplicates a chance to arrive
+	time.Sleep(time.Millisecond * 100)
+
+	for _, sub := range msgs {
+		select {
+		case msg := <-sub.ch:
+			t.Fatalf("got duplicate message: %s", msg.Data)
+		default:
+		}
+	}
+}
+
+func TestGossipsubNegativeScore(t *testing.T) {
+	// in this test we score sinkhole a peer to exclude it from the mesh and graylist it
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hosts := getNetHosts(t, ctx, 20)
+
+	psubs := getGossipsubs(ctx, hosts,
+		WithPeerScore(
+			&PeerScoreParams{
+				AppSpecificScore: func(p peer.ID) float64 {
+					if p == hosts[0].ID() {
+						return -1000
+					}
+					return 0
+				},
+				AppSpecificWeight: 1,
+				DecayInterval:     time.Second,
+				DecayToZero:       0.01,
+			},
+			&PeerScoreThresholds{
+				GossipThreshold:   -10,
+				PublishThreshold:  -100,
+				GraylistThreshold: -500,
+			}))
+
+	var subs []*Subscription
+	for _, ps := range psubs {
+		sub, err := ps.Subscribe("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		subs = append(subs, sub)
+	}
+
+	denseConnect(t, hosts)
+
+	// wait for heartbeats to build mesh
+	time.Sleep(time.Second * 2)
+
+	for i := 0; i < 20; i++ {
+		msg := []byte(fmt.Sprintf("message %d", i))
+		psubs[i%20].Publish("test", msg)
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// let the sinkholed peer try to emit gossip as well
+	time.Sleep(2 * time.Second)
+
+	// checks:
+	// 1. peer 0 should only receive its own message
+	// 2. peers 1-20 should not receive a message from peer 0, because it's not part of the mesh
+	//    and its RPCs are dropped
+	collectAll := func(sub *Subscription) []*Message {
+		var res []*Message
+		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+
+		for {
+			msg, err := sub.Next(ctx)
+			if err != nil {
+				break
+			}
+
+			res = append(res, msg)
+		}
+
+		return res
+	}
+
+	count := len(collectAll(subs[0]))
+	if count != 1 {
+		t.Fatalf("expected 1 message but got %d instead", count)
+	}
+
+	for _, sub := range subs[1:] {
+		all := collectAll(sub)
+		for _, m := range all {
+			if m.ReceivedFrom == hosts[0].ID() {
+				t.Fatal("received message from sinkholed peer")
+			}
+		}
+	}
+}
+
+func TestGossipsubPruneBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hosts := getNetHosts(t, ctx, 2)
+
+	psubs := getGossipsubs(ctx, hosts,
+		WithPeerScore(
+			&PeerScoreParams{
+				AppSpecificScore:       func(peer.ID) float64 { return 0 },
+				BehaviourPenaltyWeight: -1,
+				BehaviourPenaltyDecay:  0.9,
+				DecayInterval:          time.Second,
+				DecayToZero:            0.01,
+			},
+			&PeerScoreThresholds{
+				GossipThreshold:   -10,
+				PublishThreshold:  -100,
+				GraylistThreshold: -1000,
+			}))
+
+	for _, ps := range psubs {
+		_, err := ps.Subscribe("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	connect(t, hosts[0], hosts[1])
+
+	// wait for heartbeats to build mesh
+	time.Sleep(time.Second * 2)
+
+	gs := psubs[0].rt.(*GossipSubRouter)
+	p1 := hosts[1].ID()
+	topic := "test"
+
+	inMesh := func() bool {
+		res := make(chan bool, 1)
+		psubs[0].eval <- func() {
+			_, ok := gs.mesh[topic][p1]
+			res <- ok
+		}
+		return <-res
+	}
+
+	if !inMesh() {
+		t.Fatal("expected peer to be in the mesh")
+	}
+
+	// the peer prunes us with a backoff; we should not regraft it in the heartbeat
+	backoff := uint64(60)
+	prune := &pb.ControlMessage{Prune: []*pb.ControlPrune{{TopicID: &topic, Backoff: &backoff}}}
+	done := make(chan struct{})
+	psubs[0].eval <- func() {
+		gs.handlePrune(p1, prune)
+		gs.heartbeat()
+		close(done)
+	}
+	<-done
+
+	if inMesh() {
+		t.Fatal("expected pruned peer to be backed off")
+	}
+
+	// the peer tries to GRAFT during the backoff; it should be rejected with a backoff and penalized
+	graft := &pb.ControlMessage{Graft: []*pb.ControlGraft{{TopicID: &topic}}}
+	res := make(chan []*pb.ControlPrune, 1)
+	psubs[0].eval <- func() {
+		res <- gs.handleGraft(p1, graft)
+	}
+	cprune := <-res
+
+	if len(cprune) != 1 || cprune[0].GetTopicID() != topic {
+		t.Fatalf("expected PRUNE for %s, got %v", topic, cprune)
+	}
+	if cprune[0].GetBackoff() == 0 {
+		t.Fatal("expected PRUNE to carry a backoff")
+	}
+	if inMesh() {
+		t.Fatal("expected GRAFT to be rejected during backoff")
+	}
+	if score := gs.score.Score(p1); score >= 0 {
+		t.Fatalf("expected negative score for backoff violation, got %f", score)
+	}
+}
+
+func TestGossipsubPeerExchange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hosts := getNetHosts(t, ctx, 3)
+
+	psubs := getGossipsubs(ctx, hosts, WithPeerExchange(true))
+
+	for _, ps := range psubs {
+		_, err := ps.Subscribe("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// hosts 1 and 2 only know about host 0; host 0 dials them, so it knows their addresses
+	connect(t, hosts[1], hosts[0])
+	connect(t, hosts[2], hosts[0])
+
+	// wait for heartbeats to build mesh
+	time.Sleep(time.Second * 2)
+
+	if hosts[1].Network().Connectedness(hosts[2].ID()) == network.Connected {
+		t.Fatal("hosts 1 and 2 should not be connected yet")
+	}
+
+	// host 0 prunes host 1 with PX
+	gs0 := psubs[0].rt.(*GossipSubRouter)
+	res := make(chan *pb.ControlPrune, 1)
+	psubs[0].eval <- func() {
+		res <- gs0.makePrune(hosts[1].ID(), "test", true)
+	}
+	prune := <-res
+
+	if len(prune.GetPeers()) != 1 {
+		t.Fatalf("expected 1 peer in PX, got %d", len(prune.GetPeers()))
+	}
+	pi := prune.GetPeers()[0]
+	if peer.ID(pi.PeerID) != hosts[2].ID() {
+		t.Fatal("expected host 2 in PX")
+	}
+	if pi.SignedPeerRecord == nil {
+		t.Fatal("expected signed peer record in PX")
+	}
+	var rec pb.PeerRecord
+	err := rec.Unmarshal(pi.SignedPeerRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer.ID(rec.Signer) != hosts[2].ID() {
+		t.Fatal("expected the peer record to be signed by host 2 itself")
+	}
+
+	gs1 := psubs[1].rt.(*GossipSubRouter)
+	psubs[1].eval <- func() {
+		gs1.handlePrune(hosts[0].ID(), &pb.ControlMessage{Prune: []*pb.ControlPrune{prune}})
+	}
+
+	// wait for the PX connection
+	time.Sleep(time.Second)
+
+	if hosts[1].Network().Connectedness(hosts[2].ID()) != network.Connected {
+		t.Fatal("expected host 1 to connect to host 2 through PX")
+	}
+}
+
+func TestGossipsubPeerExchangeBadRecord(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hosts := getNetHosts(t, ctx, 3)
+
+	psubs := getGossipsubs(ctx, hosts[:2])
+	gs := psubs[0].rt.(*GossipSubRouter)
+
+	// a record for host 2 signed by host 2 itself is acceptable from anyone
+	rec := &pb.PeerRecord{PeerID: []byte(hosts[2].ID())}
+	for _, a := range hosts[2].Addrs() {
+		rec.Addrs = append(rec.Addrs, a.Bytes())
+	}
+	err := signPeerRecord(hosts[2].ID(), hosts[2].Peerstore().PrivKey(hosts[2].ID()), rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := rec.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = gs.openPeerRecord(hosts[1].ID(), hosts[2].ID(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// but it is not a record for host 1
+	_, err = gs.openPeerRecord(hosts[1].ID(), hosts[1].ID(), data)
+	if err == nil {
+		t.Fatal("expected record for the wrong peer to be rejected")
+	}
+
+	// a record for host 2 signed by the peer exchanging it is rejected
+	forged := &pb.PeerRecord{PeerID: []byte(hosts[2].ID()), Addrs: rec.Addrs}
+	err = signPeerRecord(hosts[1].ID(), hosts[1].Peerstore().PrivKey(hosts[1].ID()), forged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fdata, err := forged.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = gs.openPeerRecord(hosts[1].ID(), hosts[2].ID(), fdata)
+	if err == nil {
+		t.Fatal("expected record signed by the exchanging peer to be rejected")
+	}
+
+	// and tampering with the addresses invalidates the signature
+	rec.Addrs = rec.Addrs[:0]
+	data, err = rec.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = gs.openPeerRecord(hosts[1].ID(), hosts[2].ID(), data)
+	if err == nil {
+		t.Fatal("expected tampered record to be rejected")
+	}
+}
+
+func TestGossipsubV10Interop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hosts := getNetHosts(t, ctx, 20)
+
+	gsubs := getGossipsubs(ctx, hosts[:15], WithPeerExchange(true))
+	// the remaining hosts only speak gossipsub v1.0
+	osubs := getGossipsubs(ctx, hosts[15:], func(ps *PubSub) error {
+		ps.rt.(*GossipSubRouter).protos = []protocol.ID{GossipSubID_v10, FloodSubID}
+		return nil
+	})
+	psubs := append(gsubs, osubs...)
+
+	var msgs []*Subscription
+	for _, ps := range psubs {
+		subch, err := ps.Subscribe("foobar")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msgs = append(msgs, subch)
+	}
+
+	sparseConnect(t, hosts)
+	connect(t, hosts[0], hosts[15])
+
+	// wait for heartbeats to build mesh
+	time.Sleep(time.Second * 2)
+
+	for i := 0; i < 50; i++ {
+		msg := []byte(fmt.Sprintf("%d it's not a floooooood %d", i, i))
+
+		owner := rand.Intn(len(psubs))
+
+		psubs[owner].Publish("foobar", msg)
+
+		for _, sub := range msgs {
+			got, err := sub.Next(ctx)
+			if err != nil {
+				t.Fatal(sub.err)
+			}
+			if !bytes.Equal(msg, got.Data) {
+				t.Fatal("got wrong message!")
+			}
+		}
+	}
+
+	// v1.0 peers must not be sent a backoff or PX
+	gs := psubs[0].rt.(*GossipSubRouter)
+	res := make(chan *pb.ControlPrune, 1)
+	psubs[0].eval <- func() {
+		res <- gs.makePrune(hosts[15].ID(), "foobar", true)
+	}
+	prune := <-res
+	if prune.Backoff != nil || len(prune.Peers) != 0 {
+		t.Fatal("expected plain PRUNE for gossipsub v1.0 peer")
+	}
+}
+
+func TestGossipsubParamsValidation(t *testing.T) {
+	params := DefaultGossipSubParams()
+	if err := params.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []func(*GossipSubParams){
+		func(p *GossipSubParams) { p.D = 0 },
+		func(p *GossipSubParams) { p.Dlo = p.D + 1 },
+		func(p *GossipSubParams) { p.Dhi = p.D - 1 },
+		func(p *GossipSubParams) { p.HistoryGossip = p.HistoryLength + 1 },
+		func(p *GossipSubParams) { p.HeartbeatInterval = 0 },
+		func(p *GossipSubParams) { p.PruneBackoff = time.Millisecond },
+		func(p *GossipSubParams) {
+			p.Topics = map[string]*GossipSubTopicParams{"foobar": {D: 8, Dlo: 10, Dhi: 12}}
+		},
+	}
+	for i, mutate := range invalid {
+		params := DefaultGossipSubParams()
+		mutate(&params)
+		if err := params.validate(); err == nil {
+			t.Errorf("expected invalid params %d to fail validation", i)
+		}
+	}
+}
+
+func TestGossipsubTopicParams(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hosts := getNetHosts(t, ctx, 20)
+
+	params := DefaultGossipSubParams()
+	params.D = 3
+	params.Dlo = 2
+	params.Dhi = 4
+	params.Topics = map[string]*GossipSubTopicParams{
+		"hot": {D: 10, Dlo: 8, Dhi: 12},
+	}
+	psubs := getGossipsubs(ctx, hosts, WithGossipSubParams(params))
+
+	// the router keeps its own copy of the params
+	params.Topics["hot"].D = 1
+	cfg, err := GossipSubParamsOf(psubs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.D != 3 || cfg.Topics["hot"].D != 10 {
+		t.Fatal("unexpected router params")
+	}
+
+	for _, ps := range psubs {
+		for _, topic := range []string{"hot", "cold"} {
+			_, err := ps.Subscribe(topic)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	denseConnect(t, hosts)
+
+	// wait for heartbeats to build mesh
+	time.Sleep(time.Second * 2)
+
+	meshSize := func(ps *PubSub, topic string) int {
+		res := make(chan int, 1)
+		ps.eval <- func() {
+			res <- len(ps.rt.(*GossipSubRouter).mesh[topic])
+		}
+		return <-res
+	}
+
+	// individual mesh sizes fluctuate as peers graft and prune each other, so compare averages
+	var hot, cold float64
+	for _, ps := range psubs {
+		hot += float64(meshSize(ps, "hot"))
+		cold += float64(meshSize(ps, "cold"))
+	}
+	hot /= float64(len(psubs))
+	cold /= float64(len(psubs))
+
+	if hot < 8 || hot > 12 {
+		t.Errorf("expected average hot mesh size between 8 and 12, got %f", hot)
+	}
+	if cold < 2 || cold > 4 {
+		t.Errorf("expected average cold mesh size between 2 and 4, got %f", cold)
+	}
+}
+
+func TestGossipsubClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hosts := getNetHosts(t, ctx, 2)
+
+	psubs := getGossipsubs(ctx, hosts)
+
+	for _, ps := range psubs {
+		_, err := ps.Subscribe("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	connect(t, hosts[0], hosts[1])
+
+	// wait for heartbeats to build mesh
+	time.Sleep(time.Second * 2)
+
+	gs := psubs[0].rt.(*GossipSubRouter)
+	p1 := hosts[1].ID()
+
+	inMesh := func() bool {
+		res := make(chan bool, 1)
+		psubs[0].eval <- func() {
+			_, ok := gs.mesh["test"][p1]
+			res <- ok
+		}
+		return <-res
+	}
+
+	if !inMesh() {
+		t.Fatal("expected peer to be in the mesh")
+	}
+
+	cctx, ccancel := context.WithTimeout(ctx, 5*time.Second)
+	defer ccancel()
+	err := psubs[1].Close(cctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the closing peer prunes us
+	time.Sleep(time.Millisecond * 100)
+	if inMesh() {
+		t.Fatal("expected closed peer to have pruned the mesh")
+	}
+}
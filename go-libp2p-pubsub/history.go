@@ -0,0 +1,412 @@
+package pubsub
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
+
+	"github.com/libp2p/go-libp2p-core/helpers"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+
+	ggio "github.com/gogo/protobuf/io"
+	proto "github.com/gogo/protobuf/proto"
+)
+
+// HistoryID is the protocol ID of the history protocol, over which peers request the
+// messages we retain, to catch up on what was published while they were offline.
+const HistoryID = protocol.ID("/pubsub/history/1.0.0")
+
+// MaxHistoryMessages is the maximum number of messages served by a history request
+const MaxHistoryMessages = 1024
+
+// historyTimeout bounds the time we spend serving a history request
+const historyTimeout = 30 * time.Second
+
+// maxPeerHistoryStreams is the maximum number of history requests we serve concurrently
+// to a peer; further requests are reset.
+const maxPeerHistoryStreams = 2
+
+// HistoryQuery selects the retained messages of a topic
+type HistoryQuery struct {
+	Topic string
+	// From restricts the query to the messages of a publisher, with seqnos between
+	// FirstSeqno and LastSeqno; LastSeqno 0 is no upper bound.
+	From                  peer.ID
+	FirstSeqno, LastSeqno uint64
+	// Since restricts the query to the messages received since; zero for all.
+	Since time.Time
+	// Limit is the maximum number of messages; 0 or above MaxHistoryMessages for
+	// MaxHistoryMessages.
+	Limit int
+}
+
+// matches returns whether a message received at a time matches the query
+func (q *HistoryQuery) matches(msg *Message, received time.Time) bool {
+	if !containsTopic(msg.GetTopicIDs(), q.Topic) {
+		return false
+	}
+	if !q.Since.IsZero() && received.Before(q.Since) {
+		return false
+	}
+	if q.From == "" {
+		return true
+	}
+
+	if msg.GetFrom() != q.From {
+		return false
+	}
+	seqno, ok := msgSeqno(msg)
+	return ok && seqno >= q.FirstSeqno && (q.LastSeqno == 0 || seqno <= q.LastSeqno)
+}
+
+func (q *HistoryQuery) limit() int {
+	if q.Limit <= 0 || q.Limit > MaxHistoryMessages {
+		return MaxHistoryMessages
+	}
+	return q.Limit
+}
+
+func (q *HistoryQuery) request() *pb.HistoryRequest {
+	req := &pb.HistoryRequest{
+		Topic: proto.String(q.Topic),
+		Limit: proto.Uint32(uint32(q.limit())),
+	}
+	if q.From != "" {
+		req.From = []byte(q.From)
+		req.FirstSeqno = proto.Uint64(q.FirstSeqno)
+		req.LastSeqno = proto.Uint64(q.LastSeqno)
+	}
+	if !q.Since.IsZero() {
+		req.Since = proto.Int64(q.Since.UnixNano())
+	}
+	return req
+}
+
+func historyQuery(req *pb.HistoryRequest) HistoryQuery {
+	q := HistoryQuery{
+		Topic:      req.GetTopic(),
+		From:       peer.ID(req.GetFrom()),
+		FirstSeqno: req.GetFirstSeqno(),
+		LastSeqno:  req.GetLastSeqno(),
+		Limit:      int(req.GetLimit()),
+	}
+	if req.Since != nil {
+		q.Since = time.Unix(0, req.GetSince())
+	}
+	return q
+}
+
+func containsTopic(topics []string, topic string) bool {
+	for _, t := range topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// MessageStore retains the messages we deliver, to serve them over the history protocol.
+// Messages are put from the event loop and queried from the goroutines serving history
+// requests, so implementations must be safe for concurrent use.
+type MessageStore interface {
+	// Put retains a message received at a time.
+	Put(msg *Message, received time.Time)
+	// Query returns the retained messages matching a query, oldest first, up to the
+	// limit of the query.
+	Query(q HistoryQuery) []*Message
+}
+
+// WithMessageHistory is an option to retain the messages we deliver and forward in a store,
+// and serve them to our peers over the history protocol. Chunked messages are retained as
+// their signed fragments.
+func WithMessageHistory(store MessageStore) Option {
+	return func(p *PubSub) error {
+		if store == nil {
+			return errors.New("message store must not be nil")
+		}
+		p.history = store
+		return nil
+	}
+}
+
+// memoryMessageStore is a message store retaining the last messages in a ring, up to a
+// capacity, evicting the oldest messages first.
+type memoryMessageStore struct {
+	mx sync.Mutex
+
+	retention time.Duration
+
+	entries []storedMessage
+	// next is the index of the next entry written, size the number of entries
+	next, size int
+
+	now func() time.Time
+}
+
+type storedMessage struct {
+	msg      *Message
+	received time.Time
+}
+
+// NewMemoryMessageStore returns a message store retaining up to capacity messages in
+// memory, for up to retention; a zero retention retains the messages until they are
+// evicted by newer ones.
+func NewMemoryMessageStore(capacity int, retention time.Duration) (MessageStore, error) {
+	if capacity <= 0 {
+		return nil, errors.New("invalid capacity; must be positive")
+	}
+	if retention < 0 {
+		return nil, errors.New("invalid retention; must not be negative")
+	}
+
+	return newMemoryMessageStore(capacity, retention, time.Now), nil
+}
+
+func newMemoryMessageStore(capacity int, retention time.Duration, now func() time.Time) *memoryMessageStore {
+	return &memoryMessageStore{
+		retention: retention,
+		entries:   make([]storedMessage, capacity),
+		now:       now,
+	}
+}
+
+func (s *memoryMessageStore) Put(msg *Message, received time.Time) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.entries[s.next] = storedMessage{msg: msg, received: received}
+	s.next = (s.next + 1) % len(s.entries)
+	if s.size < len(s.entries) {
+		s.size++
+	}
+}
+
+// Query matches a snapshot of the entries, so that the lock is only held while copying them.
+func (s *memoryMessageStore) Query(q HistoryQuery) []*Message {
+	s.mx.Lock()
+	oldest := (s.next - s.size + len(s.entries)) % len(s.entries)
+	entries := make([]storedMessage, 0, s.size)
+	if oldest+s.size <= len(s.entries) {
+		entries = append(entries, s.entries[oldest:oldest+s.size]...)
+	} else {
+		entries = append(entries, s.entries[oldest:]...)
+		entries = append(entries, s.entries[:s.next]...)
+	}
+	s.mx.Unlock()
+
+	now := s.now()
+	limit := q.limit()
+
+	var res []*Message
+	for _, e := range entries {
+		if len(res) == limit {
+			break
+		}
+		if s.retention > 0 && now.Sub(e.received) > s.retention {
+			continue
+		}
+		if q.matches(e.msg, e.received) {
+			res = append(res, e.msg)
+		}
+	}
+	return res
+}
+
+// retainMsg puts a delivered message in the message store, or its fragments if it was
+// chunked, as the fragments carry the signatures.
+// Only called from processLoop.
+func (p *PubSub) retainMsg(msg *Message) {
+	if p.history == nil {
+		return
+	}
+
+	now := time.Now()
+	if msg.fragments != nil {
+		for _, frag := range msg.fragments {
+			p.history.Put(frag, now)
+		}
+		return
+	}
+	p.history.Put(msg, now)
+}
+
+// handleHistoryStream serves a history request: it reads the request, and responds with
+// the matching messages before closing the stream.
+func (p *PubSub) handleHistoryStream(s network.Stream) {
+	pid := s.Conn().RemotePeer()
+	s.SetDeadline(time.Now().Add(historyTimeout))
+
+	blacklisted := make(chan bool, 1)
+	select {
+	case p.eval <- func() { blacklisted <- p.blacklist.Contains(pid) }:
+	case <-p.ctx.Done():
+		s.Reset()
+		return
+	}
+	if <-blacklisted {
+		p.logger.Debugw("ignoring history request from blacklisted peer", "peer", pid)
+		s.Reset()
+		return
+	}
+
+	if !p.acquireHistoryStream(pid) {
+		p.logger.Debugw("too many concurrent history requests from peer", "peer", pid)
+		s.Reset()
+		return
+	}
+	defer p.releaseHistoryStream(pid)
+
+	var req pb.HistoryRequest
+	r := ggio.NewDelimitedReader(s, p.maxMessageSize)
+	err := r.ReadMsg(&req)
+	if err != nil {
+		p.logger.Infow("error reading history request", "peer", pid, "error", err)
+		s.Reset()
+		return
+	}
+
+	q := historyQuery(&req)
+	msgs := p.history.Query(q)
+	p.logger.Debugw("serving history request", "peer", pid, "topic", q.Topic, "messages", len(msgs))
+
+	bufw := bufio.NewWriter(s)
+	wc := ggio.NewDelimitedWriter(bufw)
+	for _, msg := range msgs {
+		err = wc.WriteMsg(msg.Message)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = bufw.Flush()
+	}
+	if err != nil {
+		p.logger.Infow("error writing history response", "peer", pid, "error", err)
+		s.Reset()
+		return
+	}
+
+	helpers.FullClose(s)
+}
+
+// acquireHistoryStream reserves one of the concurrent history requests served to a peer,
+// returning false if the peer has too many requests being served.
+func (p *PubSub) acquireHistoryStream(pid peer.ID) bool {
+	p.historyMx.Lock()
+	defer p.historyMx.Unlock()
+
+	if p.historyStreams[pid] >= maxPeerHistoryStreams {
+		return false
+	}
+	p.historyStreams[pid]++
+	return true
+}
+
+func (p *PubSub) releaseHistoryStream(pid peer.ID) {
+	p.historyMx.Lock()
+	defer p.historyMx.Unlock()
+
+	if p.historyStreams[pid]--; p.historyStreams[pid] == 0 {
+		delete(p.historyStreams, pid)
+	}
+}
+
+// RequestHistory asks a connected peer serving the history protocol for the messages it
+// retains matching a query. The messages go through the validation pipeline like the
+// messages the peer forwards to us, so that they are delivered to our subscriptions once
+// validated, and dropped if we have already seen them; messages in topics we are not
+// subscribed to are dropped. Recovered messages are only delivered locally; they are not
+// forwarded to our peers. It returns the number of messages received from the peer.
+func (p *PubSub) RequestHistory(ctx context.Context, pid peer.ID, q HistoryQuery) (int, error) {
+	if q.Topic == "" {
+		return 0, errors.New("history query without a topic")
+	}
+
+	s, err := p.host.NewStream(ctx, pid, HistoryID)
+	if err != nil {
+		return 0, err
+	}
+
+	// reset the stream to unblock reads when the context is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Reset()
+		case <-done:
+		}
+	}()
+
+	err = ggio.NewDelimitedWriter(s).WriteMsg(q.request())
+	if err != nil {
+		s.Reset()
+		return 0, err
+	}
+	s.Close()
+
+	limit := q.limit()
+	r := ggio.NewDelimitedReader(s, p.maxMessageSize)
+	n := 0
+	for {
+		pmsg := new(pb.Message)
+		err = r.ReadMsg(pmsg)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			s.Reset()
+			if ctx.Err() != nil {
+				return n, ctx.Err()
+			}
+			return n, err
+		}
+
+		if n == limit || !containsTopic(pmsg.GetTopicIDs(), q.Topic) {
+			s.Reset()
+			return n, fmt.Errorf("peer %s sent an invalid history response", pid)
+		}
+		n++
+
+		err = p.pushHistoryMsg(ctx, pid, pmsg)
+		if err != nil {
+			s.Reset()
+			return n, err
+		}
+	}
+}
+
+// pushHistoryMsg pushes a message received from a history response in the validation
+// pipeline, waiting for room in the pipeline.
+func (p *PubSub) pushHistoryMsg(ctx context.Context, pid peer.ID, pmsg *pb.Message) error {
+	res := make(chan *validateReq, 1)
+	push := func() {
+		if !p.subscribedToMsg(pmsg) {
+			res <- nil
+			return
+		}
+		res <- p.admitMsg(pid, &Message{Message: pmsg, ReceivedFrom: pid, recovered: true})
+	}
+
+	select {
+	case p.eval <- push:
+	case <-p.ctx.Done():
+		return p.closedErr()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	req := <-res
+	if req == nil {
+		return nil
+	}
+	return p.val.enqueueWait(ctx, req)
+}
@@ -0,0 +1,264 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	ggio "github.com/gogo/protobuf/io"
+)
+
+func TestMemoryMessageStore(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	s := newMemoryMessageStore(4, time.Minute, clock.now)
+
+	a, b := peer.ID("a"), peer.ID("b")
+	put := func(from peer.ID, seqno uint64, topic string) {
+		msg := makeSeqnoMessage(from, seqno)
+		msg.TopicIDs = []string{topic}
+		s.Put(msg, clock.now())
+		clock.advance(time.Second * 10)
+	}
+
+	put(a, 1, "foobar")
+	put(a, 2, "foobar")
+	put(b, 1, "other")
+	put(a, 3, "foobar")
+	put(b, 2, "foobar")
+
+	// the oldest message was evicted
+	res := s.Query(HistoryQuery{Topic: "foobar"})
+	if !equalSeqnos(seqnos(res), []uint64{2, 3, 2}) {
+		t.Fatalf("unexpected messages: %v", seqnos(res))
+	}
+
+	res = s.Query(HistoryQuery{Topic: "foobar", From: a, FirstSeqno: 3})
+	if !equalSeqnos(seqnos(res), []uint64{3}) {
+		t.Fatalf("unexpected messages: %v", seqnos(res))
+	}
+
+	res = s.Query(HistoryQuery{Topic: "foobar", From: a, LastSeqno: 2})
+	if !equalSeqnos(seqnos(res), []uint64{2}) {
+		t.Fatalf("unexpected messages: %v", seqnos(res))
+	}
+
+	res = s.Query(HistoryQuery{Topic: "foobar", Since: time.Unix(1030, 0)})
+	if !equalSeqnos(seqnos(res), []uint64{3, 2}) {
+		t.Fatalf("unexpected messages: %v", seqnos(res))
+	}
+
+	res = s.Query(HistoryQuery{Topic: "foobar", Limit: 1})
+	if !equalSeqnos(seqnos(res), []uint64{2}) {
+		t.Fatalf("unexpected messages: %v", seqnos(res))
+	}
+
+	// messages expire after the retention
+	clock.advance(time.Second * 25)
+	res = s.Query(HistoryQuery{Topic: "foobar"})
+	if !equalSeqnos(seqnos(res), []uint64{3, 2}) {
+		t.Fatalf("unexpected messages: %v", seqnos(res))
+	}
+
+	_, err := NewMemoryMessageStore(0, time.Minute)
+	if err == nil {
+		t.Fatal("expected an error for an invalid capacity")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 3)
+
+	store, err := NewMemoryMessageStore(16, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	psubs := []*PubSub{
+		getPubsub(ctx, hosts[0], WithMessageHistory(store)),
+		getPubsub(ctx, hosts[1]),
+	}
+
+	_, err = psubs[0].Subscribe("foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// messages are published while the subscriber is offline
+	for i := 0; i < 5; i++ {
+		err := psubs[0].Publish("foobar", []byte(fmt.Sprintf("msg%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	sub, err := psubs[1].Subscribe("foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = psubs[1].RegisterTopicValidator("foobar", func(_ context.Context, _ peer.ID, msg *Message) bool {
+		return string(msg.Data) != "msg3"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	connect(t, hosts[0], hosts[1])
+
+	// recovered messages are delivered once validated
+	n, err := psubs[1].RequestHistory(ctx, hosts[0].ID(), HistoryQuery{Topic: "foobar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Fatalf("expected 5 messages, got %d", n)
+	}
+
+	// messages are validated concurrently, so they may be delivered out of order
+	received := make(map[string]*Message)
+	for i := 0; i < 4; i++ {
+		ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+		msg, err := sub.Next(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		received[string(msg.Data)] = msg
+	}
+	for _, data := range []string{"msg0", "msg1", "msg2", "msg4"} {
+		if received[data] == nil {
+			t.Fatalf("expected to receive %s", data)
+		}
+	}
+	first := received["msg0"]
+
+	// messages we have already seen are not delivered again
+	seqno, _ := msgSeqno(first)
+	n, err = psubs[1].RequestHistory(ctx, hosts[0].ID(), HistoryQuery{Topic: "foobar", From: hosts[0].ID(), FirstSeqno: seqno + 1, LastSeqno: seqno + 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 messages, got %d", n)
+	}
+	assertNoReceive(t, ctx, sub)
+
+	// forged messages are rejected by signature validation
+	hosts[2].SetStreamHandler(HistoryID, func(s network.Stream) {
+		var req pb.HistoryRequest
+		err := ggio.NewDelimitedReader(s, DefaultMaxMessageSize).ReadMsg(&req)
+		if err != nil {
+			s.Reset()
+			return
+		}
+
+		forged := *first.Message
+		forged.Data = []byte("forged")
+		forged.Seqno = []byte{0, 0, 0, 0, 0, 0, 0, 1}
+		ggio.NewDelimitedWriter(s).WriteMsg(&forged)
+		s.Close()
+	})
+	connect(t, hosts[1], hosts[2])
+
+	n, err = psubs[1].RequestHistory(ctx, hosts[2].ID(), HistoryQuery{Topic: "foobar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 message, got %d", n)
+	}
+	assertNoReceive(t, ctx, sub)
+
+	rejected := psubs[1].Metrics().(*MemoryMetrics).Snapshot().Topics["foobar"].Rejected
+	if rejected[rejectInvalidSignature] != 1 || rejected[rejectValidationFailed] != 1 {
+		t.Fatalf("unexpected rejections: %v", rejected)
+	}
+
+	// peers without a message store don't speak the protocol
+	_, err = psubs[0].RequestHistory(ctx, hosts[1].ID(), HistoryQuery{Topic: "foobar"})
+	if err == nil {
+		t.Fatal("expected an error requesting history from a peer without a message store")
+	}
+}
+
+func TestHistoryNotForwarded(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 3)
+
+	store, err := NewMemoryMessageStore(16, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	psubs := []*PubSub{
+		getPubsub(ctx, hosts[0], WithMessageHistory(store)),
+		getPubsub(ctx, hosts[1]),
+		getPubsub(ctx, hosts[2]),
+	}
+
+	for i := 0; i < 3; i++ {
+		err := psubs[0].Publish("foobar", []byte(fmt.Sprintf("msg%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// host 2 is only connected to host 1, which recovers the messages from host 0
+	var subs []*Subscription
+	for _, ps := range psubs[1:] {
+		sub, err := ps.Subscribe("foobar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		subs = append(subs, sub)
+	}
+	connect(t, hosts[0], hosts[1])
+	connect(t, hosts[1], hosts[2])
+	time.Sleep(time.Millisecond * 100)
+
+	n, err := psubs[1].RequestHistory(ctx, hosts[0].ID(), HistoryQuery{Topic: "foobar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 messages, got %d", n)
+	}
+
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+		_, err := subs[0].Next(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// recovered messages are not replayed to the mesh
+	assertNoReceive(t, ctx, subs[1])
+
+	// history requests served concurrently to a peer are limited
+	for i := 0; i < maxPeerHistoryStreams; i++ {
+		if !psubs[0].acquireHistoryStream(hosts[1].ID()) {
+			t.Fatal("expected a history stream to be acquired")
+		}
+	}
+	if psubs[0].acquireHistoryStream(hosts[1].ID()) {
+		t.Fatal("expected too many history streams to be refused")
+	}
+	_, err = psubs[1].RequestHistory(ctx, hosts[0].ID(), HistoryQuery{Topic: "foobar"})
+	if err == nil {
+		t.Fatal("expected the history request to be reset")
+	}
+	psubs[0].releaseHistoryStream(hosts[1].ID())
+	if !psubs[0].acquireHistoryStream(hosts[1].ID()) {
+		t.Fatal("expected a released history stream to be acquired")
+	}
+}
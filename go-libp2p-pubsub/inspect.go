@@ -0,0 +1,221 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// Snapshot is a consistent copy of the state of a pubsub instance and its router, for
+// debugging. Peers are sorted by ID.
+type Snapshot struct {
+	Time   time.Time `json:"time"`
+	Host   peer.ID   `json:"host"`
+	Router string    `json:"router"`
+
+	Peers  []PeerSnapshot            `json:"peers"`
+	Topics map[string]*TopicSnapshot `json:"topics"`
+
+	// Blacklist is nil if the blacklist does not support iteration
+	Blacklist []peer.ID `json:"blacklist,omitempty"`
+
+	// GossipSub is the state specific to gossipsub; nil for other routers
+	GossipSub *GossipSubSnapshot `json:"gossipsub,omitempty"`
+}
+
+// PeerSnapshot is the state of a peer
+type PeerSnapshot struct {
+	ID peer.ID `json:"id"`
+	// Protocol is the protocol of the peer, if tracked by the router
+	Protocol protocol.ID `json:"protocol,omitempty"`
+	// Queued is the number of RPCs in the outbound queue of the peer
+	Queued int `json:"queued"`
+}
+
+// TopicSnapshot is the state of a topic
+type TopicSnapshot struct {
+	// Joined is whether we have a topic handle
+	Joined        bool `json:"joined"`
+	Subscriptions int  `json:"subscriptions"`
+	Relays        int  `json:"relays"`
+	// Peers are the peers subscribed to the topic
+	Peers     []peer.ID          `json:"peers"`
+	Validator *ValidatorSnapshot `json:"validator,omitempty"`
+
+	// Mesh, Fanout and LastPublish are only tracked by gossipsub
+	Mesh        []peer.ID  `json:"mesh,omitempty"`
+	Fanout      []peer.ID  `json:"fanout,omitempty"`
+	LastPublish *time.Time `json:"last_publish,omitempty"`
+}
+
+// ValidatorSnapshot describes the validator registered for a topic
+type ValidatorSnapshot struct {
+	Timeout     time.Duration `json:"timeout"`
+	Concurrency int           `json:"concurrency"`
+	Active      int           `json:"active"`
+	Inline      bool          `json:"inline"`
+}
+
+// GossipSubSnapshot is the state specific to gossipsub
+type GossipSubSnapshot struct {
+	// PendingGossip is the number of message IDs of the IHAVE gossip pending for each peer
+	PendingGossip map[peer.ID]int `json:"pending_gossip"`
+	// PendingControl are the control messages pending for each peer
+	PendingControl map[peer.ID]ControlSnapshot `json:"pending_control"`
+	// Backoff are the peers we have pruned from the mesh of each topic and won't graft until
+	// their backoff expires
+	Backoff      map[string][]peer.ID `json:"backoff"`
+	MessageCache MessageCacheSnapshot `json:"message_cache"`
+}
+
+// ControlSnapshot counts pending control messages
+type ControlSnapshot struct {
+	IHave int `json:"ihave"`
+	IWant int `json:"iwant"`
+	Graft int `json:"graft"`
+	Prune int `json:"prune"`
+}
+
+// MessageCacheSnapshot is the occupancy of the gossipsub message cache
+type MessageCacheSnapshot struct {
+	Messages int `json:"messages"`
+	// History is the number of entries in each window, most recent first
+	History []int `json:"history"`
+	// GossipWindows is the number of windows we gossip about
+	GossipWindows int `json:"gossip_windows"`
+}
+
+// routerInspector is implemented by routers exposing their state in snapshots.
+// It is invoked from the event loop.
+type routerInspector interface {
+	inspect(s *Snapshot)
+}
+
+// Inspect returns a consistent copy of the state of the pubsub instance and its router,
+// taken in the event loop.
+func (p *PubSub) Inspect(ctx context.Context) (*Snapshot, error) {
+	out := make(chan *Snapshot, 1)
+	select {
+	case p.eval <- func() { out <- p.snapshot() }:
+	case <-p.ctx.Done():
+		return nil, p.closedErr()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case s := <-out:
+		return s, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// snapshot copies the state of the instance and its router.
+// Only called from processLoop.
+func (p *PubSub) snapshot() *Snapshot {
+	s := &Snapshot{
+		Time:   time.Now(),
+		Host:   p.host.ID(),
+		Router: fmt.Sprintf("%T", p.rt),
+		Topics: make(map[string]*TopicSnapshot),
+	}
+
+	for pid, q := range p.peers {
+		queued := 0
+		for _, n := range q.Stats().Queued {
+			queued += n
+		}
+		s.Peers = append(s.Peers, PeerSnapshot{ID: pid, Queued: queued})
+	}
+	sort.Slice(s.Peers, func(i, j int) bool { return s.Peers[i].ID < s.Peers[j].ID })
+
+	for topic := range p.myTopics {
+		s.topic(topic).Joined = true
+	}
+	for topic, subs := range p.mySubs {
+		s.topic(topic).Subscriptions = len(subs)
+	}
+	for topic, n := range p.myRelays {
+		s.topic(topic).Relays = n
+	}
+	for topic, tmap := range p.topics {
+		if len(tmap) > 0 {
+			s.topic(topic).Peers = peerList(tmap)
+		}
+	}
+	for topic, val := range p.val.topicVals {
+		s.topic(topic).Validator = &ValidatorSnapshot{
+			Timeout:     val.validateTimeout,
+			Concurrency: cap(val.validateThrottle),
+			Active:      len(val.validateThrottle),
+			Inline:      val.validateInline,
+		}
+	}
+
+	if ib, ok := p.blacklist.(IterableBlacklist); ok {
+		s.Blacklist = []peer.ID{}
+		ib.Iterate(func(pid peer.ID) {
+			s.Blacklist = append(s.Blacklist, pid)
+		})
+		sortPeers(s.Blacklist)
+	}
+
+	if ri, ok := p.rt.(routerInspector); ok {
+		ri.inspect(s)
+	}
+
+	return s
+}
+
+// topic returns the snapshot of a topic, adding it if needed
+func (s *Snapshot) topic(topic string) *TopicSnapshot {
+	ts, ok := s.Topics[topic]
+	if !ok {
+		ts = new(TopicSnapshot)
+		s.Topics[topic] = ts
+	}
+	return ts
+}
+
+// setProtocols sets the protocols of the peers tracked by a router
+func (s *Snapshot) setProtocols(protos map[peer.ID]protocol.ID) {
+	for i := range s.Peers {
+		s.Peers[i].Protocol = protos[s.Peers[i].ID]
+	}
+}
+
+func peerList(pmap map[peer.ID]struct{}) []peer.ID {
+	peers := make([]peer.ID, 0, len(pmap))
+	for pid := range pmap {
+		peers = append(peers, pid)
+	}
+	sortPeers(peers)
+	return peers
+}
+
+func sortPeers(peers []peer.ID) {
+	sort.Slice(peers, func(i, j int) bool { return peers[i] < peers[j] })
+}
+
+// NewInspectHandler returns an HTTP handler serving the snapshots of a pubsub instance as JSON.
+func NewInspectHandler(p *PubSub) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := p.Inspect(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(s)
+	})
+}
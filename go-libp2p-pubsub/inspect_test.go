@@ -0,0 +1,127 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestInspect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 9)
+	psubs := getGossipsubs(ctx, hosts[:3])
+	psubs = append(psubs, getPubsubs(ctx, hosts[3:6])...)
+	psubs = append(psubs, getRandomsubs(ctx, hosts[6:])...)
+
+	for _, ps := range psubs {
+		_, err := ps.Subscribe("foobar")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := psubs[0].RegisterTopicValidator("foobar", func(context.Context, peer.ID, *Message) bool {
+		return true
+	}, WithValidatorInline(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, group := range [][]int{{0, 1, 2}, {3, 4, 5}, {6, 7, 8}} {
+		connect(t, hosts[group[0]], hosts[group[1]])
+		connect(t, hosts[group[0]], hosts[group[2]])
+	}
+
+	// wait for heartbeats to build mesh
+	time.Sleep(time.Second * 2)
+
+	err = psubs[0].Publish("foobar", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	for i, router := range []string{"gossipsub", "floodsub", "randomsub"} {
+		s, err := psubs[3*i].Inspect(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if s.Router != router || s.Host != hosts[3*i].ID() {
+			t.Fatalf("unexpected snapshot of %s router: %+v", router, s)
+		}
+		if len(s.Peers) != 2 {
+			t.Fatalf("expected 2 peers, got %d", len(s.Peers))
+		}
+		if router != "floodsub" && s.Peers[0].Protocol == "" {
+			t.Fatalf("expected the %s router to track the peer protocols", router)
+		}
+
+		ts := s.Topics["foobar"]
+		if ts == nil || ts.Subscriptions != 1 || len(ts.Peers) != 2 {
+			t.Fatalf("unexpected topic snapshot: %+v", ts)
+		}
+		if (router == "gossipsub") != (s.GossipSub != nil) {
+			t.Fatal("expected gossipsub state only for gossipsub")
+		}
+	}
+
+	s, err := psubs[0].Inspect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := s.Topics["foobar"]
+	if len(ts.Mesh) != 2 {
+		t.Fatalf("expected 2 peers in the mesh, got %d", len(ts.Mesh))
+	}
+	if ts.Validator == nil || !ts.Validator.Inline {
+		t.Fatalf("unexpected validator snapshot: %+v", ts.Validator)
+	}
+	mc := s.GossipSub.MessageCache
+	entries := 0
+	for _, n := range mc.History {
+		entries += n
+	}
+	if mc.Messages != 1 || entries != 1 {
+		t.Fatalf("unexpected message cache snapshot: %+v", mc)
+	}
+	if s.Blacklist == nil || len(s.Blacklist) != 0 {
+		t.Fatal("expected an empty blacklist")
+	}
+
+	// the snapshot is served as JSON
+	srv := httptest.NewServer(NewInspectHandler(psubs[0]))
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var res Snapshot
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Host != hosts[0].ID() || len(res.Topics["foobar"].Mesh) != 2 {
+		t.Fatalf("unexpected snapshot served: %+v", res)
+	}
+
+	err = psubs[0].Close(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = psubs[0].Inspect(ctx)
+	if err != ErrPubSubClosed {
+		t.Fatalf("expected ErrPubSubClosed, got %v", err)
+	}
+}
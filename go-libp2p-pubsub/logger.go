@@ -0,0 +1,132 @@
+package pubsub
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
+
+	logging "github.com/ipfs/go-log"
+)
+
+// Logger is a levelled, structured logger. The keysAndValues are alternating field names
+// and values, such as "peer", pid, "topic", topic; the fields we log are peer, topic and
+// msgid, for the peer, topic and ID of the message concerned.
+//
+// The interface matches the levelled methods of zap's SugaredLogger, so that embedding
+// applications can plug in their logger as is.
+type Logger interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+// WithLogger is an option to set the logger of the pubsub instance and its router.
+// By default, we log to the go-log "pubsub" logger, with the fields formatted as key=value.
+// The file tracers and blacklists of the package log to the logger of the instance they
+// are attached to.
+func WithLogger(l Logger) Option {
+	return func(p *PubSub) error {
+		if l == nil {
+			return fmt.Errorf("logger must not be nil")
+		}
+		p.logger = l
+		return nil
+	}
+}
+
+// loggerSetter is implemented by components constructed independently of the pubsub
+// instance, such as tracers and blacklists, which log to the go-log logger until they are
+// attached to an instance.
+type loggerSetter interface {
+	setLogger(Logger)
+}
+
+// goLogger is the default logger, logging to a go-log logger
+type goLogger struct {
+	l logging.StandardLogger
+}
+
+func newGoLogger(l logging.StandardLogger) Logger {
+	return goLogger{l: l}
+}
+
+func (g goLogger) Debugw(msg string, keysAndValues ...interface{}) {
+	g.l.Debug(logFields{msg, keysAndValues})
+}
+
+func (g goLogger) Infow(msg string, keysAndValues ...interface{}) {
+	g.l.Info(logFields{msg, keysAndValues})
+}
+
+func (g goLogger) Warnw(msg string, keysAndValues ...interface{}) {
+	g.l.Warning(logFields{msg, keysAndValues})
+}
+
+func (g goLogger) Errorw(msg string, keysAndValues ...interface{}) {
+	g.l.Error(logFields{msg, keysAndValues})
+}
+
+// logFields is a message with its fields, formatted when the message is logged, so that
+// nothing is formatted for disabled levels.
+type logFields struct {
+	msg           string
+	keysAndValues []interface{}
+}
+
+func (f logFields) String() string {
+	return formatFields(f.msg, f.keysAndValues)
+}
+
+// formatFields formats a message and its fields as "msg key=value ..."; a trailing key
+// without a value is logged as a value of the field "extra".
+func formatFields(msg string, keysAndValues []interface{}) string {
+	if len(keysAndValues) == 0 {
+		return msg
+	}
+
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if i+1 == len(keysAndValues) {
+			fmt.Fprintf(&b, " extra=%v", keysAndValues[i])
+			break
+		}
+		fmt.Fprintf(&b, " %v=%v", keysAndValues[i], keysAndValues[i+1])
+	}
+	return b.String()
+}
+
+// msgFields returns the log fields of a message received from a peer. The topic and ID
+// fields are formatted lazily, as the ID may be expensive to compute.
+func (p *PubSub) msgFields(msg *Message) []interface{} {
+	return []interface{}{
+		"peer", msg.ReceivedFrom,
+		"topic", topicsField(msg.GetTopicIDs()),
+		"msgid", p.logMsgID(msg.Message),
+	}
+}
+
+// topicsField formats the topics of a message as a comma separated list
+type topicsField []string
+
+func (t topicsField) String() string {
+	return strings.Join(t, ",")
+}
+
+// msgIDField formats the ID of a message; it is hex encoded, as the default ID is binary.
+type msgIDField struct {
+	id MsgIdFunction
+	m  *pb.Message
+}
+
+func (l msgIDField) String() string {
+	return hex.EncodeToString([]byte(l.id(l.m)))
+}
+
+// logMsgID returns the ID of a message as logged
+func (p *PubSub) logMsgID(m *pb.Message) msgIDField {
+	return msgIDField{id: p.msgID, m: m}
+}
@@ -0,0 +1,147 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
+)
+
+// logEntry is an entry logged to a testLogger
+type logEntry struct {
+	level  string
+	msg    string
+	fields map[interface{}]interface{}
+}
+
+// testLogger is a Logger capturing the entries it logs
+type testLogger struct {
+	mx      sync.Mutex
+	entries []logEntry
+}
+
+func (l *testLogger) log(level, msg string, keysAndValues []interface{}) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	fields := make(map[interface{}]interface{})
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		fields[keysAndValues[i]] = keysAndValues[i+1]
+	}
+	l.entries = append(l.entries, logEntry{level: level, msg: msg, fields: fields})
+}
+
+func (l *testLogger) Debugw(msg string, keysAndValues ...interface{}) {
+	l.log("debug", msg, keysAndValues)
+}
+
+func (l *testLogger) Infow(msg string, keysAndValues ...interface{}) {
+	l.log("info", msg, keysAndValues)
+}
+
+func (l *testLogger) Warnw(msg string, keysAndValues ...interface{}) {
+	l.log("warn", msg, keysAndValues)
+}
+
+func (l *testLogger) Errorw(msg string, keysAndValues ...interface{}) {
+	l.log("error", msg, keysAndValues)
+}
+
+// find returns the first entry logged with a message
+func (l *testLogger) find(msg string) (logEntry, bool) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	for _, e := range l.entries {
+		if e.msg == msg {
+			return e, true
+		}
+	}
+	return logEntry{}, false
+}
+
+func TestWithLogger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := new(testLogger)
+	hosts := getNetHosts(t, ctx, 2)
+	psubs := []*PubSub{
+		getPubsub(ctx, hosts[0], WithLogger(logger)),
+		getPubsub(ctx, hosts[1], WithMessageSigning(false), WithStrictSignatureVerification(false)),
+	}
+
+	_, err := psubs[0].Subscribe("foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connect(t, hosts[0], hosts[1])
+	time.Sleep(time.Millisecond * 100)
+
+	e, ok := logger.find("new peer")
+	if !ok {
+		t.Fatal("expected the new peer to be logged")
+	}
+	if e.level != "debug" || e.fields["peer"] != hosts[1].ID() {
+		t.Fatalf("unexpected log entry: %+v", e)
+	}
+
+	// unsigned messages are dropped by the strict receiver
+	err = psubs[1].Publish("foobar", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	e, ok = logger.find("dropping unsigned message")
+	if !ok {
+		t.Fatal("expected the dropped message to be logged")
+	}
+	if e.fields["peer"] != hosts[1].ID() || fmt.Sprint(e.fields["topic"]) != "foobar" || fmt.Sprint(e.fields["msgid"]) == "" {
+		t.Fatalf("unexpected log entry: %+v", e)
+	}
+
+	_, err = NewFloodSub(ctx, hosts[0], WithLogger(nil))
+	if err == nil {
+		t.Fatal("expected an error for a nil logger")
+	}
+}
+
+func TestFormatFields(t *testing.T) {
+	for _, tc := range []struct {
+		keysAndValues []interface{}
+		expected      string
+	}{
+		{nil, "msg"},
+		{[]interface{}{"peer", "QmPeer", "count", 3}, "msg peer=QmPeer count=3"},
+		{[]interface{}{"topic", "foobar", "dangling"}, "msg topic=foobar extra=dangling"},
+	} {
+		res := formatFields("msg", tc.keysAndValues)
+		if res != tc.expected {
+			t.Fatalf("expected %q, got %q", tc.expected, res)
+		}
+	}
+}
+
+func TestMsgFieldsLazy(t *testing.T) {
+	calls := 0
+	p := &PubSub{msgID: func(pmsg *pb.Message) string {
+		calls++
+		return "id"
+	}}
+
+	msg := &Message{Message: &pb.Message{TopicIDs: []string{"foo", "bar"}}}
+	fields := p.msgFields(msg)
+	if calls != 0 {
+		t.Fatal("expected the message ID not to be computed until formatted")
+	}
+
+	res := formatFields("msg", fields)
+	if res != "msg peer= topic=foo,bar msgid=6964" || calls != 1 {
+		t.Fatalf("unexpected formatting %q", res)
+	}
+}
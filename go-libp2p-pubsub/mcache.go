@@ -1,7 +1,7 @@
 package pubsub
 
 import (
-	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
 )
 
 func NewMessageCache(gossip, history int) *MessageCache {
@@ -9,6 +9,7 @@ func NewMessageCache(gossip, history int) *MessageCache {
 		msgs:    make(map[string]*pb.Message),
 		history: make([][]CacheEntry, history),
 		gossip:  gossip,
+		msgID:   DefaultMsgIdFn,
 	}
 }
 
@@ -16,6 +17,13 @@ type MessageCache struct {
 	msgs    map[string]*pb.Message
 	history [][]CacheEntry
 	gossip  int
+
+	msgID MsgIdFunction
+}
+
+// SetMsgIdFn sets the function used to compute message IDs for cached messages.
+func (mc *MessageCache) SetMsgIdFn(msgID MsgIdFunction) {
+	mc.msgID = msgID
 }
 
 type CacheEntry struct {
@@ -24,7 +32,7 @@ type CacheEntry struct {
 }
 
 func (mc *MessageCache) Put(msg *pb.Message) {
-	mid := msgID(msg)
+	mid := mc.msgID(msg)
 	mc.msgs[mid] = msg
 	mc.history[0] = append(mc.history[0], CacheEntry{mid: mid, topics: msg.GetTopicIDs()})
 }
@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"testing"
 
-	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
 )
 
 func TestMessageCache(t *testing.T) {
@@ -21,7 +21,7 @@ func TestMessageCache(t *testing.T) {
 	}
 
 	for i := 0; i < 10; i++ {
-		mid := msgID(msgs[i])
+		mid := DefaultMsgIdFn(msgs[i])
 		m, ok := mcache.Get(mid)
 		if !ok {
 			t.Fatalf("Message %d not in cache", i)
@@ -38,7 +38,7 @@ func TestMessageCache(t *testing.T) {
 	}
 
 	for i := 0; i < 10; i++ {
-		mid := msgID(msgs[i])
+		mid := DefaultMsgIdFn(msgs[i])
 		if mid != gids[i] {
 			t.Fatalf("GossipID mismatch for message %d", i)
 		}
@@ -50,7 +50,7 @@ func TestMessageCache(t *testing.T) {
 	}
 
 	for i := 0; i < 20; i++ {
-		mid := msgID(msgs[i])
+		mid := DefaultMsgIdFn(msgs[i])
 		m, ok := mcache.Get(mid)
 		if !ok {
 			t.Fatalf("Message %d not in cache", i)
@@ -67,14 +67,14 @@ func TestMessageCache(t *testing.T) {
 	}
 
 	for i := 0; i < 10; i++ {
-		mid := msgID(msgs[i])
+		mid := DefaultMsgIdFn(msgs[i])
 		if mid != gids[10+i] {
 			t.Fatalf("GossipID mismatch for message %d", i)
 		}
 	}
 
 	for i := 10; i < 20; i++ {
-		mid := msgID(msgs[i])
+		mid := DefaultMsgIdFn(msgs[i])
 		if mid != gids[i-10] {
 			t.Fatalf("GossipID mismatch for message %d", i)
 		}
@@ -105,7 +105,7 @@ func TestMessageCache(t *testing.T) {
 	}
 
 	for i := 0; i < 10; i++ {
-		mid := msgID(msgs[i])
+		mid := DefaultMsgIdFn(msgs[i])
 		_, ok := mcache.Get(mid)
 		if ok {
 			t.Fatalf("Message %d still in cache", i)
@@ -113,7 +113,7 @@ func TestMessageCache(t *testing.T) {
 	}
 
 	for i := 10; i < 60; i++ {
-		mid := msgID(msgs[i])
+		mid := DefaultMsgIdFn(msgs[i])
 		m, ok := mcache.Get(mid)
 		if !ok {
 			t.Fatalf("Message %d not in cache", i)
@@ -130,21 +130,21 @@ func TestMessageCache(t *testing.T) {
 	}
 
 	for i := 0; i < 10; i++ {
-		mid := msgID(msgs[50+i])
+		mid := DefaultMsgIdFn(msgs[50+i])
 		if mid != gids[i] {
 			t.Fatalf("GossipID mismatch for message %d", i)
 		}
 	}
 
 	for i := 10; i < 20; i++ {
-		mid := msgID(msgs[30+i])
+		mid := DefaultMsgIdFn(msgs[30+i])
 		if mid != gids[i] {
 			t.Fatalf("GossipID mismatch for message %d", i)
 		}
 	}
 
 	for i := 20; i < 30; i++ {
-		mid := msgID(msgs[10+i])
+		mid := DefaultMsgIdFn(msgs[10+i])
 		if mid != gids[i] {
 			t.Fatalf("GossipID mismatch for message %d", i)
 		}
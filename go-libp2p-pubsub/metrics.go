@@ -0,0 +1,336 @@
+package pubsub
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Metrics receives the measurements of the pubsub system. The methods are called from the
+// event loop, the validation pipeline and the peer writers, so implementations must be safe
+// for concurrent use and must not block.
+// The message measurements of messages from our peers are only recorded for the topics we
+// subscribe to or relay.
+type Metrics interface {
+	// MessagePublished is called for each topic of a message we publish.
+	MessagePublished(topic string)
+	// MessageReceived is called for each topic of a message received from a peer, before
+	// deduplication and validation.
+	MessageReceived(topic string)
+	// MessageDelivered is called for each topic of a message that has been accepted.
+	MessageDelivered(topic string)
+	// MessageDuplicate is called for each topic of a message we have already seen.
+	MessageDuplicate(topic string)
+	// MessageRejected is called for each topic of a message rejected prior to or by validation,
+	// with the reason of the rejection.
+	MessageRejected(topic string, reason string)
+	// MessageThrottled is called for each topic of a message dropped because the validation
+	// pipeline is overloaded.
+	MessageThrottled(topic string)
+	// SubscriberDropped is called when a message can't be delivered to a subscription of
+	// a topic because the subscriber is too slow.
+	SubscriberDropped(topic string)
+
+	// RPCSent is called when an RPC of a priority class is queued to a peer.
+	RPCSent(class RPCPriority)
+	// RPCDropped is called when an RPC of a priority class is dropped because the outbound
+	// queue of a peer is full.
+	RPCDropped(class RPCPriority)
+	// QueueDepth is called when the number of RPCs in the outbound queue of a peer changes.
+	QueueDepth(p peer.ID, depth int)
+	// PeerRemoved is called when the outbound queue of a peer is closed.
+	PeerRemoved(p peer.ID)
+
+	// MeshSize is called with the number of peers in the mesh of a topic after each
+	// heartbeat, and with 0 when we leave the topic. Only gossipsub maintains a mesh.
+	MeshSize(topic string, size int)
+	// ValidationLatency is called with the time taken by a validator of a topic.
+	ValidationLatency(topic string, d time.Duration)
+	// HeartbeatDuration is called with the time taken by a router heartbeat.
+	HeartbeatDuration(d time.Duration)
+}
+
+// WithMetrics is an option to set the metrics of the pubsub instance; by default, the
+// measurements are aggregated in a MemoryMetrics.
+func WithMetrics(m Metrics) Option {
+	return func(p *PubSub) error {
+		if m == nil {
+			return fmt.Errorf("metrics must not be nil")
+		}
+		p.metrics = m
+		return nil
+	}
+}
+
+// Metrics returns the metrics of the pubsub instance.
+func (p *PubSub) Metrics() Metrics {
+	return p.metrics
+}
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the buckets of the latency
+// histograms of MemoryMetrics.
+var DefaultLatencyBuckets = []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5}
+
+// TopicMetrics are the counters of a topic
+type TopicMetrics struct {
+	Published         uint64
+	Received          uint64
+	Delivered         uint64
+	Duplicate         uint64
+	Throttled         uint64
+	SubscriberDropped uint64
+	// Rejected counts the rejected messages by reason
+	Rejected map[string]uint64
+	// MeshSize is the number of peers in the mesh
+	MeshSize int
+}
+
+// Histogram is a histogram of durations, in seconds.
+type Histogram struct {
+	// Buckets are the upper bounds of the buckets
+	Buckets []float64
+	// Counts are the number of observations in each bucket, which are not cumulative;
+	// the observations exceeding the last bucket are only included in Count.
+	Counts []uint64
+	Count  uint64
+	Sum    float64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{Buckets: buckets, Counts: make([]uint64, len(buckets))}
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	v := d.Seconds()
+	h.Count++
+	h.Sum += v
+
+	i := sort.SearchFloat64s(h.Buckets, v)
+	if i < len(h.Buckets) {
+		h.Counts[i]++
+	}
+}
+
+func (h *Histogram) clone() Histogram {
+	res := *h
+	res.Counts = append([]uint64(nil), h.Counts...)
+	return res
+}
+
+// MetricsSnapshot is a point in time copy of the measurements of a MemoryMetrics.
+type MetricsSnapshot struct {
+	Topics            map[string]TopicMetrics
+	RPCSent           map[RPCPriority]uint64
+	RPCDropped        map[RPCPriority]uint64
+	QueueDepth        map[peer.ID]int
+	ValidationLatency map[string]Histogram
+	HeartbeatDuration Histogram
+}
+
+// MemoryMetrics is the default Metrics implementation, aggregating the measurements in memory.
+type MemoryMetrics struct {
+	mx sync.Mutex
+
+	buckets    []float64
+	topics     map[string]*TopicMetrics
+	rpcSent    [numRPCPriorities]uint64
+	rpcDropped [numRPCPriorities]uint64
+	queueDepth map[peer.ID]int
+	validation map[string]*Histogram
+	heartbeat  *Histogram
+}
+
+var _ Metrics = (*MemoryMetrics)(nil)
+
+// NewMemoryMetrics returns a new MemoryMetrics, with histograms using DefaultLatencyBuckets.
+func NewMemoryMetrics() *MemoryMetrics {
+	return &MemoryMetrics{
+		buckets:    DefaultLatencyBuckets,
+		topics:     make(map[string]*TopicMetrics),
+		queueDepth: make(map[peer.ID]int),
+		validation: make(map[string]*Histogram),
+		heartbeat:  newHistogram(DefaultLatencyBuckets),
+	}
+}
+
+// topic returns the counters of a topic; the lock must be held.
+func (m *MemoryMetrics) topic(topic string) *TopicMetrics {
+	t, ok := m.topics[topic]
+	if !ok {
+		t = &TopicMetrics{Rejected: make(map[string]uint64)}
+		m.topics[topic] = t
+	}
+	return t
+}
+
+func (m *MemoryMetrics) MessagePublished(topic string) {
+	m.mx.Lock()
+	m.topic(topic).Published++
+	m.mx.Unlock()
+}
+
+func (m *MemoryMetrics) MessageReceived(topic string) {
+	m.mx.Lock()
+	m.topic(topic).Received++
+	m.mx.Unlock()
+}
+
+func (m *MemoryMetrics) MessageDelivered(topic string) {
+	m.mx.Lock()
+	m.topic(topic).Delivered++
+	m.mx.Unlock()
+}
+
+func (m *MemoryMetrics) MessageDuplicate(topic string) {
+	m.mx.Lock()
+	m.topic(topic).Duplicate++
+	m.mx.Unlock()
+}
+
+func (m *MemoryMetrics) MessageRejected(topic string, reason string) {
+	m.mx.Lock()
+	m.topic(topic).Rejected[reason]++
+	m.mx.Unlock()
+}
+
+func (m *MemoryMetrics) MessageThrottled(topic string) {
+	m.mx.Lock()
+	m.topic(topic).Throttled++
+	m.mx.Unlock()
+}
+
+func (m *MemoryMetrics) SubscriberDropped(topic string) {
+	m.mx.Lock()
+	m.topic(topic).SubscriberDropped++
+	m.mx.Unlock()
+}
+
+func (m *MemoryMetrics) RPCSent(class RPCPriority) {
+	m.mx.Lock()
+	m.rpcSent[class]++
+	m.mx.Unlock()
+}
+
+func (m *MemoryMetrics) RPCDropped(class RPCPriority) {
+	m.mx.Lock()
+	m.rpcDropped[class]++
+	m.mx.Unlock()
+}
+
+func (m *MemoryMetrics) QueueDepth(p peer.ID, depth int) {
+	m.mx.Lock()
+	m.queueDepth[p] = depth
+	m.mx.Unlock()
+}
+
+func (m *MemoryMetrics) PeerRemoved(p peer.ID) {
+	m.mx.Lock()
+	delete(m.queueDepth, p)
+	m.mx.Unlock()
+}
+
+func (m *MemoryMetrics) MeshSize(topic string, size int) {
+	m.mx.Lock()
+	m.topic(topic).MeshSize = size
+	m.mx.Unlock()
+}
+
+func (m *MemoryMetrics) ValidationLatency(topic string, d time.Duration) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	h, ok := m.validation[topic]
+	if !ok {
+		h = newHistogram(m.buckets)
+		m.validation[topic] = h
+	}
+	h.observe(d)
+}
+
+func (m *MemoryMetrics) HeartbeatDuration(d time.Duration) {
+	m.mx.Lock()
+	m.heartbeat.observe(d)
+	m.mx.Unlock()
+}
+
+// Snapshot returns a copy of the current measurements.
+func (m *MemoryMetrics) Snapshot() MetricsSnapshot {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	s := MetricsSnapshot{
+		Topics:            make(map[string]TopicMetrics, len(m.topics)),
+		RPCSent:           make(map[RPCPriority]uint64, numRPCPriorities),
+		RPCDropped:        make(map[RPCPriority]uint64, numRPCPriorities),
+		QueueDepth:        make(map[peer.ID]int, len(m.queueDepth)),
+		ValidationLatency: make(map[string]Histogram, len(m.validation)),
+		HeartbeatDuration: m.heartbeat.clone(),
+	}
+
+	for topic, t := range m.topics {
+		tm := *t
+		tm.Rejected = make(map[string]uint64, len(t.Rejected))
+		for reason, n := range t.Rejected {
+			tm.Rejected[reason] = n
+		}
+		s.Topics[topic] = tm
+	}
+	for class := range m.rpcSent {
+		s.RPCSent[RPCPriority(class)] = m.rpcSent[class]
+		s.RPCDropped[RPCPriority(class)] = m.rpcDropped[class]
+	}
+	for p, depth := range m.queueDepth {
+		s.QueueDepth[p] = depth
+	}
+	for topic, h := range m.validation {
+		s.ValidationLatency[topic] = h.clone()
+	}
+
+	return s
+}
+
+// rejectMessage records the rejection of a message in the tracer and the metrics;
+// rejections due to overload are recorded as throttled.
+func (p *PubSub) rejectMessage(msg *Message, reason string) {
+	p.tracer.RejectMessage(msg, reason)
+
+	for _, topic := range msg.topics {
+		switch reason {
+		case rejectValidationQueueFull, rejectValidationThrottled:
+			p.metrics.MessageThrottled(topic)
+		default:
+			p.metrics.MessageRejected(topic, reason)
+		}
+	}
+}
+
+// metricTopics returns the topics of a message for which metrics are recorded: all the topics
+// of our own messages, and the topics we subscribe to or relay for messages from our peers,
+// so that peers can't grow the metrics with arbitrary topics.
+// Only called from processLoop.
+func (p *PubSub) metricTopics(src peer.ID, msg *Message) []string {
+	topics := msg.GetTopicIDs()
+	if src == p.host.ID() {
+		return topics
+	}
+
+	var res []string
+	for _, topic := range topics {
+		if p.wantsTopic(topic) {
+			res = append(res, topic)
+		}
+	}
+	return res
+}
+
+// duplicateMessage records a duplicate message in the tracer and the metrics
+func (p *PubSub) duplicateMessage(msg *Message) {
+	p.tracer.DuplicateMessage(msg)
+
+	for _, topic := range msg.topics {
+		p.metrics.MessageDuplicate(topic)
+	}
+}
@@ -0,0 +1,171 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 3)
+	psubs := getGossipsubs(ctx, hosts)
+
+	// the validator of the first peer rejects messages of the last peer
+	err := psubs[0].RegisterTopicValidator("foobar", func(ctx context.Context, p peer.ID, msg *Message) bool {
+		return msg.GetFrom() != hosts[2].ID()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var subs []*Subscription
+	for _, ps := range psubs {
+		sub, err := ps.Subscribe("foobar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		subs = append(subs, sub)
+	}
+
+	denseConnect(t, hosts)
+
+	// wait for heartbeats to build mesh
+	time.Sleep(time.Second * 2)
+
+	for i := 0; i < 2; i++ {
+		msg := []byte(fmt.Sprintf("message %d", i))
+		err := psubs[i].Publish("foobar", msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, sub := range subs {
+			assertReceive(t, sub, msg)
+		}
+	}
+
+	err = psubs[2].Publish("foobar", []byte("rejected"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	s := psubs[0].Metrics().(*MemoryMetrics).Snapshot()
+	tm := s.Topics["foobar"]
+	if tm.Published != 1 || tm.Delivered != 2 {
+		t.Fatalf("unexpected topic counters: %+v", tm)
+	}
+	if tm.Received == 0 || tm.Rejected[rejectValidationFailed] == 0 {
+		t.Fatalf("expected received and rejected messages: %+v", tm)
+	}
+	if tm.MeshSize == 0 {
+		t.Fatal("expected the mesh size to be reported")
+	}
+	if s.ValidationLatency["foobar"].Count == 0 {
+		t.Fatal("expected validation latencies to be observed")
+	}
+	if s.HeartbeatDuration.Count == 0 {
+		t.Fatal("expected heartbeat durations to be observed")
+	}
+	if s.RPCSent[PriorityControl] == 0 || s.RPCSent[PriorityPublish] == 0 {
+		t.Fatalf("expected RPCs to be sent: %v", s.RPCSent)
+	}
+	if _, ok := s.QueueDepth[hosts[1].ID()]; !ok {
+		t.Fatal("expected the queue depth of a peer to be reported")
+	}
+
+	// the queue depths of disconnected peers are removed
+	hosts[0].Network().ClosePeer(hosts[1].ID())
+	time.Sleep(time.Millisecond * 100)
+
+	s = psubs[0].Metrics().(*MemoryMetrics).Snapshot()
+	if _, ok := s.QueueDepth[hosts[1].ID()]; ok {
+		t.Fatal("unexpected queue depth of a disconnected peer")
+	}
+}
+
+func TestMetricTopics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 1)
+	p := &PubSub{
+		host:     hosts[0],
+		mySubs:   map[string]map[*Subscription]struct{}{"foobar": {}},
+		myRelays: map[string]int{"relayed": 1},
+	}
+
+	msg := &Message{Message: &pb.Message{TopicIDs: []string{"foobar", "padding", "relayed"}}}
+
+	// only the topics we want are measured for messages from our peers
+	topics := p.metricTopics(peer.ID("peer"), msg)
+	if len(topics) != 2 || topics[0] != "foobar" || topics[1] != "relayed" {
+		t.Fatalf("unexpected topics: %v", topics)
+	}
+
+	// all the topics of our own messages are measured
+	topics = p.metricTopics(hosts[0].ID(), msg)
+	if len(topics) != 3 {
+		t.Fatalf("unexpected topics: %v", topics)
+	}
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	m := NewMemoryMetrics()
+	m.MessagePublished("foo")
+	m.MessagePublished("foo")
+	m.MessageRejected("foo", rejectInvalidSignature)
+	m.MessageDelivered(`b"ar`)
+	m.RPCDropped(PriorityGossip)
+	m.MeshSize("foo", 6)
+	m.ValidationLatency("foo", time.Millisecond*2)
+	m.ValidationLatency("foo", time.Second*10)
+
+	srv := httptest.NewServer(NewPrometheusHandler(m))
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != PrometheusContentType {
+		t.Fatalf("unexpected content type %s", resp.Header.Get("Content-Type"))
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		"# TYPE pubsub_messages_published_total counter",
+		`pubsub_messages_published_total{topic="foo"} 2`,
+		`pubsub_messages_delivered_total{topic="b\"ar"} 1`,
+		`pubsub_messages_rejected_total{topic="foo",reason="invalid signature"} 1`,
+		`pubsub_mesh_peers{topic="foo"} 6`,
+		`pubsub_rpc_dropped_total{class="gossip"} 1`,
+		`pubsub_rpc_dropped_total{class="control"} 0`,
+		`pubsub_validation_duration_seconds_bucket{topic="foo",le="0.001"} 0`,
+		`pubsub_validation_duration_seconds_bucket{topic="foo",le="0.005"} 1`,
+		`pubsub_validation_duration_seconds_bucket{topic="foo",le="5"} 1`,
+		`pubsub_validation_duration_seconds_bucket{topic="foo",le="+Inf"} 2`,
+		`pubsub_validation_duration_seconds_count{topic="foo"} 2`,
+		`pubsub_heartbeat_duration_seconds_count 0`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Fatalf("expected line %q in:\n%s", line, body)
+		}
+	}
+}
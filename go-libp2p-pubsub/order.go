@@ -0,0 +1,362 @@
+package pubsub
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// OrderEventType is the type of an event of an ordered subscription
+type OrderEventType int
+
+const (
+	// OrderGap reports a range of seqnos of a publisher that were skipped, because they were
+	// not received within the timeout or the window.
+	OrderGap OrderEventType = iota
+	// OrderLate reports a message that was not delivered, because it was received after its
+	// seqno was delivered or skipped.
+	OrderLate
+	// OrderDropped reports a message that was not delivered, because the subscriber was too
+	// slow to read it from the subscription.
+	OrderDropped
+)
+
+// OrderEvent is an event of an ordered subscription
+type OrderEvent struct {
+	Type OrderEventType
+	From peer.ID
+	// First and Last are the range of skipped seqnos of a gap, or the seqno of a late or
+	// dropped message
+	First, Last uint64
+	// Message is the late or dropped message; nil for gaps
+	Message *Message
+}
+
+// OrderEventHandler receives the events of an ordered subscription
+type OrderEventHandler func(OrderEvent)
+
+const (
+	// orderAuthorTimeout is the inactivity after which the ordering state of a publisher
+	// without pending messages is dropped
+	orderAuthorTimeout = 2 * time.Minute
+	// orderRestartDistance is the distance below the next expected seqno of a publisher
+	// beyond which a message is taken as a restart of the publisher with a lower seqno
+	// counter, rather than as a late message
+	orderRestartDistance = 1 << 16
+)
+
+// WithOrderedDelivery is a subscription option to deliver the messages of each publisher in
+// the order they were published to the topic. Messages are ordered by their topic seqno, which
+// the publisher increments for each message it publishes to the topic, or by their seqno for
+// publishers that don't set the topic seqno. Messages received ahead of the next expected
+// seqno of their publisher are held for up to timeout, for at most window seqnos ahead,
+// waiting for the missing messages; the missing seqnos are then skipped and reported as a
+// gap. Messages received after their seqno was delivered or skipped are reported as late and
+// not delivered. The first message received from a publisher is delivered as is and sets its
+// expected seqno; so is the first message after a couple of minutes without messages from the
+// publisher, or a message so far below the expected seqno that the publisher must have
+// restarted.
+// The handler may be nil; it is called from the goroutine ordering the messages, so it
+// must not block.
+func WithOrderedDelivery(window int, timeout time.Duration, handler OrderEventHandler) SubOpt {
+	return func(sub *Subscription) error {
+		if window <= 0 {
+			return errors.New("invalid ordering window; must be positive")
+		}
+		if timeout <= 0 {
+			return errors.New("invalid ordering timeout; must be positive")
+		}
+
+		sub.order = &orderer{
+			window:  uint64(window),
+			timeout: timeout,
+			handler: handler,
+			authors: make(map[peer.ID]*authorOrder),
+		}
+		return nil
+	}
+}
+
+// orderer reorders the messages of a subscription by publisher and topic seqno; it is only
+// accessed by the ordering goroutine of the subscription.
+type orderer struct {
+	window  uint64
+	timeout time.Duration
+	handler OrderEventHandler
+
+	authors map[peer.ID]*authorOrder
+	swept   time.Time
+
+	// logger of the pubsub instance, set when the subscription is added
+	logger Logger
+
+	// ready are the messages ready to be delivered, in order
+	ready []*Message
+}
+
+// authorOrder is the ordering state of a publisher
+type authorOrder struct {
+	next    uint64
+	pending map[uint64]*pendingMessage
+	seen    time.Time
+}
+
+type pendingMessage struct {
+	msg      *Message
+	deadline time.Time
+}
+
+// msgSeqno returns the seqno of a message, which is false for messages without a valid seqno
+func msgSeqno(msg *Message) (uint64, bool) {
+	seqno := msg.GetSeqno()
+	if len(seqno) != 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(seqno), true
+}
+
+// orderSeqno returns the seqno ordering a message: its topic seqno, which is consecutive for
+// the messages of a publisher in the topic, or its seqno for publishers that don't set it.
+// It is false for messages without a valid seqno.
+func orderSeqno(msg *Message) (uint64, bool) {
+	if msg.TopicSeqno != nil {
+		return msg.GetTopicSeqno(), true
+	}
+	return msgSeqno(msg)
+}
+
+// push adds a received message, making ready the messages that can be delivered
+func (o *orderer) push(msg *Message, now time.Time) {
+	seqno, ok := orderSeqno(msg)
+	if !ok {
+		o.ready = append(o.ready, msg)
+		return
+	}
+
+	o.sweep(now)
+
+	from := msg.GetFrom()
+	a, ok := o.authors[from]
+	if ok && seqno < a.next && a.next-seqno > orderRestartDistance {
+		o.logger.Debugw("publisher restarted with a lower seqno; resetting its order", "peer", from)
+		o.flushAuthor(from, a)
+		delete(o.authors, from)
+		ok = false
+	}
+	if !ok {
+		o.authors[from] = &authorOrder{next: seqno + 1, pending: make(map[uint64]*pendingMessage), seen: now}
+		o.ready = append(o.ready, msg)
+		return
+	}
+	a.seen = now
+
+	switch {
+	case seqno < a.next:
+		o.emit(OrderEvent{Type: OrderLate, From: from, First: seqno, Last: seqno, Message: msg})
+		return
+	case seqno == a.next:
+		o.ready = append(o.ready, msg)
+		a.next++
+		o.drain(a)
+		return
+	}
+
+	if _, ok := a.pending[seqno]; ok {
+		return
+	}
+	a.pending[seqno] = &pendingMessage{msg: msg, deadline: now.Add(o.timeout)}
+
+	// make room in the window by skipping the oldest missing seqnos
+	if seqno-a.next >= o.window {
+		o.skipTo(from, a, seqno-o.window+1)
+	}
+}
+
+// expire delivers the pending messages that have timed out, along with the pending messages
+// that precede them, skipping the missing seqnos in between
+func (o *orderer) expire(now time.Time) {
+	for from, a := range o.authors {
+		var last uint64
+		expired := false
+		for seqno, pm := range a.pending {
+			if !now.Before(pm.deadline) && (!expired || seqno > last) {
+				last = seqno
+				expired = true
+			}
+		}
+
+		if expired {
+			o.skipTo(from, a, last)
+		}
+	}
+}
+
+// flush delivers all the pending messages in order, skipping the missing seqnos
+func (o *orderer) flush() {
+	for from, a := range o.authors {
+		o.flushAuthor(from, a)
+	}
+}
+
+// flushAuthor delivers the pending messages of a publisher in order, skipping the missing seqnos
+func (o *orderer) flushAuthor(from peer.ID, a *authorOrder) {
+	for len(a.pending) > 0 {
+		o.skipTo(from, a, a.first())
+	}
+}
+
+// sweep drops the state of the publishers without pending messages that have been inactive
+// for orderAuthorTimeout, at most once per timeout
+func (o *orderer) sweep(now time.Time) {
+	if now.Sub(o.swept) < orderAuthorTimeout {
+		return
+	}
+	o.swept = now
+
+	for from, a := range o.authors {
+		if len(a.pending) == 0 && now.Sub(a.seen) >= orderAuthorTimeout {
+			delete(o.authors, from)
+		}
+	}
+}
+
+// deadline returns the earliest deadline of the pending messages, if any
+func (o *orderer) deadline() (time.Time, bool) {
+	var res time.Time
+	found := false
+	for _, a := range o.authors {
+		for _, pm := range a.pending {
+			if !found || pm.deadline.Before(res) {
+				res = pm.deadline
+				found = true
+			}
+		}
+	}
+	return res, found
+}
+
+// skipTo skips the seqnos of a publisher up to, but excluding, a seqno: the pending messages
+// in between are made ready in order and the missing seqnos are reported as gaps.
+func (o *orderer) skipTo(from peer.ID, a *authorOrder, to uint64) {
+	seqnos := make([]uint64, 0, len(a.pending))
+	for seqno := range a.pending {
+		if seqno < to {
+			seqnos = append(seqnos, seqno)
+		}
+	}
+	sort.Slice(seqnos, func(i, j int) bool { return seqnos[i] < seqnos[j] })
+
+	for _, seqno := range seqnos {
+		if seqno > a.next {
+			o.emit(OrderEvent{Type: OrderGap, From: from, First: a.next, Last: seqno - 1})
+		}
+		o.ready = append(o.ready, a.pending[seqno].msg)
+		delete(a.pending, seqno)
+		a.next = seqno + 1
+	}
+
+	if a.next < to {
+		o.emit(OrderEvent{Type: OrderGap, From: from, First: a.next, Last: to - 1})
+		a.next = to
+	}
+
+	o.drain(a)
+}
+
+// drain makes ready the pending messages that follow the next expected seqno of a publisher
+func (o *orderer) drain(a *authorOrder) {
+	for {
+		pm, ok := a.pending[a.next]
+		if !ok {
+			return
+		}
+		o.ready = append(o.ready, pm.msg)
+		delete(a.pending, a.next)
+		a.next++
+	}
+}
+
+// first returns the lowest pending seqno of a publisher with pending messages
+func (a *authorOrder) first() uint64 {
+	first := true
+	var res uint64
+	for seqno := range a.pending {
+		if first || seqno < res {
+			res = seqno
+			first = false
+		}
+	}
+	return res
+}
+
+func (o *orderer) emit(evt OrderEvent) {
+	if o.handler != nil {
+		o.handler(evt)
+	}
+}
+
+// reorder orders the messages of a subscription, from the channel fed by the event loop to
+// the channel read by Next. It exits once the subscription is closed, after delivering the
+// pending messages.
+func (sub *Subscription) reorder() {
+	o := sub.order
+	defer close(sub.out)
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		var timeout <-chan time.Time
+		if deadline, ok := o.deadline(); ok {
+			timer.Reset(time.Until(deadline))
+			timeout = timer.C
+		}
+
+		select {
+		case msg, ok := <-sub.ch:
+			if !ok {
+				o.flush()
+				sub.deliverReady()
+				return
+			}
+			o.push(msg, time.Now())
+
+		case <-timeout:
+			o.expire(time.Now())
+		}
+
+		if timeout != nil && !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+
+		sub.deliverReady()
+	}
+}
+
+// deliverReady delivers the ordered messages ready for delivery; like the event loop, it
+// drops messages when the subscriber is too slow, reporting them to the event handler.
+func (sub *Subscription) deliverReady() {
+	o := sub.order
+	for _, msg := range o.ready {
+		select {
+		case sub.out <- msg:
+		default:
+			o.logger.Infow("can't deliver ordered message to subscription; subscriber too slow",
+				"topic", sub.topic, "peer", msg.ReceivedFrom)
+			seqno, _ := orderSeqno(msg)
+			o.emit(OrderEvent{Type: OrderDropped, From: msg.GetFrom(), First: seqno, Last: seqno, Message: msg})
+		}
+	}
+
+	for i := range o.ready {
+		o.ready[i] = nil
+	}
+	o.ready = o.ready[:0]
+}
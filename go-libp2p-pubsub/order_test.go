@@ -0,0 +1,329 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// makeSeqnoMessage returns a message from a publisher with a seqno
+func makeSeqnoMessage(from peer.ID, seqno uint64) *Message {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, seqno)
+	return &Message{Message: &pb.Message{From: []byte(from), Seqno: b}}
+}
+
+// seqnos returns the seqnos of messages
+func seqnos(msgs []*Message) []uint64 {
+	res := make([]uint64, 0, len(msgs))
+	for _, msg := range msgs {
+		seqno, _ := orderSeqno(msg)
+		res = append(res, seqno)
+	}
+	return res
+}
+
+func equalSeqnos(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestOrderer(t *testing.T) {
+	var events []OrderEvent
+	o := &orderer{
+		window:  4,
+		timeout: time.Second,
+		handler: func(evt OrderEvent) { events = append(events, evt) },
+		authors: make(map[peer.ID]*authorOrder),
+		logger:  newGoLogger(log),
+	}
+
+	a, b := peer.ID("a"), peer.ID("b")
+	now := time.Unix(1000, 0)
+
+	// the first message of a publisher is delivered at once, and the following ones in order
+	for _, msg := range []*Message{
+		makeSeqnoMessage(a, 10),
+		makeSeqnoMessage(a, 12),
+		makeSeqnoMessage(b, 5),
+		makeSeqnoMessage(a, 13),
+		makeSeqnoMessage(a, 11),
+	} {
+		o.push(msg, now)
+	}
+	if !equalSeqnos(seqnos(o.ready), []uint64{10, 5, 11, 12, 13}) {
+		t.Fatalf("unexpected order: %v", seqnos(o.ready))
+	}
+	o.ready = nil
+
+	// late messages are reported and not delivered
+	o.push(makeSeqnoMessage(a, 12), now)
+	if len(o.ready) != 0 || len(events) != 1 || events[0].Type != OrderLate || events[0].First != 12 {
+		t.Fatalf("expected a late message event, got %+v", events)
+	}
+	events = nil
+
+	// missing seqnos are skipped after the timeout
+	o.push(makeSeqnoMessage(a, 16), now)
+	o.push(makeSeqnoMessage(a, 15), now.Add(time.Millisecond*500))
+	deadline, ok := o.deadline()
+	if !ok || !deadline.Equal(now.Add(time.Second)) {
+		t.Fatalf("unexpected deadline %s", deadline)
+	}
+
+	o.expire(now.Add(time.Millisecond * 999))
+	if len(o.ready) != 0 {
+		t.Fatal("unexpected delivery before the timeout")
+	}
+
+	o.expire(now.Add(time.Second))
+	if !equalSeqnos(seqnos(o.ready), []uint64{15, 16}) {
+		t.Fatalf("unexpected order: %v", seqnos(o.ready))
+	}
+	if len(events) != 1 || events[0].Type != OrderGap || events[0].From != a || events[0].First != 14 || events[0].Last != 14 {
+		t.Fatalf("expected a gap event, got %+v", events)
+	}
+	o.ready = nil
+	events = nil
+
+	// messages beyond the window skip the oldest missing seqnos
+	o.push(makeSeqnoMessage(a, 19), now)
+	o.push(makeSeqnoMessage(a, 22), now)
+	if !equalSeqnos(seqnos(o.ready), []uint64{19}) {
+		t.Fatalf("unexpected order: %v", seqnos(o.ready))
+	}
+	if len(events) != 1 || events[0].First != 17 || events[0].Last != 18 {
+		t.Fatalf("expected a gap event, got %+v", events)
+	}
+	o.ready = nil
+	events = nil
+
+	// flushing delivers the pending messages
+	o.flush()
+	if !equalSeqnos(seqnos(o.ready), []uint64{22}) {
+		t.Fatalf("unexpected order: %v", seqnos(o.ready))
+	}
+	if len(events) != 1 || events[0].First != 20 || events[0].Last != 21 {
+		t.Fatalf("expected a gap event, got %+v", events)
+	}
+	if _, ok := o.deadline(); ok {
+		t.Fatal("unexpected pending messages")
+	}
+	o.ready = nil
+	events = nil
+
+	// a publisher restarting with a lower seqno starts over
+	o.push(makeSeqnoMessage(a, 100000), now)
+	o.push(makeSeqnoMessage(a, 100001), now)
+	events = nil
+	o.push(makeSeqnoMessage(a, 1), now)
+	o.push(makeSeqnoMessage(a, 2), now)
+	if !equalSeqnos(seqnos(o.ready), []uint64{100000, 100001, 1, 2}) {
+		t.Fatalf("unexpected order: %v", seqnos(o.ready))
+	}
+	for _, evt := range events {
+		if evt.Type == OrderLate {
+			t.Fatalf("unexpected late message event: %+v", evt)
+		}
+	}
+	o.ready = nil
+	events = nil
+
+	// the state of inactive publishers is dropped
+	o.push(makeSeqnoMessage(b, 6), now.Add(orderAuthorTimeout))
+	if _, ok := o.authors[a]; ok {
+		t.Fatal("expected the inactive publisher to be dropped")
+	}
+	if _, ok := o.authors[b]; !ok {
+		t.Fatal("expected the active publisher to be kept")
+	}
+}
+
+func TestOrderedDeliveryDrops(t *testing.T) {
+	var events []OrderEvent
+	sub := &Subscription{
+		topic: "foobar",
+		out:   make(chan *Message, 1),
+		order: &orderer{
+			window:  4,
+			timeout: time.Second,
+			handler: func(evt OrderEvent) { events = append(events, evt) },
+			authors: make(map[peer.ID]*authorOrder),
+			logger:  newGoLogger(log),
+		},
+	}
+
+	// the messages that don't fit in the subscription are reported as dropped
+	a := peer.ID("a")
+	now := time.Unix(1000, 0)
+	for seqno := uint64(1); seqno <= 3; seqno++ {
+		sub.order.push(makeSeqnoMessage(a, seqno), now)
+	}
+	sub.deliverReady()
+
+	if msg := <-sub.out; !equalSeqnos(seqnos([]*Message{msg}), []uint64{1}) {
+		t.Fatalf("unexpected message: %v", seqnos([]*Message{msg}))
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 dropped message events, got %+v", events)
+	}
+	for i, evt := range events {
+		seqno := uint64(i + 2)
+		if evt.Type != OrderDropped || evt.From != a || evt.First != seqno || evt.Last != seqno || evt.Message == nil {
+			t.Fatalf("unexpected event: %+v", evt)
+		}
+	}
+	if len(sub.order.ready) != 0 {
+		t.Fatal("expected no messages left to deliver")
+	}
+}
+
+func TestOrderedSubscription(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 1)
+	ps := getPubsub(ctx, hosts[0])
+
+	var mx sync.Mutex
+	var gaps []OrderEvent
+	sub, err := ps.Subscribe("foobar", WithOrderedDelivery(8, time.Millisecond*100, func(evt OrderEvent) {
+		mx.Lock()
+		gaps = append(gaps, evt)
+		mx.Unlock()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// messages are fed out of order, as if validated concurrently
+	from := peer.ID("publisher")
+	ps.eval <- func() {
+		for _, seqno := range []uint64{1, 3, 2, 5} {
+			sub.ch <- makeSeqnoMessage(from, seqno)
+		}
+	}
+
+	var received []*Message
+	for i := 0; i < 4; i++ {
+		msg, err := sub.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		received = append(received, msg)
+	}
+	if !equalSeqnos(seqnos(received), []uint64{1, 2, 3, 5}) {
+		t.Fatalf("unexpected order: %v", seqnos(received))
+	}
+
+	mx.Lock()
+	if len(gaps) != 1 || gaps[0].Type != OrderGap || gaps[0].First != 4 || gaps[0].Last != 4 {
+		t.Fatalf("expected a gap event, got %+v", gaps)
+	}
+	mx.Unlock()
+
+	// published messages come out of the ordered subscription
+	err = ps.Publish("foobar", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := sub.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Data) != "hello" {
+		t.Fatal("unexpected message")
+	}
+
+	sub.Cancel()
+	_, err = sub.Next(ctx)
+	if err == nil {
+		t.Fatal("expected an error from a cancelled subscription")
+	}
+
+	_, err = ps.Subscribe("foobar", WithOrderedDelivery(0, time.Second, nil))
+	if err == nil {
+		t.Fatal("expected an error for an invalid window")
+	}
+}
+
+func TestOrderedSubscriptionTopicSeqnos(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 2)
+	psubs := getPubsubs(ctx, hosts, WithMessageChunking(16, 1024, time.Second))
+	connect(t, hosts[0], hosts[1])
+
+	var mx sync.Mutex
+	var events []OrderEvent
+	sub, err := psubs[1].Subscribe("foobar", WithOrderedDelivery(8, time.Second*10, func(evt OrderEvent) {
+		mx.Lock()
+		events = append(events, evt)
+		mx.Unlock()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = psubs[1].Subscribe("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Millisecond * 100)
+
+	// messages in another topic and chunked messages leave no gaps in the topic seqnos,
+	// so the messages are not held waiting for the missing seqnos
+	chunked := make([]byte, 100)
+	for i := range chunked {
+		chunked[i] = byte('a' + i%26)
+	}
+	publish := []struct {
+		topic string
+		data  []byte
+	}{
+		{"foobar", []byte("first")},
+		{"other", []byte("hello")},
+		{"foobar", chunked},
+		{"other", []byte("world")},
+		{"foobar", []byte("last")},
+	}
+	for _, p := range publish {
+		err := psubs[0].Publish(p.topic, p.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.topic != "foobar" {
+			continue
+		}
+
+		nctx, ncancel := context.WithTimeout(ctx, time.Second*2)
+		msg, err := sub.Next(nctx)
+		ncancel()
+		if err != nil {
+			t.Fatalf("message %s not delivered: %s", p.data, err)
+		}
+		if string(msg.GetData()) != string(p.data) {
+			t.Fatalf("unexpected message: %s", msg.GetData())
+		}
+	}
+
+	mx.Lock()
+	defer mx.Unlock()
+	if len(events) != 0 {
+		t.Fatalf("unexpected order events: %+v", events)
+	}
+}
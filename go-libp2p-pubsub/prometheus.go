@@ -0,0 +1,159 @@
+package pubsub
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PrometheusContentType is the content type of the Prometheus text exposition format
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// NewPrometheusHandler returns an HTTP handler serving the measurements of a MemoryMetrics
+// in the Prometheus text exposition format.
+func NewPrometheusHandler(m *MemoryMetrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", PrometheusContentType)
+
+		bw := bufio.NewWriter(w)
+		writePrometheus(bw, m.Snapshot())
+		bw.Flush()
+	})
+}
+
+// writePrometheus writes a snapshot in the Prometheus text exposition format; series are
+// sorted by label, so that the output is stable.
+func writePrometheus(w *bufio.Writer, s MetricsSnapshot) {
+	topics := make([]string, 0, len(s.Topics))
+	for topic := range s.Topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	counters := []struct {
+		name, help string
+		value      func(TopicMetrics) uint64
+	}{
+		{"pubsub_messages_published_total", "Messages published.",
+			func(t TopicMetrics) uint64 { return t.Published }},
+		{"pubsub_messages_received_total", "Messages received from peers, before deduplication and validation.",
+			func(t TopicMetrics) uint64 { return t.Received }},
+		{"pubsub_messages_delivered_total", "Messages accepted and delivered.",
+			func(t TopicMetrics) uint64 { return t.Delivered }},
+		{"pubsub_messages_duplicate_total", "Duplicate messages.",
+			func(t TopicMetrics) uint64 { return t.Duplicate }},
+		{"pubsub_messages_throttled_total", "Messages dropped because validation is overloaded.",
+			func(t TopicMetrics) uint64 { return t.Throttled }},
+		{"pubsub_subscriber_dropped_total", "Messages not delivered to slow subscribers.",
+			func(t TopicMetrics) uint64 { return t.SubscriberDropped }},
+	}
+	for _, c := range counters {
+		writeHeader(w, c.name, c.help, "counter")
+		for _, topic := range topics {
+			writeSample(w, c.name, labels("topic", topic), strconv.FormatUint(c.value(s.Topics[topic]), 10))
+		}
+	}
+
+	writeHeader(w, "pubsub_messages_rejected_total", "Messages rejected, by reason.", "counter")
+	for _, topic := range topics {
+		rejected := s.Topics[topic].Rejected
+		reasons := make([]string, 0, len(rejected))
+		for reason := range rejected {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+
+		for _, reason := range reasons {
+			writeSample(w, "pubsub_messages_rejected_total", labels("topic", topic, "reason", reason),
+				strconv.FormatUint(rejected[reason], 10))
+		}
+	}
+
+	writeHeader(w, "pubsub_mesh_peers", "Peers in the mesh of a topic.", "gauge")
+	for _, topic := range topics {
+		writeSample(w, "pubsub_mesh_peers", labels("topic", topic), strconv.Itoa(s.Topics[topic].MeshSize))
+	}
+
+	writeHeader(w, "pubsub_rpc_sent_total", "RPCs queued to peers, by priority class.", "counter")
+	for class := RPCPriority(0); class < numRPCPriorities; class++ {
+		writeSample(w, "pubsub_rpc_sent_total", labels("class", class.String()),
+			strconv.FormatUint(s.RPCSent[class], 10))
+	}
+
+	writeHeader(w, "pubsub_rpc_dropped_total", "RPCs dropped because an outbound queue is full, by priority class.", "counter")
+	for class := RPCPriority(0); class < numRPCPriorities; class++ {
+		writeSample(w, "pubsub_rpc_dropped_total", labels("class", class.String()),
+			strconv.FormatUint(s.RPCDropped[class], 10))
+	}
+
+	peers := make([]string, 0, len(s.QueueDepth))
+	depths := make(map[string]int, len(s.QueueDepth))
+	for p, depth := range s.QueueDepth {
+		peers = append(peers, p.Pretty())
+		depths[p.Pretty()] = depth
+	}
+	sort.Strings(peers)
+
+	writeHeader(w, "pubsub_outbound_queue_depth", "RPCs in the outbound queue of a peer.", "gauge")
+	for _, p := range peers {
+		writeSample(w, "pubsub_outbound_queue_depth", labels("peer", p), strconv.Itoa(depths[p]))
+	}
+
+	vtopics := make([]string, 0, len(s.ValidationLatency))
+	for topic := range s.ValidationLatency {
+		vtopics = append(vtopics, topic)
+	}
+	sort.Strings(vtopics)
+
+	writeHeader(w, "pubsub_validation_duration_seconds", "Time taken by the validators of a topic.", "histogram")
+	for _, topic := range vtopics {
+		writeHistogram(w, "pubsub_validation_duration_seconds", []string{"topic", topic}, s.ValidationLatency[topic])
+	}
+
+	writeHeader(w, "pubsub_heartbeat_duration_seconds", "Time taken by the router heartbeat.", "histogram")
+	writeHistogram(w, "pubsub_heartbeat_duration_seconds", nil, s.HeartbeatDuration)
+}
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeSample(w *bufio.Writer, name, labels, value string) {
+	fmt.Fprintf(w, "%s%s %s\n", name, labels, value)
+}
+
+// writeHistogram writes the cumulative buckets, sum and count series of a histogram
+func writeHistogram(w *bufio.Writer, name string, kv []string, h Histogram) {
+	var cumulative uint64
+	for i, le := range h.Buckets {
+		cumulative += h.Counts[i]
+		writeSample(w, name+"_bucket", labels(append(kv, "le", strconv.FormatFloat(le, 'g', -1, 64))...),
+			strconv.FormatUint(cumulative, 10))
+	}
+	writeSample(w, name+"_bucket", labels(append(kv, "le", "+Inf")...), strconv.FormatUint(h.Count, 10))
+	writeSample(w, name+"_sum", labels(kv...), strconv.FormatFloat(h.Sum, 'g', -1, 64))
+	writeSample(w, name+"_count", labels(kv...), strconv.FormatUint(h.Count, 10))
+}
+
+// labels formats alternating label names and values, escaping the values
+func labels(kv ...string) string {
+	if len(kv) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", kv[i], labelEscaper.Replace(kv[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -2,14 +2,14 @@ package pubsub
 
 import (
 	"context"
-	"encoding/binary"
+	"errors"
 	"fmt"
 	"math/rand"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
 
 	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/host"
@@ -17,16 +17,34 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/protocol"
 
+	proto "github.com/gogo/protobuf/proto"
 	logging "github.com/ipfs/go-log"
-	timecache "github.com/whyrusleeping/timecache"
 )
 
 var (
+	// TimeCacheDuration is the default TTL of the seen messages cache, and the retention of
+	// the message delivery records of peer scoring.
 	TimeCacheDuration = 120 * time.Second
 )
 
+// DefaultMaxMessageSize is the default maximum size of the RPCs we read from our peers,
+// which also bounds the size of the messages we publish.
+const DefaultMaxMessageSize = 1 << 20
+
+// DefaultBatchMaxRPCs is the default maximum number of queued RPCs coalesced by the writer
+// of a peer into a single RPC.
+const DefaultBatchMaxRPCs = 64
+
 var log = logging.Logger("pubsub")
 
+// ErrPubSubClosed is returned by operations on a PubSub instance that has been closed,
+// and by Subscription.Next for subscriptions closed by the shutdown.
+var ErrPubSubClosed = errors.New("pubsub has been closed")
+
+// ErrMessageTooLarge is returned when publishing a message that exceeds the maximum
+// message size, or the maximum chunked message size when chunking is enabled.
+var ErrMessageTooLarge = errors.New("message too large")
+
 // PubSub is the implementation of the pubsub system.
 type PubSub struct {
 	// atomic counter for seqnos
@@ -35,6 +53,8 @@ type PubSub struct {
 	//
 	// See: https://golang.org/pkg/sync/atomic/#pkg-note-BUG
 	counter uint64
+	// seqnoLimit is the highest seqno persisted in the seqno store; accessed atomically
+	seqnoLimit uint64
 
 	host host.Host
 
@@ -51,6 +71,12 @@ type PubSub struct {
 	// addSub is a control channel for us to add and remove subscriptions
 	addSub chan *addSubReq
 
+	// addTopic is a control channel for us to add topic handles
+	addTopic chan *addTopicReq
+
+	// rmTopic is a control channel for us to remove topic handles
+	rmTopic chan *rmTopicReq
+
 	// get list of topics we are subscribed to
 	getTopics chan *topicReq
 
@@ -73,7 +99,17 @@ type PubSub struct {
 	peerDead chan peer.ID
 
 	// The set of topics we are subscribed to
-	myTopics map[string]map[*Subscription]struct{}
+	mySubs map[string]map[*Subscription]struct{}
+
+	// The set of topics we are relaying for, with the number of active relays
+	myRelays map[string]int
+
+	// addRelay and rmRelay are control channels for us to add and remove relays
+	addRelay chan *addRelayReq
+	rmRelay  chan string
+
+	// The set of topics we have joined, keyed by topic name
+	myTopics map[string]*Topic
 
 	// topics tracks which topics each of our peers are subscribed to
 	topics map[string]map[peer.ID]struct{}
@@ -94,10 +130,20 @@ type PubSub struct {
 	blacklist     Blacklist
 	blacklistPeer chan peer.ID
 
-	peers map[peer.ID]chan *RPC
+	// outbound queues of our peers
+	peers map[peer.ID]*rpcQueue
 
-	seenMessagesMx sync.Mutex
-	seenMessages   *timecache.TimeCache
+	// parameters of the priority classes of the outbound queues
+	queueParams [numRPCPriorities]queueClassParams
+
+	// cache of the IDs of the messages we have seen; the TTL and strategy configure
+	// the default cache
+	seenMessages SeenCache
+	seenTTL      time.Duration
+	seenStrategy SeenStrategy
+
+	// function used to compute the ID of a message
+	msgID MsgIdFunction
 
 	// key for signing messages; nil when signing is disabled (default for now)
 	signKey crypto.PrivKey
@@ -106,7 +152,69 @@ type PubSub struct {
 	// strict mode rejects all unsigned messages prior to validation
 	signStrict bool
 
-	ctx context.Context
+	// penalty hook invoked for peers forwarding messages rejected by validation
+	penaltyHook PeerPenaltyHook
+
+	// filter for the topics we subscribe to and track for our peers
+	subFilter SubscriptionFilter
+	// maximum number of subscriptions tracked per peer; 0 for no limit
+	maxPeerSubs int
+	// number of subscriptions tracked per peer
+	peerSubs map[peer.ID]int
+	// penalty hook invoked for peers sending RPCs that violate the subscription filter or limits
+	subPenaltyHook SubscriptionPenaltyHook
+
+	// tracer for the message lifecycle and pubsub events
+	tracer *pubsubTracer
+
+	// structured logger of the instance and its router
+	logger Logger
+
+	// metrics of the instance and its router
+	metrics Metrics
+
+	// persists the seqno counter; nil if the counter is only kept in memory
+	seqnoStore SeqnoStore
+	seqnoMx    sync.Mutex
+
+	// tracks the seqnos of the messages of other publishers; nil if disabled
+	gapTracker *GapTracker
+
+	// retains the messages we deliver, served over the history protocol; nil if disabled
+	history MessageStore
+	// number of history requests being served per peer
+	historyMx      sync.Mutex
+	historyStreams map[peer.ID]int
+
+	// maximum size of the RPCs we read and write
+	maxMessageSize int
+
+	// budget of the RPCs coalesced by the peer writers
+	batchMaxRPCs  int
+	batchMaxBytes int
+
+	// chunker for large messages; nil when chunking is disabled
+	chunker *chunker
+
+	// traffic counters of the compressed streams; nil when compression is disabled
+	compression *compressionTracker
+
+	// closeCh is a control channel for shutting down the event loop
+	closeCh chan struct{}
+
+	// closed is closed when Close is called; closeOnce guards it
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// done is closed when the event loop exits
+	done chan struct{}
+
+	// writers tracks the per peer writer goroutines, so that Close can wait
+	// for the outbound queues to be flushed
+	writers sync.WaitGroup
+
+	ctx    context.Context
+	cancel func()
 }
 
 // PubSubRouter is the message router component of PubSub.
@@ -131,16 +239,46 @@ type PubSubRouter interface {
 	// Leave notifies the router that we are no longer interested in a topic.
 	// It is invoked after the unsubscription announcement.
 	Leave(topic string)
+	// AcceptFrom is invoked on any incoming message before pushing it to the validation pipeline
+	// or processing control information.
+	// Allows routers with internal scoring to vet peers before commiting any processing resources
+	// to the message and implement an effective graylist.
+	AcceptFrom(peer.ID) bool
 }
 
 type Message struct {
 	*pb.Message
+	ReceivedFrom peer.ID
+
+	// local is the message with its payload decrypted, for topics with shared key encryption;
+	// the embedded message is the one on the wire, which is the one forwarded.
+	local *Message
+
+	// fragments are the fragments a chunked message was reassembled from, which are the
+	// messages forwarded in its place.
+	fragments []*Message
+
+	// topics are the topics of the message for which metrics are recorded; set when the
+	// message is admitted.
+	topics []string
+
+	// recovered is set for messages received in a history response, which are delivered to
+	// our subscriptions but not forwarded.
+	recovered bool
 }
 
 func (m *Message) GetFrom() peer.ID {
 	return peer.ID(m.Message.GetFrom())
 }
 
+// view returns the message as seen by validators and subscribers.
+func (m *Message) view() *Message {
+	if m.local != nil {
+		return m.local
+	}
+	return m
+}
+
 type RPC struct {
 	pb.RPC
 
@@ -152,61 +290,103 @@ type Option func(*PubSub) error
 
 // NewPubSub returns a new PubSub management object.
 func NewPubSub(ctx context.Context, h host.Host, rt PubSubRouter, opts ...Option) (*PubSub, error) {
-	fmt.Println("In NewPubSub")
+	ctx, cancel := context.WithCancel(ctx)
 	ps := &PubSub{
-		host:          h,
-		ctx:           ctx,
-		rt:            rt,
-		val:           newValidation(),
-		signID:        h.ID(),
-		signKey:       h.Peerstore().PrivKey(h.ID()),
-		signStrict:    true,
-		incoming:      make(chan *RPC, 32),
-		publish:       make(chan *Message),
-		newPeers:      make(chan peer.ID),
-		newPeerStream: make(chan network.Stream),
-		newPeerError:  make(chan peer.ID),
-		peerDead:      make(chan peer.ID),
-		cancelCh:      make(chan *Subscription),
-		getPeers:      make(chan *listPeerReq),
-		addSub:        make(chan *addSubReq),
-		getTopics:     make(chan *topicReq),
-		sendMsg:       make(chan *sendReq, 32),
-		addVal:        make(chan *addValReq),
-		rmVal:         make(chan *rmValReq),
-		eval:          make(chan func()),
-		myTopics:      make(map[string]map[*Subscription]struct{}),
-		topics:        make(map[string]map[peer.ID]struct{}),
-		peers:         make(map[peer.ID]chan *RPC),
-		blacklist:     NewMapBlacklist(),
-		blacklistPeer: make(chan peer.ID),
-		seenMessages:  timecache.NewTimeCache(TimeCacheDuration),
-		counter:       uint64(time.Now().UnixNano()),
+		host:           h,
+		ctx:            ctx,
+		cancel:         cancel,
+		rt:             rt,
+		val:            newValidation(),
+		signID:         h.ID(),
+		signKey:        h.Peerstore().PrivKey(h.ID()),
+		signStrict:     true,
+		maxMessageSize: DefaultMaxMessageSize,
+		batchMaxRPCs:   DefaultBatchMaxRPCs,
+		incoming:       make(chan *RPC, 32),
+		publish:        make(chan *Message),
+		newPeers:       make(chan peer.ID),
+		newPeerStream:  make(chan network.Stream),
+		newPeerError:   make(chan peer.ID),
+		peerDead:       make(chan peer.ID),
+		cancelCh:       make(chan *Subscription),
+		getPeers:       make(chan *listPeerReq),
+		addSub:         make(chan *addSubReq),
+		addTopic:       make(chan *addTopicReq),
+		rmTopic:        make(chan *rmTopicReq),
+		getTopics:      make(chan *topicReq),
+		sendMsg:        make(chan *sendReq, 32),
+		addVal:         make(chan *addValReq),
+		rmVal:          make(chan *rmValReq),
+		eval:           make(chan func()),
+		closeCh:        make(chan struct{}),
+		closed:         make(chan struct{}),
+		done:           make(chan struct{}),
+		mySubs:         make(map[string]map[*Subscription]struct{}),
+		myRelays:       make(map[string]int),
+		addRelay:       make(chan *addRelayReq),
+		rmRelay:        make(chan string),
+		myTopics:       make(map[string]*Topic),
+		topics:         make(map[string]map[peer.ID]struct{}),
+		peerSubs:       make(map[peer.ID]int),
+		historyStreams: make(map[peer.ID]int),
+		peers:          make(map[peer.ID]*rpcQueue),
+		queueParams:    defaultQueueParams(),
+		blacklist:      NewMapBlacklist(),
+		blacklistPeer:  make(chan peer.ID),
+		seenTTL:        TimeCacheDuration,
+		msgID:          DefaultMsgIdFn,
+		tracer:         &pubsubTracer{pid: h.ID()},
+		logger:         newGoLogger(log),
+		metrics:        NewMemoryMetrics(),
+		counter:        uint64(time.Now().UnixNano()),
 	}
 
 	for _, opt := range opts {
 		err := opt(ps)
 		if err != nil {
+			cancel()
 			return nil, err
 		}
 	}
 
 	if ps.signStrict && ps.signKey == nil {
+		cancel()
 		return nil, fmt.Errorf("strict signature verification enabled but message signing is disabled")
 	}
 
+	if ps.seenMessages == nil {
+		ps.seenMessages = NewTimeBucketCache(ps.seenTTL, ps.seenStrategy)
+	}
+
+	ps.tracer.msgID = ps.msgID
+	if ps.chunker != nil {
+		ps.chunker.logger = ps.logger
+	}
+	if ls, ok := ps.blacklist.(loggerSetter); ok {
+		ls.setLogger(ps.logger)
+	}
+	if ls, ok := ps.tracer.tracer.(loggerSetter); ok {
+		ls.setLogger(ps.logger)
+	}
+
 	rt.Attach(ps)
 
-	for _, id := range rt.Protocols() {
+	for _, id := range ps.protocols() {
 		h.SetStreamHandler(id, ps.handleNewStream)
 	}
-	fmt.Println("Register PubSubNotif")
+	if ps.history != nil {
+		h.SetStreamHandler(HistoryID, ps.handleHistoryStream)
+	}
 	h.Network().Notify((*PubSubNotif)(ps))
 
 	ps.val.Start(ps)
 
 	go ps.processLoop(ctx)
 
+	if ps.chunker != nil {
+		go ps.expireChunks()
+	}
+
 	return ps, nil
 }
 
@@ -246,6 +426,39 @@ func WithMessageAuthor(author peer.ID) Option {
 	}
 }
 
+// WithMaxMessageSize is an option to set the maximum size of the RPCs we read from our peers,
+// which defaults to DefaultMaxMessageSize. Larger RPCs reset the stream they are read from, so
+// publishing messages that don't fit in an RPC fails with ErrMessageTooLarge.
+// All peers in a network should use the same maximum size.
+func WithMaxMessageSize(size int) Option {
+	return func(p *PubSub) error {
+		if size <= 0 {
+			return errors.New("invalid max message size; must be positive")
+		}
+		p.maxMessageSize = size
+		return nil
+	}
+}
+
+// WithRPCBatching is an option to set the budget of the peer writers, which coalesce the
+// RPCs queued for a peer into a single RPC with a single flush. The writers coalesce up to
+// maxRPCs RPCs, with a total size of up to maxBytes bytes; a maxBytes of 0 uses the maximum
+// message size, which also caps it. The default budget is DefaultBatchMaxRPCs RPCs with the
+// maximum message size; setting maxRPCs to 1 disables batching.
+func WithRPCBatching(maxRPCs, maxBytes int) Option {
+	return func(p *PubSub) error {
+		if maxRPCs <= 0 {
+			return errors.New("invalid batch size; must be positive")
+		}
+		if maxBytes < 0 {
+			return errors.New("invalid batch byte budget; must be non-negative")
+		}
+		p.batchMaxRPCs = maxRPCs
+		p.batchMaxBytes = maxBytes
+		return nil
+	}
+}
+
 // WithStrictSignatureVerification is an option to enable or disable strict message signing.
 // When enabled (which is the default), unsigned messages will be discarded.
 func WithStrictSignatureVerification(required bool) Option {
@@ -255,6 +468,31 @@ func WithStrictSignatureVerification(required bool) Option {
 	}
 }
 
+// WithMessageIdFn is an option to customize the way a message ID is computed for a pubsub message.
+// The default ID function is DefaultMsgIdFn (concatenation of source and seqno), but it can be
+// customized to e.g. the hash of the message data for content-addressed deduplication.
+// The ID function is used consistently for the seen messages cache, the gossipsub
+// message cache and IHAVE/IWANT gossip.
+func WithMessageIdFn(fn MsgIdFunction) Option {
+	return func(p *PubSub) error {
+		if fn == nil {
+			return fmt.Errorf("message ID function must not be nil")
+		}
+		p.msgID = fn
+		return nil
+	}
+}
+
+// WithPeerPenaltyHook sets a callback that is invoked whenever a peer forwards a
+// message that is rejected by validation. Messages that are ignored by validators
+// (ValidationIgnore) or dropped due to throttling do not trigger the hook.
+func WithPeerPenaltyHook(hook PeerPenaltyHook) Option {
+	return func(p *PubSub) error {
+		p.penaltyHook = hook
+		return nil
+	}
+}
+
 // WithBlacklist provides an implementation of the blacklist; the default is a
 // MapBlacklist
 func WithBlacklist(b Blacklist) Option {
@@ -264,80 +502,165 @@ func WithBlacklist(b Blacklist) Option {
 	}
 }
 
+// Close shuts down the pubsub system gracefully: it announces to our peers that we
+// are leaving all the topics we are subscribed to (pruning the gossipsub meshes),
+// closes all subscriptions with ErrPubSubClosed, stops accepting new streams, flushes
+// the outbound queues and waits for the writer and validation goroutines to exit.
+// The context bounds the time spent waiting; the pubsub system is shut down even
+// if it expires, but messages in flight may be lost.
+// Subsequent operations on the PubSub instance fail with ErrPubSubClosed.
+func (p *PubSub) Close(ctx context.Context) error {
+	first := false
+	p.closeOnce.Do(func() {
+		first = true
+		close(p.closed)
+	})
+	if !first {
+		return ErrPubSubClosed
+	}
+
+	for _, id := range p.protocols() {
+		p.host.RemoveStreamHandler(id)
+	}
+	if p.history != nil {
+		p.host.RemoveStreamHandler(HistoryID)
+	}
+	p.host.Network().StopNotify((*PubSubNotif)(p))
+
+	// the event loop closes the outbound queues when it exits, which lets the writers
+	// drain them and close their streams.
+	select {
+	case p.closeCh <- struct{}{}:
+	case <-p.done:
+		// the event loop has already exited, because the context was cancelled
+	}
+	<-p.done
+
+	err := waitGroup(ctx, &p.writers)
+
+	p.cancel()
+
+	verr := waitGroup(ctx, &p.val.workers)
+	if err == nil {
+		err = verr
+	}
+
+	if p.seqnoStore != nil {
+		serr := p.storeSeqno()
+		if err == nil {
+			err = serr
+		}
+	}
+
+	return err
+}
+
+// closedErr returns the error reported by operations attempted after the event
+// loop has exited.
+func (p *PubSub) closedErr() error {
+	select {
+	case <-p.closed:
+		return ErrPubSubClosed
+	default:
+		return p.ctx.Err()
+	}
+}
+
+// waitGroup waits for the goroutines of a WaitGroup to exit, or until the context is done.
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // processLoop handles all inputs arriving on the channels
 func (p *PubSub) processLoop(ctx context.Context) {
 	defer func() {
 		// Clean up go routines.
-		for _, ch := range p.peers {
-			close(ch)
+		for _, q := range p.peers {
+			q.Close()
 		}
 		p.peers = nil
 		p.topics = nil
+		close(p.done)
 	}()
 
 	for {
 		select {
 		case pid := <-p.newPeers:
-			fmt.Println("<-p.newPeers", p.host.ID(), pid)
 			if _, ok := p.peers[pid]; ok {
-				log.Warning("already have connection to peer: ", pid)
+				p.logger.Warnw("already have connection to peer", "peer", pid)
 				continue
 			}
 
 			if p.blacklist.Contains(pid) {
-				log.Warning("ignoring connection from blacklisted peer: ", pid)
+				p.logger.Warnw("ignoring connection from blacklisted peer", "peer", pid)
 				continue
 			}
 
-			messages := make(chan *RPC, 32)
-			messages <- p.getHelloPacket()
+			p.logger.Debugw("new peer", "peer", pid)
+
+			messages := p.newPeerQueue(pid)
+			p.writers.Add(1)
 			go p.handleNewPeer(ctx, pid, messages)
 			p.peers[pid] = messages
 
 		case s := <-p.newPeerStream:
-			fmt.Println("<-p.newPeerStream")
 			pid := s.Conn().RemotePeer()
 
-			ch, ok := p.peers[pid]
+			q, ok := p.peers[pid]
 			if !ok {
-				log.Warning("new stream for unknown peer: ", pid)
+				p.logger.Warnw("new stream for unknown peer", "peer", pid)
 				s.Reset()
 				continue
 			}
 
 			if p.blacklist.Contains(pid) {
-				log.Warning("closing stream for blacklisted peer: ", pid)
-				close(ch)
+				p.logger.Warnw("closing stream for blacklisted peer", "peer", pid)
+				q.Close()
+				delete(p.peers, pid)
 				s.Reset()
 				continue
 			}
 
-			p.rt.AddPeer(pid, s.Protocol())
+			id := baseProtocol(s.Protocol())
+			p.logger.Debugw("new stream to peer", "peer", pid, "protocol", s.Protocol())
+			p.rt.AddPeer(pid, id)
+			p.tracer.AddPeer(pid, id)
 
 		case pid := <-p.newPeerError:
-			fmt.Println("<-p.newPeerError")
+			p.logger.Debugw("failed to open stream to peer", "peer", pid)
 			delete(p.peers, pid)
 
 		case pid := <-p.peerDead:
-			fmt.Println("<-p.peerDead")
-			ch, ok := p.peers[pid]
+			q, ok := p.peers[pid]
 			if !ok {
 				continue
 			}
 
-			close(ch)
+			q.Close()
 
 			if p.host.Network().Connectedness(pid) == network.Connected {
 				// still connected, must be a duplicate connection being closed.
 				// we respawn the writer as we need to ensure there is a stream active
-				log.Warning("peer declared dead but still connected; respawning writer: ", pid)
-				messages := make(chan *RPC, 32)
-				messages <- p.getHelloPacket()
+				p.logger.Warnw("peer declared dead but still connected; respawning writer", "peer", pid)
+				messages := p.newPeerQueue(pid)
+				p.writers.Add(1)
 				go p.handleNewPeer(ctx, pid, messages)
 				p.peers[pid] = messages
 				continue
 			}
 
+			p.logger.Debugw("peer dead", "peer", pid)
 			delete(p.peers, pid)
 			for t, tmap := range p.topics {
 				if _, ok := tmap[pid]; ok {
@@ -345,24 +668,34 @@ func (p *PubSub) processLoop(ctx context.Context) {
 					p.notifyLeave(t, pid)
 				}
 			}
+			delete(p.peerSubs, pid)
 
 			p.rt.RemovePeer(pid)
+			p.tracer.RemovePeer(pid)
+
+			if p.compression != nil {
+				p.compression.remove(pid)
+			}
 
 		case treq := <-p.getTopics:
-			fmt.Println("<-p.getTopics")
 			var out []string
-			for t := range p.myTopics {
+			for t := range p.mySubs {
 				out = append(out, t)
 			}
 			treq.resp <- out
+		case topic := <-p.addTopic:
+			p.handleAddTopic(topic)
+		case topic := <-p.rmTopic:
+			p.handleRemoveTopic(topic)
 		case sub := <-p.cancelCh:
-			fmt.Println("<-p.cancelCh")
 			p.handleRemoveSubscription(sub)
 		case sub := <-p.addSub:
-			fmt.Println("<-p.addSub")
 			p.handleAddSubscription(sub)
+		case relay := <-p.addRelay:
+			p.handleAddRelay(relay)
+		case topic := <-p.rmRelay:
+			p.handleRemoveRelay(topic)
 		case preq := <-p.getPeers:
-			fmt.Println("<-p.getPeers")
 			tmap, ok := p.topics[preq.topic]
 			if preq.topic != "" && !ok {
 				preq.resp <- nil
@@ -380,37 +713,35 @@ func (p *PubSub) processLoop(ctx context.Context) {
 			}
 			preq.resp <- peers
 		case rpc := <-p.incoming:
-			fmt.Println("<-p.incoming")
+			p.logger.Debugw("received RPC", "peer", rpc.from,
+				"subscriptions", len(rpc.GetSubscriptions()), "messages", len(rpc.GetPublish()))
 			p.handleIncomingRPC(rpc)
 
 		case msg := <-p.publish:
-			fmt.Println("<-p.publish")
+			p.logger.Debugw("publishing message", p.msgFields(msg)...)
+			p.tracer.PublishMessage(msg)
 			p.pushMsg(p.host.ID(), msg)
 
 		case req := <-p.sendMsg:
-			fmt.Println("<-p.sendMsg")
-			p.publishMessage(req.from, req.msg.Message)
+			p.logger.Debugw("forwarding validated message", p.msgFields(req.msg)...)
+			p.publishMessage(req.msg)
 
 		case req := <-p.addVal:
-			fmt.Println("<-p.addVal")
 			p.val.AddValidator(req)
 
 		case req := <-p.rmVal:
-			fmt.Println("<-p.rmVal")
 			p.val.RemoveValidator(req)
 
 		case thunk := <-p.eval:
-			fmt.Println("<-p.eval")
 			thunk()
 
 		case pid := <-p.blacklistPeer:
-			fmt.Println("<-p.blacklistPeer")
-			log.Infof("Blacklisting peer %s", pid)
+			p.logger.Infow("blacklisting peer", "peer", pid)
 			p.blacklist.Add(pid)
 
-			ch, ok := p.peers[pid]
+			q, ok := p.peers[pid]
 			if ok {
-				close(ch)
+				q.Close()
 				delete(p.peers, pid)
 				for t, tmap := range p.topics {
 					if _, ok := tmap[pid]; ok {
@@ -418,23 +749,115 @@ func (p *PubSub) processLoop(ctx context.Context) {
 						p.notifyLeave(t, pid)
 					}
 				}
+				delete(p.peerSubs, pid)
 				p.rt.RemovePeer(pid)
+				p.tracer.RemovePeer(pid)
+
+				if p.compression != nil {
+					p.compression.remove(pid)
+				}
 			}
 
+			if eb, ok := p.blacklist.(ExpiringBlacklist); ok {
+				if expiry, ok := eb.Expiry(pid); ok {
+					p.scheduleReadmit(pid, expiry)
+				}
+			}
+
+		case <-p.closeCh:
+			p.handleClose()
+			p.logger.Infow("pubsub processloop closed")
+			return
+
 		case <-ctx.Done():
-			fmt.Println("<-ctx.Done()")
-			log.Info("pubsub processloop shutting down")
+			p.logger.Infow("pubsub processloop shutting down")
 			return
 		}
 	}
 }
 
+// handleClose announces that we are leaving all the topics we are subscribed to and
+// closes all subscriptions, in preparation for shutting down the event loop.
+// Only called from processLoop.
+func (p *PubSub) handleClose() {
+	for topic, subs := range p.mySubs {
+		for sub := range subs {
+			sub.err = ErrPubSubClosed
+			sub.close()
+		}
+
+		delete(p.myRelays, topic)
+		p.announce(topic, false)
+		p.rt.Leave(topic)
+		p.tracer.Leave(topic)
+	}
+
+	for topic := range p.myRelays {
+		p.announce(topic, false)
+		p.rt.Leave(topic)
+		p.tracer.Leave(topic)
+	}
+
+	p.mySubs = make(map[string]map[*Subscription]struct{})
+	p.myRelays = make(map[string]int)
+}
+
+// handleAddTopic adds a topic handle for a particular topic. If a handle
+// already exists for the topic, the existing handle is returned.
+// Only called from processLoop.
+func (p *PubSub) handleAddTopic(req *addTopicReq) {
+	topic := req.topic
+	t, ok := p.myTopics[topic.topic]
+	if ok {
+		req.resp <- t
+		return
+	}
+
+	p.myTopics[topic.topic] = topic
+	req.resp <- topic
+}
+
+// handleRemoveTopic removes a topic handle from bookkeeping.
+// The handle can only be removed when there are no active subscriptions,
+// event handlers or validators for the topic.
+// Only called from processLoop.
+func (p *PubSub) handleRemoveTopic(req *rmTopicReq) {
+	topic := p.myTopics[req.topic.topic]
+	if topic != req.topic {
+		req.resp <- nil
+		return
+	}
+
+	if len(p.mySubs[topic.topic]) > 0 {
+		req.resp <- fmt.Errorf("cannot close topic %s: outstanding subscriptions", topic.topic)
+		return
+	}
+
+	if p.myRelays[topic.topic] > 0 {
+		req.resp <- fmt.Errorf("cannot close topic %s: outstanding relays", topic.topic)
+		return
+	}
+
+	if topic.hasEventHandlers() {
+		req.resp <- fmt.Errorf("cannot close topic %s: outstanding event handlers", topic.topic)
+		return
+	}
+
+	if _, ok := p.val.topicVals[topic.topic]; ok {
+		req.resp <- fmt.Errorf("cannot close topic %s: outstanding validator", topic.topic)
+		return
+	}
+
+	delete(p.myTopics, topic.topic)
+	req.resp <- nil
+}
+
 // handleRemoveSubscription removes Subscription sub from bookeeping.
 // If this was the last Subscription for a given topic, it will also announce
 // that this node is not subscribing to this topic anymore.
 // Only called from processLoop.
 func (p *PubSub) handleRemoveSubscription(sub *Subscription) {
-	subs := p.myTopics[sub.topic]
+	subs := p.mySubs[sub.topic]
 
 	if subs == nil {
 		return
@@ -445,9 +868,16 @@ func (p *PubSub) handleRemoveSubscription(sub *Subscription) {
 	delete(subs, sub)
 
 	if len(subs) == 0 {
-		delete(p.myTopics, sub.topic)
+		delete(p.mySubs, sub.topic)
+
+		// stay in the topic if we are still relaying it
+		if p.myRelays[sub.topic] > 0 {
+			return
+		}
+
 		p.announce(sub.topic, false)
 		p.rt.Leave(sub.topic)
+		p.tracer.Leave(sub.topic)
 	}
 }
 
@@ -457,18 +887,19 @@ func (p *PubSub) handleRemoveSubscription(sub *Subscription) {
 // Only called from processLoop.
 func (p *PubSub) handleAddSubscription(req *addSubReq) {
 	sub := req.sub
-	subs := p.myTopics[sub.topic]
+	subs := p.mySubs[sub.topic]
 
-	// announce we want this topic
-	if len(subs) == 0 {
+	// announce we want this topic, unless we are already relaying it
+	if len(subs) == 0 && p.myRelays[sub.topic] == 0 {
 		p.announce(sub.topic, true)
 		p.rt.Join(sub.topic)
+		p.tracer.Join(sub.topic)
 	}
 
 	// make new if not there
 	if subs == nil {
-		p.myTopics[sub.topic] = make(map[*Subscription]struct{})
-		subs = p.myTopics[sub.topic]
+		p.mySubs[sub.topic] = make(map[*Subscription]struct{})
+		subs = p.mySubs[sub.topic]
 	}
 
 	tmap := p.topics[sub.topic]
@@ -477,12 +908,67 @@ func (p *PubSub) handleAddSubscription(req *addSubReq) {
 		sub.evtLog[p] = PeerJoin
 	}
 	sub.cancelCh = p.cancelCh
+	sub.ctx = p.ctx
+
+	if sub.order != nil {
+		sub.order.logger = p.logger
+		sub.out = make(chan *Message, cap(sub.ch))
+		go sub.reorder()
+	}
 
-	p.myTopics[sub.topic][sub] = struct{}{}
+	p.mySubs[sub.topic][sub] = struct{}{}
 
 	req.resp <- sub
 }
 
+// handleAddRelay adds a relay for a particular topic. If it is the first relay and
+// we have no subscriptions for the topic, it will announce that this node subscribes
+// to the topic.
+// Only called from processLoop.
+func (p *PubSub) handleAddRelay(req *addRelayReq) {
+	topic := req.topic
+
+	if p.myRelays[topic] == 0 && len(p.mySubs[topic]) == 0 {
+		p.announce(topic, true)
+		p.rt.Join(topic)
+		p.tracer.Join(topic)
+	}
+
+	p.myRelays[topic]++
+
+	req.resp <- struct{}{}
+}
+
+// handleRemoveRelay removes a relay for a particular topic. If it was the last relay
+// and we have no subscriptions for the topic, it will announce that this node is not
+// subscribing to this topic anymore.
+// Only called from processLoop.
+func (p *PubSub) handleRemoveRelay(topic string) {
+	if p.myRelays[topic] == 0 {
+		return
+	}
+
+	p.myRelays[topic]--
+	if p.myRelays[topic] > 0 {
+		return
+	}
+
+	delete(p.myRelays, topic)
+
+	if len(p.mySubs[topic]) == 0 {
+		p.announce(topic, false)
+		p.rt.Leave(topic)
+		p.tracer.Leave(topic)
+	}
+}
+
+// wantsTopic returns whether we are subscribed to or relaying a topic.
+// Only called from processLoop.
+func (p *PubSub) wantsTopic(topic string) bool {
+	_, ok := p.mySubs[topic]
+	return ok || p.myRelays[topic] > 0
+}
+
 // announce announces whether or not this node is interested in a given topic
 // Only called from processLoop.
 func (p *PubSub) announce(topic string, sub bool) {
@@ -492,13 +978,8 @@ func (p *PubSub) announce(topic string, sub bool) {
 	}
 
 	out := rpcWithSubs(subopt)
-	for pid, peer := range p.peers {
-		select {
-		case peer <- out:
-		default:
-			log.Infof("Can't send announce message to peer %s: queue full; scheduling retry", pid)
-			go p.announceRetry(pid, topic, sub)
-		}
+	for pid := range p.peers {
+		p.sendRPC(pid, out, PriorityControl)
 	}
 }
 
@@ -506,7 +987,7 @@ func (p *PubSub) announceRetry(pid peer.ID, topic string, sub bool) {
 	time.Sleep(time.Duration(1+rand.Intn(1000)) * time.Millisecond)
 
 	retry := func() {
-		_, ok := p.myTopics[topic]
+		ok := p.wantsTopic(topic)
 		if (ok && sub) || (!ok && !sub) {
 			p.doAnnounceRetry(pid, topic, sub)
 		}
@@ -519,35 +1000,27 @@ func (p *PubSub) announceRetry(pid peer.ID, topic string, sub bool) {
 }
 
 func (p *PubSub) doAnnounceRetry(pid peer.ID, topic string, sub bool) {
-	peer, ok := p.peers[pid]
-	if !ok {
-		return
-	}
-
 	subopt := &pb.RPC_SubOpts{
 		Topicid:   &topic,
 		Subscribe: &sub,
 	}
 
-	out := rpcWithSubs(subopt)
-	select {
-	case peer <- out:
-	default:
-		log.Infof("Can't send announce message to peer %s: queue full; scheduling retry", pid)
-		go p.announceRetry(pid, topic, sub)
-	}
+	p.sendRPC(pid, rpcWithSubs(subopt), PriorityControl)
 }
 
 // notifySubs sends a given message to all corresponding subscribers.
 // Only called from processLoop.
-func (p *PubSub) notifySubs(msg *pb.Message) {
+func (p *PubSub) notifySubs(msg *Message) {
+	msg = msg.view()
 	for _, topic := range msg.GetTopicIDs() {
-		subs := p.myTopics[topic]
+		subs := p.mySubs[topic]
 		for f := range subs {
 			select {
-			case f.ch <- &Message{msg}:
+			case f.ch <- msg:
 			default:
-				log.Infof("Can't deliver message to subscription for topic %s; subscriber too slow", topic)
+				p.metrics.SubscriberDropped(topic)
+				p.logger.Infow("can't deliver message to subscription; subscriber too slow",
+					"topic", topic, "msgid", p.logMsgID(msg.Message))
 			}
 		}
 	}
@@ -555,41 +1028,46 @@ func (p *PubSub) notifySubs(msg *pb.Message) {
 
 // seenMessage returns whether we already saw this message before
 func (p *PubSub) seenMessage(id string) bool {
-	p.seenMessagesMx.Lock()
-	defer p.seenMessagesMx.Unlock()
 	return p.seenMessages.Has(id)
 }
 
 // markSeen marks a message as seen such that seenMessage returns `true' for the given id
 // returns true if the message was freshly marked
 func (p *PubSub) markSeen(id string) bool {
-	p.seenMessagesMx.Lock()
-	defer p.seenMessagesMx.Unlock()
-	if p.seenMessages.Has(id) {
-		return false
-	}
-
-	p.seenMessages.Add(id)
-	return true
+	return p.seenMessages.Add(id)
 }
 
-// subscribedToMessage returns whether we are subscribed to one of the topics
+// subscribedToMessage returns whether we are subscribed to or relaying one of the topics
 // of a given message
 func (p *PubSub) subscribedToMsg(msg *pb.Message) bool {
-	if len(p.myTopics) == 0 {
+	if len(p.mySubs) == 0 && len(p.myRelays) == 0 {
 		return false
 	}
 
 	for _, t := range msg.GetTopicIDs() {
-		if _, ok := p.myTopics[t]; ok {
+		if p.wantsTopic(t) {
 			return true
 		}
 	}
 	return false
 }
 
+func (p *PubSub) notifyJoin(topic string, pid peer.ID) {
+	if t, ok := p.myTopics[topic]; ok {
+		t.sendNotification(PeerEvent{PeerJoin, pid})
+	}
+	if subs, ok := p.mySubs[topic]; ok {
+		for s := range subs {
+			s.sendNotification(PeerEvent{PeerJoin, pid})
+		}
+	}
+}
+
 func (p *PubSub) notifyLeave(topic string, pid peer.ID) {
-	if subs, ok := p.myTopics[topic]; ok {
+	if t, ok := p.myTopics[topic]; ok {
+		t.sendNotification(PeerEvent{PeerLeave, pid})
+	}
+	if subs, ok := p.mySubs[topic]; ok {
 		for s := range subs {
 			s.sendNotification(PeerEvent{PeerLeave, pid})
 		}
@@ -597,7 +1075,18 @@ func (p *PubSub) notifyLeave(topic string, pid peer.ID) {
 }
 
 func (p *PubSub) handleIncomingRPC(rpc *RPC) {
-	for _, subopt := range rpc.GetSubscriptions() {
+	p.tracer.RecvRPC(rpc)
+
+	subs, err := p.filterIncomingSubscriptions(rpc)
+	if err != nil {
+		p.logger.Debugw("subscription filter error; dropping RPC", "peer", rpc.from, "error", err)
+		if p.subPenaltyHook != nil {
+			p.subPenaltyHook(rpc.from, err)
+		}
+		return
+	}
+
+	for _, subopt := range subs {
 		t := subopt.GetTopicid()
 		if subopt.GetSubscribe() {
 			tmap, ok := p.topics[t]
@@ -608,12 +1097,8 @@ func (p *PubSub) handleIncomingRPC(rpc *RPC) {
 
 			if _, ok = tmap[rpc.from]; !ok {
 				tmap[rpc.from] = struct{}{}
-				if subs, ok := p.myTopics[t]; ok {
-					peer := rpc.from
-					for s := range subs {
-						s.sendNotification(PeerEvent{PeerJoin, peer})
-					}
-				}
+				p.peerSubs[rpc.from]++
+				p.notifyJoin(t, rpc.from)
 			}
 		} else {
 			tmap, ok := p.topics[t]
@@ -623,67 +1108,153 @@ func (p *PubSub) handleIncomingRPC(rpc *RPC) {
 
 			if _, ok := tmap[rpc.from]; ok {
 				delete(tmap, rpc.from)
+				p.peerSubs[rpc.from]--
 				p.notifyLeave(t, rpc.from)
 			}
 		}
 	}
 
+	// ask the router to vet the peer before commiting any processing resources
+	if !p.rt.AcceptFrom(rpc.from) {
+		p.logger.Infow("received message from router graylisted peer; dropping RPC", "peer", rpc.from)
+		return
+	}
+
 	for _, pmsg := range rpc.GetPublish() {
 		if !p.subscribedToMsg(pmsg) {
-			log.Warning("received message we didn't subscribe to. Dropping.")
+			p.logger.Warnw("received message we didn't subscribe to; dropping", "peer", rpc.from,
+				"topic", topicsField(pmsg.GetTopicIDs()))
 			continue
 		}
 
-		msg := &Message{pmsg}
+		msg := &Message{Message: pmsg, ReceivedFrom: rpc.from}
 		p.pushMsg(rpc.from, msg)
 	}
 
 	p.rt.HandleRPC(rpc)
 }
 
-// msgID returns a unique ID of the passed Message
-func msgID(pmsg *pb.Message) string {
+// MsgIdFunction returns a unique ID for the passed Message; PubSub can be customized to use
+// any implementation of this function by configuring it with WithMessageIdFn.
+type MsgIdFunction func(pmsg *pb.Message) string
+
+// DefaultMsgIdFn returns a unique ID of the passed Message, the concatenation of
+// the source and the sequence number.
+func DefaultMsgIdFn(pmsg *pb.Message) string {
 	return string(pmsg.GetFrom()) + string(pmsg.GetSeqno())
 }
 
 // pushMsg pushes a message performing validation as necessary
 func (p *PubSub) pushMsg(src peer.ID, msg *Message) {
+	req := p.admitMsg(src, msg)
+	if req != nil {
+		p.val.enqueue(req)
+	}
+}
+
+// admitMsg performs the checks of a message before validation, delivering at once the
+// messages that don't need validation. It returns the validation request of the message,
+// or nil if the message was delivered or dropped.
+func (p *PubSub) admitMsg(src peer.ID, msg *Message) *validateReq {
+	msg.topics = p.metricTopics(src, msg)
+	for _, topic := range msg.topics {
+		if src == p.host.ID() {
+			p.metrics.MessagePublished(topic)
+		} else {
+			p.metrics.MessageReceived(topic)
+		}
+	}
+
 	// reject messages from blacklisted peers
 	if p.blacklist.Contains(src) {
-		log.Warningf("dropping message from blacklisted peer %s", src)
-		return
+		p.logger.Warnw("dropping message from blacklisted peer", p.msgFields(msg)...)
+		p.rejectMessage(msg, rejectBlacklistedPeer)
+		return nil
 	}
 
 	// even if they are forwarded by good peers
 	if p.blacklist.Contains(msg.GetFrom()) {
-		log.Warningf("dropping message from blacklisted source %s", src)
-		return
+		p.logger.Warnw("dropping message from blacklisted source", append(p.msgFields(msg), "from", msg.GetFrom())...)
+		p.rejectMessage(msg, rejectBlacklistedSource)
+		return nil
 	}
 
 	// reject unsigned messages when strict before we even process the id
 	if p.signStrict && msg.Signature == nil {
-		log.Debugf("dropping unsigned message from %s", src)
-		return
+		p.logger.Debugw("dropping unsigned message", p.msgFields(msg)...)
+		p.rejectMessage(msg, rejectMissingSignature)
+		return nil
+	}
+
+	// reject messages in authenticated topics that are not signed by a trusted key
+	if !p.authorizeMsg(msg) {
+		p.logger.Debugw("dropping message from unauthorized publisher", append(p.msgFields(msg), "from", msg.GetFrom())...)
+		p.rejectMessage(msg, rejectUnauthorizedPublisher)
+		p.penalizePeer(src, msg)
+		return nil
+	}
+
+	id := p.msgID(msg.Message)
+
+	// messages that don't need validation are checked and marked as seen in one step
+	req := p.val.newRequest(src, msg)
+	if req == nil {
+		if !p.markSeen(id) {
+			p.duplicateMessage(msg)
+			return nil
+		}
+		p.publishMessage(msg)
+		return nil
 	}
 
 	// have we already seen and validated this message?
-	id := msgID(msg.Message)
 	if p.seenMessage(id) {
-		return
+		p.duplicateMessage(msg)
+		return nil
 	}
 
-	if !p.val.Push(src, msg) {
+	return req
+}
+
+// penalizePeer reports a peer that forwarded a rejected message to the penalty hook.
+// It is called from the validation goroutines.
+func (p *PubSub) penalizePeer(src peer.ID, msg *Message) {
+	// we don't penalize ourselves for our own publishes
+	if src == p.host.ID() {
 		return
 	}
 
-	if p.markSeen(id) {
-		p.publishMessage(src, msg.Message)
+	if p.penaltyHook != nil {
+		p.penaltyHook(src, msg)
 	}
 }
 
-func (p *PubSub) publishMessage(from peer.ID, pmsg *pb.Message) {
-	p.notifySubs(pmsg)
-	p.rt.Publish(from, pmsg)
+func (p *PubSub) publishMessage(msg *Message) {
+	if p.isFragment(msg) {
+		p.reassemble(msg)
+		return
+	}
+
+	p.tracer.DeliverMessage(msg)
+	p.trackGaps(msg)
+	p.retainMsg(msg)
+	for _, topic := range msg.topics {
+		p.metrics.MessageDelivered(topic)
+	}
+	p.notifySubs(msg)
+
+	if msg.recovered {
+		return
+	}
+
+	if msg.fragments != nil {
+		for _, frag := range msg.fragments {
+			p.rt.Publish(frag.ReceivedFrom, frag.Message)
+		}
+		return
+	}
+
+	p.rt.Publish(msg.ReceivedFrom, msg.Message)
 }
 
 type addSubReq struct {
@@ -693,9 +1264,120 @@ type addSubReq struct {
 
 type SubOpt func(sub *Subscription) error
 
+// Join joins the topic and returns a Topic handle. Only one Topic handle should exist per topic,
+// and Join will error if the Topic handle already exists.
+func (p *PubSub) Join(topic string) (*Topic, error) {
+	td := pb.TopicDescriptor{Name: &topic}
+
+	return p.JoinByTopicDescriptor(&td)
+}
+
+// JoinByTopicDescriptor joins the topic described by a pb.TopicDescriptor and returns a Topic handle.
+// Only one Topic handle should exist per topic, and JoinByTopicDescriptor will error if the Topic
+// handle already exists.
+func (p *PubSub) JoinByTopicDescriptor(td *pb.TopicDescriptor) (*Topic, error) {
+	t, ok, err := p.tryJoinByTopicDescriptor(td)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("topic already exists")
+	}
+
+	return t, nil
+}
+
+// tryJoin is an internal function that tries to join a topic.
+// Returns the topic handle and true if the topic was newly created,
+// or the existing handle and false if a handle already exists.
+func (p *PubSub) tryJoin(topic string) (*Topic, bool, error) {
+	td := pb.TopicDescriptor{Name: &topic}
+
+	return p.tryJoinByTopicDescriptor(&td)
+}
+
+// tryJoinByTopicDescriptor is like tryJoin, with a topic descriptor.
+// A descriptor with only a name matches any existing handle for the topic; otherwise
+// the descriptor must match the descriptor of an existing handle.
+func (p *PubSub) tryJoinByTopicDescriptor(td *pb.TopicDescriptor) (*Topic, bool, error) {
+	if p.subFilter != nil && !p.subFilter.CanSubscribe(td.GetName()) {
+		return nil, false, fmt.Errorf("topic %s is not allowed by the subscription filter", td.GetName())
+	}
+
+	auth, err := newTopicAuth(td)
+	if err != nil {
+		return nil, false, err
+	}
+
+	keys, err := newTopicKeyring(td)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// seed the topic seqnos with the seqno counter, so that they keep increasing when
+	// the topic is joined again
+	t := &Topic{
+		counter:     atomic.LoadUint64(&p.counter),
+		p:           p,
+		topic:       td.GetName(),
+		desc:        proto.Clone(td).(*pb.TopicDescriptor),
+		auth:        auth,
+		keys:        keys,
+		evtHandlers: make(map[*TopicEventHandler]struct{}),
+	}
+
+	resp := make(chan *Topic, 1)
+	select {
+	case p.addTopic <- &addTopicReq{topic: t, resp: resp}:
+	case <-p.ctx.Done():
+		return nil, false, p.closedErr()
+	}
+	returnedTopic := <-resp
+
+	if returnedTopic != t {
+		if !isPlainTopicDescriptor(td) && !proto.Equal(returnedTopic.desc, td) {
+			return nil, false, fmt.Errorf("topic %s already joined with a different descriptor", td.GetName())
+		}
+
+		return returnedTopic, false, nil
+	}
+
+	return t, true, nil
+}
+
+// isPlainTopicDescriptor returns whether a descriptor only names a topic, without
+// authentication or encryption options.
+func isPlainTopicDescriptor(td *pb.TopicDescriptor) bool {
+	return td.GetAuth().GetMode() == pb.TopicDescriptor_AuthOpts_NONE &&
+		td.GetEnc().GetMode() == pb.TopicDescriptor_EncOpts_NONE
+}
+
+// GetTopicDescriptor returns the descriptor of a topic we have joined.
+func (p *PubSub) GetTopicDescriptor(topic string) (*pb.TopicDescriptor, error) {
+	out := make(chan *Topic, 1)
+	select {
+	case p.eval <- func() {
+		out <- p.myTopics[topic]
+	}:
+	case <-p.ctx.Done():
+		return nil, p.closedErr()
+	}
+
+	t := <-out
+	if t == nil {
+		return nil, fmt.Errorf("topic %s has not been joined", topic)
+	}
+
+	return t.Descriptor(), nil
+}
+
 // Subscribe returns a new Subscription for the given topic.
 // Note that subscription is not an instanteneous operation. It may take some time
 // before the subscription is processed by the pubsub main loop and propagated to our peers.
+//
+// Subscribe implicitly joins the topic; use Join and Topic.Subscribe to hold on to
+// the topic handle.
 func (p *PubSub) Subscribe(topic string, opts ...SubOpt) (*Subscription, error) {
 	td := pb.TopicDescriptor{Name: &topic}
 
@@ -704,37 +1386,39 @@ func (p *PubSub) Subscribe(topic string, opts ...SubOpt) (*Subscription, error)
 
 // SubscribeByTopicDescriptor lets you subscribe a topic using a pb.TopicDescriptor.
 func (p *PubSub) SubscribeByTopicDescriptor(td *pb.TopicDescriptor, opts ...SubOpt) (*Subscription, error) {
-	if td.GetAuth().GetMode() != pb.TopicDescriptor_AuthOpts_NONE {
-		return nil, fmt.Errorf("auth mode not yet supported")
+	// ignore whether the topic was newly created or not, since either way we have a valid topic to work with
+	t, _, err := p.tryJoinByTopicDescriptor(td)
+	if err != nil {
+		return nil, err
 	}
 
-	if td.GetEnc().GetMode() != pb.TopicDescriptor_EncOpts_NONE {
-		return nil, fmt.Errorf("encryption mode not yet supported")
-	}
-
-	sub := &Subscription{
-		topic: td.GetName(),
-
-		ch:        make(chan *Message, 32),
-		peerEvtCh: make(chan PeerEvent, 1),
-		evtLog:    make(map[peer.ID]EventType),
-		evtLogCh:  make(chan struct{}, 1),
-	}
+	return t.Subscribe(opts...)
+}
 
-	for _, opt := range opts {
-		err := opt(sub)
-		if err != nil {
-			return nil, err
-		}
-	}
+type addRelayReq struct {
+	topic string
+	resp  chan struct{}
+}
 
-	out := make(chan *Subscription, 1)
-	p.addSub <- &addSubReq{
-		sub:  sub,
-		resp: out,
+// RelayCancelFunc cancels a relay; it is safe to call more than once.
+type RelayCancelFunc func()
+
+// Relay enables message relaying for a topic without subscribing to it: the node joins
+// the topic mesh, announces its interest in the topic, and validates and forwards messages,
+// but never delivers them to a Subscription. This allows infrastructure nodes to support
+// topics they have no interest in.
+// Relays are reference counted, and coexist with subscriptions for the topic; the node
+// leaves the topic once all relays are cancelled and all subscriptions are cancelled.
+//
+// Relay implicitly joins the topic; use Join and Topic.Relay to hold on to
+// the topic handle.
+func (p *PubSub) Relay(topic string) (RelayCancelFunc, error) {
+	t, _, err := p.tryJoin(topic)
+	if err != nil {
+		return nil, err
 	}
 
-	return <-out, nil
+	return t.Relay()
 }
 
 type topicReq struct {
@@ -742,37 +1426,28 @@ type topicReq struct {
 }
 
 // GetTopics returns the topics this node is subscribed to.
+// It returns nil once the PubSub instance has been closed.
 func (p *PubSub) GetTopics() []string {
 	out := make(chan []string, 1)
-	p.getTopics <- &topicReq{resp: out}
+	select {
+	case p.getTopics <- &topicReq{resp: out}:
+	case <-p.ctx.Done():
+		return nil
+	}
 	return <-out
 }
 
 // Publish publishes data to the given topic.
+//
+// Publish implicitly joins the topic; use Join and Topic.Publish to hold on to
+// the topic handle.
 func (p *PubSub) Publish(topic string, data []byte) error {
-	seqno := p.nextSeqno()
-	m := &pb.Message{
-		Data:     data,
-		TopicIDs: []string{topic},
-		From:     []byte(p.host.ID()),
-		Seqno:    seqno,
-	}
-	if p.signKey != nil {
-		m.From = []byte(p.signID)
-		err := signMessage(p.signID, p.signKey, m)
-		if err != nil {
-			return err
-		}
+	t, _, err := p.tryJoin(topic)
+	if err != nil {
+		return err
 	}
-	p.publish <- &Message{m}
-	return nil
-}
 
-func (p *PubSub) nextSeqno() []byte {
-	seqno := make([]byte, 8)
-	counter := atomic.AddUint64(&p.counter, 1)
-	binary.BigEndian.PutUint64(seqno, counter)
-	return seqno
+	return t.Publish(context.TODO(), data)
 }
 
 type listPeerReq struct {
@@ -788,25 +1463,131 @@ type sendReq struct {
 }
 
 // ListPeers returns a list of peers we are connected to in the given topic.
+// It returns nil once the PubSub instance has been closed.
 func (p *PubSub) ListPeers(topic string) []peer.ID {
 	out := make(chan []peer.ID)
-	p.getPeers <- &listPeerReq{
+	select {
+	case p.getPeers <- &listPeerReq{
 		resp:  out,
 		topic: topic,
+	}:
+	case <-p.ctx.Done():
+		return nil
 	}
 	return <-out
 }
 
 // BlacklistPeer blacklists a peer; all messages from this peer will be unconditionally dropped.
 func (p *PubSub) BlacklistPeer(pid peer.ID) {
-	p.blacklistPeer <- pid
+	select {
+	case p.blacklistPeer <- pid:
+	case <-p.ctx.Done():
+	}
+}
+
+// UnblacklistPeer removes a peer from the blacklist; the blacklist must implement
+// RemovableBlacklist. If the peer is still connected, it is re-admitted immediately;
+// otherwise it is admitted when it reconnects.
+func (p *PubSub) UnblacklistPeer(pid peer.ID) error {
+	rb, ok := p.blacklist.(RemovableBlacklist)
+	if !ok {
+		return fmt.Errorf("blacklist does not support removal")
+	}
+
+	done := make(chan struct{})
+	select {
+	case p.eval <- func() {
+		rb.Remove(pid)
+		p.readmitPeer(pid)
+		close(done)
+	}:
+	case <-p.ctx.Done():
+		return p.closedErr()
+	}
+
+	<-done
+	return nil
+}
+
+// scheduleReadmit re-admits a blacklisted peer when its blacklist entry expires, unless it
+// has been blacklisted again for longer.
+func (p *PubSub) scheduleReadmit(pid peer.ID, expiry time.Time) {
+	time.AfterFunc(time.Until(expiry), func() {
+		select {
+		case p.eval <- func() {
+			if !p.blacklist.Contains(pid) {
+				p.readmitPeer(pid)
+			}
+		}:
+		case <-p.ctx.Done():
+		}
+	})
+}
+
+// readmitPeer re-admits a connected peer that was previously blacklisted.
+// The peer's inbound streams are reset, so that it respawns its writer and re-announces
+// its subscriptions, which we dropped when the peer was blacklisted.
+// Only called from processLoop.
+func (p *PubSub) readmitPeer(pid peer.ID) {
+	if _, ok := p.peers[pid]; ok {
+		return
+	}
+
+	if p.host.Network().Connectedness(pid) != network.Connected {
+		return
+	}
+
+	protos := make(map[protocol.ID]struct{})
+	for _, id := range p.protocols() {
+		protos[id] = struct{}{}
+	}
+
+	for _, c := range p.host.Network().ConnsToPeer(pid) {
+		for _, s := range c.GetStreams() {
+			if _, ok := protos[s.Protocol()]; ok && s.Stat().Direction == network.DirInbound {
+				s.Reset()
+			}
+		}
+	}
+
+	go func() {
+		select {
+		case p.newPeers <- pid:
+		case <-p.ctx.Done():
+		}
+	}()
+}
+
+// ListBlacklisted returns the blacklisted peers; the blacklist must implement IterableBlacklist.
+func (p *PubSub) ListBlacklisted() ([]peer.ID, error) {
+	ib, ok := p.blacklist.(IterableBlacklist)
+	if !ok {
+		return nil, fmt.Errorf("blacklist does not support iteration")
+	}
+
+	out := make(chan []peer.ID, 1)
+	select {
+	case p.eval <- func() {
+		var peers []peer.ID
+		ib.Iterate(func(pid peer.ID) {
+			peers = append(peers, pid)
+		})
+		out <- peers
+	}:
+	case <-p.ctx.Done():
+		return nil, p.closedErr()
+	}
+
+	return <-out, nil
 }
 
 // RegisterTopicValidator registers a validator for topic.
+// The validator can be either a Validator, whose boolean result maps to ValidationAccept
+// or ValidationReject, or a ValidatorEx.
 // By default validators are asynchronous, which means they will run in a separate goroutine.
 // The number of active goroutines is controlled by global and per topic validator
 // throttles; if it exceeds the throttle threshold, messages will be dropped.
-func (p *PubSub) RegisterTopicValidator(topic string, val Validator, opts ...ValidatorOpt) error {
+func (p *PubSub) RegisterTopicValidator(topic string, val interface{}, opts ...ValidatorOpt) error {
 	addVal := &addValReq{
 		topic:    topic,
 		validate: val,
@@ -820,7 +1601,11 @@ func (p *PubSub) RegisterTopicValidator(topic string, val Validator, opts ...Val
 		}
 	}
 
-	p.addVal <- addVal
+	select {
+	case p.addVal <- addVal:
+	case <-p.ctx.Done():
+		return p.closedErr()
+	}
 	return <-addVal.resp
 }
 
@@ -832,6 +1617,10 @@ func (p *PubSub) UnregisterTopicValidator(topic string) error {
 		resp:  make(chan error, 1),
 	}
 
-	p.rmVal <- rmVal
+	select {
+	case p.rmVal <- rmVal:
+	case <-p.ctx.Done():
+		return p.closedErr()
+	}
 	return <-rmVal.resp
 }
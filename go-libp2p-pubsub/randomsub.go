@@ -2,8 +2,9 @@ package pubsub
 
 import (
 	"context"
+	"fmt"
 
-	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
 
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/peer"
@@ -15,22 +16,101 @@ const (
 )
 
 var (
+	// default number of peers to forward each message to; see RandomSubParams
 	RandomSubD = 6
 )
 
+// RandomSubParams contains the parameters of a randomsub router.
+type RandomSubParams struct {
+	// D is the number of randomsub peers each message is forwarded to.
+	D int
+
+	// Topics contains per topic overrides of D.
+	Topics map[string]*RandomSubTopicParams
+}
+
+// RandomSubTopicParams overrides the randomsub parameters for a single topic.
+type RandomSubTopicParams struct {
+	D int
+}
+
+// DefaultRandomSubParams returns the default randomsub parameters.
+func DefaultRandomSubParams() RandomSubParams {
+	return RandomSubParams{D: RandomSubD}
+}
+
+// WithRandomSubParams is a randomsub router option that sets the router parameters.
+// The parameters are copied, so later changes by the caller have no effect on the router.
+func WithRandomSubParams(params RandomSubParams) Option {
+	return func(ps *PubSub) error {
+		rs, ok := ps.rt.(*RandomSubRouter)
+		if !ok {
+			return fmt.Errorf("pubsub router is not randomsub")
+		}
+
+		err := params.validate()
+		if err != nil {
+			return err
+		}
+
+		rs.params = params.copy()
+
+		return nil
+	}
+}
+
+// RandomSubParamsOf returns a copy of the parameters of a randomsub instance.
+func RandomSubParamsOf(ps *PubSub) (RandomSubParams, error) {
+	rs, ok := ps.rt.(*RandomSubRouter)
+	if !ok {
+		return RandomSubParams{}, fmt.Errorf("pubsub router is not randomsub")
+	}
+
+	return rs.params.copy(), nil
+}
+
+func (p *RandomSubParams) validate() error {
+	if p.D < 1 {
+		return fmt.Errorf("invalid D; must be at least 1")
+	}
+
+	for topic, params := range p.Topics {
+		if params.D < 1 {
+			return fmt.Errorf("invalid randomsub parameters for topic %s: D must be at least 1", topic)
+		}
+	}
+
+	return nil
+}
+
+func (p *RandomSubParams) copy() RandomSubParams {
+	res := *p
+	if p.Topics != nil {
+		res.Topics = make(map[string]*RandomSubTopicParams, len(p.Topics))
+		for topic, params := range p.Topics {
+			tparams := *params
+			res.Topics[topic] = &tparams
+		}
+	}
+	return res
+}
+
 // NewRandomSub returns a new PubSub object using RandomSubRouter as the router.
 func NewRandomSub(ctx context.Context, h host.Host, opts ...Option) (*PubSub, error) {
 	rt := &RandomSubRouter{
-		peers: make(map[peer.ID]protocol.ID),
+		peers:  make(map[peer.ID]protocol.ID),
+		params: DefaultRandomSubParams(),
 	}
 	return NewPubSub(ctx, h, rt, opts...)
 }
 
 // RandomSubRouter is a router that implements a random propagation strategy.
-// For each message, it selects RandomSubD peers and forwards the message to them.
+// For each message, it selects D peers and forwards the message to them; when the
+// message is published in several topics, the largest D among them is used.
 type RandomSubRouter struct {
-	p     *PubSub
-	peers map[peer.ID]protocol.ID
+	p      *PubSub
+	peers  map[peer.ID]protocol.ID
+	params RandomSubParams
 }
 
 func (rs *RandomSubRouter) Protocols() []protocol.ID {
@@ -49,14 +129,28 @@ func (rs *RandomSubRouter) RemovePeer(p peer.ID) {
 	delete(rs.peers, p)
 }
 
+func (rs *RandomSubRouter) AcceptFrom(peer.ID) bool {
+	return true
+}
+
 func (rs *RandomSubRouter) HandleRPC(rpc *RPC) {}
 
 func (rs *RandomSubRouter) Publish(from peer.ID, msg *pb.Message) {
 	tosend := make(map[peer.ID]struct{})
 	rspeers := make(map[peer.ID]struct{})
 	src := peer.ID(msg.GetFrom())
+	d := 0
 
 	for _, topic := range msg.GetTopicIDs() {
+		td := rs.params.D
+		tparams, ok := rs.params.Topics[topic]
+		if ok {
+			td = tparams.D
+		}
+		if td > d {
+			d = td
+		}
+
 		tmap, ok := rs.p.topics[topic]
 		if !ok {
 			continue
@@ -75,10 +169,10 @@ func (rs *RandomSubRouter) Publish(from peer.ID, msg *pb.Message) {
 		}
 	}
 
-	if len(rspeers) > RandomSubD {
+	if len(rspeers) > d {
 		xpeers := peerMapToList(rspeers)
 		shufflePeers(xpeers)
-		xpeers = xpeers[:RandomSubD]
+		xpeers = xpeers[:d]
 		for _, p := range xpeers {
 			tosend[p] = struct{}{}
 		}
@@ -89,20 +183,17 @@ func (rs *RandomSubRouter) Publish(from peer.ID, msg *pb.Message) {
 	}
 
 	out := rpcWithMessages(msg)
+	class := rs.p.messageClass(from)
 	for p := range tosend {
-		mch, ok := rs.p.peers[p]
-		if !ok {
-			continue
-		}
-
-		select {
-		case mch <- out:
-		default:
-			log.Infof("dropping message to peer %s: queue full", p)
-		}
+		rs.p.sendRPC(p, out, class)
 	}
 }
 
 func (rs *RandomSubRouter) Join(topic string) {}
 
 func (rs *RandomSubRouter) Leave(topic string) {}
+
+func (rs *RandomSubRouter) inspect(s *Snapshot) {
+	s.Router = "randomsub"
+	s.setProtocols(rs.peers)
+}
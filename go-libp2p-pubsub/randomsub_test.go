@@ -0,0 +1,79 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+)
+
+func getRandomsubs(ctx context.Context, hs []host.Host, opts ...Option) []*PubSub {
+	var psubs []*PubSub
+	for _, h := range hs {
+		ps, err := NewRandomSub(ctx, h, opts...)
+		if err != nil {
+			panic(err)
+		}
+		psubs = append(psubs, ps)
+	}
+	return psubs
+}
+
+func TestRandomsubTopicParams(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hosts := getNetHosts(t, ctx, 10)
+
+	params := DefaultRandomSubParams()
+	params.D = 1
+	params.Topics = map[string]*RandomSubTopicParams{"hot": {D: 9}}
+	psubs := getRandomsubs(ctx, hosts, WithRandomSubParams(params))
+
+	cfg, err := RandomSubParamsOf(psubs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.D != 1 || cfg.Topics["hot"].D != 9 {
+		t.Fatal("unexpected router params")
+	}
+
+	var msgs []*Subscription
+	for _, ps := range psubs {
+		subch, err := ps.Subscribe("hot")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msgs = append(msgs, subch)
+	}
+
+	// everyone is connected to the publisher, which forwards to all 9 peers in the hot topic
+	for _, h := range hosts[1:] {
+		connect(t, hosts[0], h)
+	}
+
+	time.Sleep(time.Millisecond * 100)
+
+	for i := 0; i < 10; i++ {
+		msg := []byte(fmt.Sprintf("message %d", i))
+		psubs[0].Publish("hot", msg)
+
+		for _, sub := range msgs {
+			got, err := sub.Next(ctx)
+			if err != nil {
+				t.Fatal(sub.err)
+			}
+			if !bytes.Equal(msg, got.Data) {
+				t.Fatal("got wrong message!")
+			}
+		}
+	}
+
+	_, err = NewRandomSub(ctx, hosts[0], WithRandomSubParams(RandomSubParams{D: 0}))
+	if err == nil {
+		t.Fatal("expected invalid params to be rejected")
+	}
+}
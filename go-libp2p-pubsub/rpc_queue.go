@@ -0,0 +1,334 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// RPCPriority is the priority class of an outbound RPC. Each peer has an outbound queue with
+// a bounded sub-queue per class, and the writer always sends the RPCs of the highest priority
+// class first, so that control traffic is not starved by data under load.
+type RPCPriority int
+
+const (
+	// PriorityControl is the class of subscription announcements and router control
+	// messages, such as GRAFT and PRUNE.
+	PriorityControl RPCPriority = iota
+	// PriorityPublish is the class of the messages we publish.
+	PriorityPublish
+	// PriorityForward is the class of the messages we forward for other peers.
+	PriorityForward
+	// PriorityGossip is the class of gossip, such as IHAVE and IWANT, and of the messages
+	// sent in response to gossip.
+	PriorityGossip
+
+	numRPCPriorities = iota
+)
+
+func (c RPCPriority) String() string {
+	switch c {
+	case PriorityControl:
+		return "control"
+	case PriorityPublish:
+		return "publish"
+	case PriorityForward:
+		return "forward"
+	case PriorityGossip:
+		return "gossip"
+	default:
+		return fmt.Sprintf("RPCPriority(%d)", int(c))
+	}
+}
+
+// DropPolicy determines which RPC is dropped when queueing an RPC in a full priority class.
+type DropPolicy int
+
+const (
+	// DropNewest drops the RPC being queued.
+	DropNewest DropPolicy = iota
+	// DropOldest drops the oldest RPC in the class to make room for the RPC being queued.
+	DropOldest
+)
+
+// DefaultOutboundQueueSize is the default size of each priority class of the outbound queues.
+const DefaultOutboundQueueSize = 32
+
+// queueClassParams are the parameters of a priority class of the outbound queues
+type queueClassParams struct {
+	size   int
+	policy DropPolicy
+}
+
+func defaultQueueParams() [numRPCPriorities]queueClassParams {
+	var params [numRPCPriorities]queueClassParams
+	for i := range params {
+		params[i] = queueClassParams{size: DefaultOutboundQueueSize, policy: DropNewest}
+	}
+	// stale gossip is worth less than fresh gossip
+	params[PriorityGossip].policy = DropOldest
+	return params
+}
+
+// WithOutboundQueue is an option to set the size and drop policy of a priority class of the
+// outbound peer queues. By default, each class holds DefaultOutboundQueueSize RPCs, and
+// drops the newest RPC when full, except for gossip which drops the oldest RPC.
+func WithOutboundQueue(class RPCPriority, size int, policy DropPolicy) Option {
+	return func(p *PubSub) error {
+		if class < 0 || class >= numRPCPriorities {
+			return fmt.Errorf("invalid priority class %d", class)
+		}
+		if size <= 0 {
+			return fmt.Errorf("invalid queue size for class %s; must be positive", class)
+		}
+		if policy != DropNewest && policy != DropOldest {
+			return fmt.Errorf("invalid drop policy %d", policy)
+		}
+
+		p.queueParams[class] = queueClassParams{size: size, policy: policy}
+		return nil
+	}
+}
+
+// QueueStats are the statistics of the outbound queue of a peer, by priority class.
+type QueueStats struct {
+	// Queued is the number of RPCs waiting in the queue
+	Queued map[RPCPriority]int
+	// Dropped is the number of RPCs dropped because the queue was full
+	Dropped map[RPCPriority]uint64
+}
+
+// QueueStats returns the statistics of the outbound queues of our peers.
+func (p *PubSub) QueueStats() (map[peer.ID]QueueStats, error) {
+	out := make(chan map[peer.ID]QueueStats, 1)
+	select {
+	case p.eval <- func() {
+		stats := make(map[peer.ID]QueueStats, len(p.peers))
+		for pid, q := range p.peers {
+			stats[pid] = q.Stats()
+		}
+		out <- stats
+	}:
+	case <-p.ctx.Done():
+		return nil, p.closedErr()
+	}
+
+	return <-out, nil
+}
+
+// rpcQueue is the outbound queue of a peer, with a bounded FIFO sub-queue per priority class.
+// RPCs are pushed by the event loop and popped by the writer goroutine of the peer.
+type rpcQueue struct {
+	params *[numRPCPriorities]queueClassParams
+
+	mx      sync.Mutex
+	classes [numRPCPriorities][]*RPC
+	dropped [numRPCPriorities]uint64
+	closed  bool
+
+	// signal is notified when RPCs are pushed or the queue is closed
+	signal chan struct{}
+
+	// metrics receive the depth of the queue of the peer while it is open; nil for none
+	pid     peer.ID
+	metrics Metrics
+}
+
+func newRPCQueue(params *[numRPCPriorities]queueClassParams) *rpcQueue {
+	return &rpcQueue{
+		params: params,
+		signal: make(chan struct{}, 1),
+	}
+}
+
+// Push queues an RPC in a priority class. It returns the RPC dropped to respect the size of
+// the class, which is the RPC itself with the DropNewest policy, or nil if nothing was dropped.
+// RPCs pushed to a closed queue are dropped.
+func (q *rpcQueue) Push(rpc *RPC, class RPCPriority) *RPC {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	if q.closed {
+		return rpc
+	}
+
+	var dropped *RPC
+	params := q.params[class]
+	if len(q.classes[class]) >= params.size {
+		q.dropped[class]++
+
+		if params.policy == DropNewest {
+			return rpc
+		}
+
+		dropped = q.classes[class][0]
+		q.classes[class][0] = nil
+		q.classes[class] = q.classes[class][1:]
+	}
+
+	q.classes[class] = append(q.classes[class], rpc)
+	q.notify()
+	q.reportDepth()
+
+	return dropped
+}
+
+// Pop returns the next RPC, from the highest priority class with queued RPCs, waiting for an
+// RPC to be pushed if the queue is empty. It returns false when the queue has been closed and
+// drained, or when the context is done.
+func (q *rpcQueue) Pop(ctx context.Context) (*RPC, bool) {
+	for {
+		q.mx.Lock()
+		rpc := q.pop()
+		closed := q.closed
+		q.mx.Unlock()
+
+		if rpc != nil {
+			return rpc, true
+		}
+
+		if closed {
+			return nil, false
+		}
+
+		select {
+		case <-q.signal:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// TryPop returns the next RPC like Pop, without waiting if the queue is empty.
+func (q *rpcQueue) TryPop() (*RPC, bool) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	rpc := q.pop()
+	return rpc, rpc != nil
+}
+
+// pop removes the next RPC from the highest priority class with queued RPCs, returning
+// nil if the queue is empty. The lock must be held.
+func (q *rpcQueue) pop() *RPC {
+	for class := range q.classes {
+		if len(q.classes[class]) == 0 {
+			continue
+		}
+
+		rpc := q.classes[class][0]
+		q.classes[class][0] = nil
+		q.classes[class] = q.classes[class][1:]
+		q.reportDepth()
+		return rpc
+	}
+
+	return nil
+}
+
+// Close closes the queue; the writer drains the queued RPCs before exiting.
+func (q *rpcQueue) Close() {
+	q.mx.Lock()
+	if !q.closed && q.metrics != nil {
+		q.metrics.PeerRemoved(q.pid)
+	}
+	q.closed = true
+	q.notify()
+	q.mx.Unlock()
+}
+
+// Stats returns the statistics of the queue
+func (q *rpcQueue) Stats() QueueStats {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	stats := QueueStats{
+		Queued:  make(map[RPCPriority]int, numRPCPriorities),
+		Dropped: make(map[RPCPriority]uint64, numRPCPriorities),
+	}
+	for class := range q.classes {
+		stats.Queued[RPCPriority(class)] = len(q.classes[class])
+		stats.Dropped[RPCPriority(class)] = q.dropped[class]
+	}
+	return stats
+}
+
+// reportDepth reports the depth of an open queue to the metrics; the lock must be held.
+// The depth is reported under the lock, so that it can't be reported after the peer
+// has been removed by Close.
+func (q *rpcQueue) reportDepth() {
+	if q.metrics == nil || q.closed {
+		return
+	}
+
+	depth := 0
+	for _, rpcs := range q.classes {
+		depth += len(rpcs)
+	}
+	q.metrics.QueueDepth(q.pid, depth)
+}
+
+// notify signals the writer
+func (q *rpcQueue) notify() {
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+// newPeerQueue creates the outbound queue of a new peer, with the hello packet queued.
+// Only called from processLoop.
+func (p *PubSub) newPeerQueue(pid peer.ID) *rpcQueue {
+	q := newRPCQueue(&p.queueParams)
+	q.pid = pid
+	q.metrics = p.metrics
+	hello := p.getHelloPacket()
+	q.Push(hello, PriorityControl)
+	p.tracer.SendRPC(hello, pid)
+	return q
+}
+
+// sendRPC queues an RPC to a peer in a priority class.
+// It returns the RPC dropped from the queue to make room, which is the RPC itself if it
+// could not be queued, or nil. Dropped subscription announcements are retried; the caller
+// is responsible for retrying anything else. RPCs to unknown peers are ignored.
+// Only called from processLoop.
+func (p *PubSub) sendRPC(pid peer.ID, out *RPC, class RPCPriority) *RPC {
+	q, ok := p.peers[pid]
+	if !ok {
+		return nil
+	}
+
+	dropped := q.Push(out, class)
+	if dropped != out {
+		p.tracer.SendRPC(out, pid)
+		p.metrics.RPCSent(class)
+	}
+
+	if dropped == nil {
+		return nil
+	}
+
+	p.metrics.RPCDropped(class)
+
+	p.logger.Infow("dropping RPC: queue full", "peer", pid, "class", class)
+	p.tracer.DropRPC(dropped, pid)
+
+	for _, subopt := range dropped.GetSubscriptions() {
+		p.logger.Infow("can't send announce message: queue full; scheduling retry",
+			"peer", pid, "topic", subopt.GetTopicid())
+		go p.announceRetry(pid, subopt.GetTopicid(), subopt.GetSubscribe())
+	}
+
+	return dropped
+}
+
+// messageClass returns the priority class of a message forwarded by a router
+func (p *PubSub) messageClass(from peer.ID) RPCPriority {
+	if from == p.host.ID() {
+		return PriorityPublish
+	}
+	return PriorityForward
+}
@@ -0,0 +1,167 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRPCQueuePriority(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	params := defaultQueueParams()
+	q := newRPCQueue(&params)
+
+	gossip := new(RPC)
+	forward := new(RPC)
+	control := new(RPC)
+	publish := new(RPC)
+
+	q.Push(gossip, PriorityGossip)
+	q.Push(forward, PriorityForward)
+	q.Push(control, PriorityControl)
+	q.Push(publish, PriorityPublish)
+
+	for _, expected := range []*RPC{control, publish, forward, gossip} {
+		rpc, ok := q.Pop(ctx)
+		if !ok {
+			t.Fatal("expected an RPC")
+		}
+		if rpc != expected {
+			t.Fatal("RPCs popped out of priority order")
+		}
+	}
+
+	// pop waits for an RPC to be pushed
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		q.Push(control, PriorityControl)
+	}()
+
+	rpc, ok := q.Pop(ctx)
+	if !ok || rpc != control {
+		t.Fatal("expected the pushed RPC")
+	}
+
+	// a closed queue is drained before pop fails
+	q.Push(forward, PriorityForward)
+	q.Close()
+
+	if q.Push(gossip, PriorityGossip) != gossip {
+		t.Fatal("expected RPC pushed to a closed queue to be dropped")
+	}
+
+	rpc, ok = q.Pop(ctx)
+	if !ok || rpc != forward {
+		t.Fatal("expected the queued RPC")
+	}
+	_, ok = q.Pop(ctx)
+	if ok {
+		t.Fatal("expected pop to fail on a closed and drained queue")
+	}
+}
+
+func TestRPCQueueDropPolicy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	params := defaultQueueParams()
+	params[PriorityForward] = queueClassParams{size: 2, policy: DropNewest}
+	params[PriorityGossip] = queueClassParams{size: 2, policy: DropOldest}
+	q := newRPCQueue(&params)
+
+	rpcs := []*RPC{new(RPC), new(RPC), new(RPC)}
+
+	for i, rpc := range rpcs {
+		dropped := q.Push(rpc, PriorityForward)
+		if i < 2 && dropped != nil {
+			t.Fatal("unexpected drop")
+		}
+		if i == 2 && dropped != rpc {
+			t.Fatal("expected the newest RPC to be dropped")
+		}
+	}
+
+	for i, rpc := range rpcs {
+		dropped := q.Push(rpc, PriorityGossip)
+		if i < 2 && dropped != nil {
+			t.Fatal("unexpected drop")
+		}
+		if i == 2 && dropped != rpcs[0] {
+			t.Fatal("expected the oldest RPC to be dropped")
+		}
+	}
+
+	stats := q.Stats()
+	if stats.Queued[PriorityForward] != 2 || stats.Queued[PriorityGossip] != 2 || stats.Queued[PriorityControl] != 0 {
+		t.Fatalf("unexpected queue depths: %v", stats.Queued)
+	}
+	if stats.Dropped[PriorityForward] != 1 || stats.Dropped[PriorityGossip] != 1 || stats.Dropped[PriorityControl] != 0 {
+		t.Fatalf("unexpected drop counters: %v", stats.Dropped)
+	}
+
+	for _, expected := range []*RPC{rpcs[0], rpcs[1], rpcs[1], rpcs[2]} {
+		rpc, _ := q.Pop(ctx)
+		if rpc != expected {
+			t.Fatal("unexpected RPC")
+		}
+	}
+}
+
+func TestOutboundQueueOptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 1)
+
+	for _, opt := range []Option{
+		WithOutboundQueue(RPCPriority(-1), 1, DropNewest),
+		WithOutboundQueue(PriorityGossip+1, 1, DropNewest),
+		WithOutboundQueue(PriorityControl, 0, DropNewest),
+		WithOutboundQueue(PriorityControl, 1, DropPolicy(2)),
+	} {
+		_, err := NewFloodSub(ctx, hosts[0], opt)
+		if err == nil {
+			t.Fatal("expected an error for an invalid option")
+		}
+	}
+}
+
+func TestQueueStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 2)
+	psubs := getPubsubs(ctx, hosts, WithOutboundQueue(PriorityForward, 1, DropNewest))
+
+	connect(t, hosts[0], hosts[1])
+	time.Sleep(time.Millisecond * 100)
+
+	// queue more RPCs in one go than the writer can possibly send
+	pid := hosts[1].ID()
+	done := make(chan struct{})
+	psubs[0].eval <- func() {
+		for i := 0; i < 1000; i++ {
+			psubs[0].sendRPC(pid, rpcWithMessages(), PriorityForward)
+		}
+		close(done)
+	}
+	<-done
+
+	stats, err := psubs[0].QueueStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pstats, ok := stats[pid]
+	if !ok {
+		t.Fatal("expected queue stats for peer")
+	}
+	if pstats.Dropped[PriorityForward] == 0 {
+		t.Fatal("expected forwarded RPCs to be dropped")
+	}
+	if pstats.Dropped[PriorityControl] != 0 {
+		t.Fatal("unexpected control drops")
+	}
+}
@@ -0,0 +1,503 @@
+package pubsub
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func makeScoreTestMessage(topic string, seqno int, from peer.ID) *Message {
+	return &Message{
+		Message: &pb.Message{
+			From:     []byte(from),
+			Data:     []byte{byte(seqno)},
+			Seqno:    []byte{byte(seqno)},
+			TopicIDs: []string{topic},
+		},
+		ReceivedFrom: from,
+	}
+}
+
+func defaultTestScoreParams(topic string, tparams *TopicScoreParams) *PeerScoreParams {
+	return &PeerScoreParams{
+		AppSpecificScore: func(peer.ID) float64 { return 0 },
+		Topics:           map[string]*TopicScoreParams{topic: tparams},
+		DecayInterval:    time.Second,
+		DecayToZero:      0.01,
+	}
+}
+
+func TestScoreTimeInMesh(t *testing.T) {
+	// Create parameters with reasonable default values
+	mytopic := "mytopic"
+	params := defaultTestScoreParams(mytopic, &TopicScoreParams{
+		TopicWeight:                   0.5,
+		TimeInMeshWeight:              1,
+		TimeInMeshQuantum:             time.Millisecond,
+		TimeInMeshCap:                 3600,
+		InvalidMessageDeliveriesDecay: 0.1,
+	})
+
+	peerA := peer.ID("A")
+
+	ps := newPeerScore(params)
+	ps.AddPeer(peerA, "myproto")
+
+	// Peer score should start at 0
+	aScore := ps.Score(peerA)
+	if aScore != 0 {
+		t.Fatal("expected score to start at zero")
+	}
+
+	// The time in mesh depends on how long the peer has been grafted
+	ps.Graft(peerA, mytopic)
+	elapsed := 200 * time.Millisecond
+	time.Sleep(elapsed)
+
+	ps.refreshScores()
+	aScore = ps.Score(peerA)
+	expected := params.Topics[mytopic].TopicWeight * params.Topics[mytopic].TimeInMeshWeight * float64(elapsed/params.Topics[mytopic].TimeInMeshQuantum)
+	if aScore < expected {
+		t.Fatalf("Score: %f. Expected >= %f", aScore, expected)
+	}
+}
+
+func TestScoreFirstMessageDeliveries(t *testing.T) {
+	mytopic := "mytopic"
+	params := defaultTestScoreParams(mytopic, &TopicScoreParams{
+		TopicWeight:                   1,
+		FirstMessageDeliveriesWeight:  1,
+		FirstMessageDeliveriesDecay:   1.0, // test without decay for now
+		FirstMessageDeliveriesCap:     2000,
+		TimeInMeshQuantum:             time.Second,
+		InvalidMessageDeliveriesDecay: 0.1,
+	})
+
+	peerA := peer.ID("A")
+
+	ps := newPeerScore(params)
+	ps.AddPeer(peerA, "myproto")
+	ps.Graft(peerA, mytopic)
+
+	// deliver a bunch of messages from peer A
+	nMessages := 100
+	for i := 0; i < nMessages; i++ {
+		msg := makeScoreTestMessage(mytopic, i, peerA)
+		ps.ValidateMessage(msg)
+		ps.DeliverMessage(msg)
+	}
+
+	ps.refreshScores()
+	aScore := ps.Score(peerA)
+	expected := params.Topics[mytopic].TopicWeight * params.Topics[mytopic].FirstMessageDeliveriesWeight * float64(nMessages)
+	if aScore != expected {
+		t.Fatalf("Score: %f. Expected %f", aScore, expected)
+	}
+}
+
+func TestScoreMeshMessageDeliveries(t *testing.T) {
+	mytopic := "mytopic"
+	params := defaultTestScoreParams(mytopic, &TopicScoreParams{
+		TopicWeight:                     1,
+		MeshMessageDeliveriesWeight:     -1,
+		MeshMessageDeliveriesActivation: 0,
+		MeshMessageDeliveriesWindow:     10 * time.Millisecond,
+		MeshMessageDeliveriesThreshold:  20,
+		MeshMessageDeliveriesCap:        100,
+		MeshMessageDeliveriesDecay:      1.0, // no decay for this test
+		FirstMessageDeliveriesWeight:    0,
+		TimeInMeshQuantum:               time.Second,
+		InvalidMessageDeliveriesDecay:   0.1,
+	})
+
+	// peer A always delivers the message first.
+	// peer B delivers next (within the delivery window).
+	// peer C delivers outside the delivery window.
+	// we expect peers A and B to have a score of zero, since all other parameter weights are zero.
+	// Peer C should have a negative score.
+	peerA := peer.ID("A")
+	peerB := peer.ID("B")
+	peerC := peer.ID("C")
+	peers := []peer.ID{peerA, peerB, peerC}
+
+	ps := newPeerScore(params)
+	for _, p := range peers {
+		ps.AddPeer(p, "myproto")
+		ps.Graft(p, mytopic)
+	}
+
+	// assert that nobody has been penalized yet for not delivering messages before activation
+	for _, p := range peers {
+		score := ps.Score(p)
+		if score < 0 {
+			t.Fatalf("expected no mesh delivery penalty before activation, got score %f", score)
+		}
+	}
+
+	// activate the mesh delivery penalty
+	ps.refreshScores()
+
+	// deliver a bunch of messages from peers
+	nMessages := 100
+	for i := 0; i < nMessages; i++ {
+		msg := makeScoreTestMessage(mytopic, i, peerA)
+		ps.ValidateMessage(msg)
+		ps.DeliverMessage(msg)
+
+		msg.ReceivedFrom = peerB
+		ps.DuplicateMessage(msg)
+
+		// deliver duplicate from peerC after the window
+		time.Sleep(params.Topics[mytopic].MeshMessageDeliveriesWindow + (5 * time.Millisecond))
+		msg.ReceivedFrom = peerC
+		ps.DuplicateMessage(msg)
+	}
+
+	ps.refreshScores()
+	aScore := ps.Score(peerA)
+	bScore := ps.Score(peerB)
+	cScore := ps.Score(peerC)
+	if aScore < 0 {
+		t.Fatalf("Expected non-negative score for peer A, got %f", aScore)
+	}
+	if bScore < 0 {
+		t.Fatalf("Expected non-negative score for peer B, got %f", bScore)
+	}
+
+	// the penalty is the difference between the threshold and the actual mesh deliveries, squared.
+	// since we didn't deliver anything, this is just the value of the threshold
+	penalty := params.Topics[mytopic].MeshMessageDeliveriesThreshold * params.Topics[mytopic].MeshMessageDeliveriesThreshold
+	expected := params.Topics[mytopic].TopicWeight * params.Topics[mytopic].MeshMessageDeliveriesWeight * penalty
+	if cScore != expected {
+		t.Fatalf("Score: %f. Expected %f", cScore, expected)
+	}
+}
+
+func TestScoreMeshFailurePenalty(t *testing.T) {
+	mytopic := "mytopic"
+	params := defaultTestScoreParams(mytopic, &TopicScoreParams{
+		TopicWeight:                     1,
+		MeshMessageDeliveriesWeight:     0,
+		MeshMessageDeliveriesActivation: 0,
+		MeshMessageDeliveriesThreshold:  20,
+		MeshMessageDeliveriesCap:        100,
+		MeshMessageDeliveriesDecay:      1.0,
+		MeshFailurePenaltyWeight:        -1,
+		MeshFailurePenaltyDecay:         1.0,
+		TimeInMeshQuantum:               time.Second,
+		InvalidMessageDeliveriesDecay:   0.1,
+	})
+
+	peerA := peer.ID("A")
+
+	ps := newPeerScore(params)
+	ps.AddPeer(peerA, "myproto")
+	ps.Graft(peerA, mytopic)
+
+	// activate the mesh delivery penalty and prune the peer without any deliveries
+	ps.refreshScores()
+	ps.Prune(peerA, mytopic)
+
+	aScore := ps.Score(peerA)
+	threshold := params.Topics[mytopic].MeshMessageDeliveriesThreshold
+	expected := params.Topics[mytopic].TopicWeight * params.Topics[mytopic].MeshFailurePenaltyWeight * threshold * threshold
+	if aScore != expected {
+		t.Fatalf("Score: %f. Expected %f", aScore, expected)
+	}
+}
+
+func TestScoreInvalidMessageDeliveries(t *testing.T) {
+	mytopic := "mytopic"
+	params := defaultTestScoreParams(mytopic, &TopicScoreParams{
+		TopicWeight:                    1,
+		TimeInMeshQuantum:              time.Second,
+		InvalidMessageDeliveriesWeight: -1,
+		InvalidMessageDeliveriesDecay:  1.0,
+	})
+
+	peerA := peer.ID("A")
+	peerB := peer.ID("B")
+
+	ps := newPeerScore(params)
+	ps.AddPeer(peerA, "myproto")
+	ps.AddPeer(peerB, "myproto")
+
+	nMessages := 10
+	for i := 0; i < nMessages; i++ {
+		msg := makeScoreTestMessage(mytopic, i, peerA)
+		ps.ValidateMessage(msg)
+
+		// peer B forwards the same message while it is being validated
+		dup := makeScoreTestMessage(mytopic, i, peerA)
+		dup.ReceivedFrom = peerB
+		ps.DuplicateMessage(dup)
+
+		ps.RejectMessage(msg, rejectValidationFailed)
+	}
+
+	ps.refreshScores()
+	expected := params.Topics[mytopic].TopicWeight * params.Topics[mytopic].InvalidMessageDeliveriesWeight * float64(nMessages*nMessages)
+	for _, p := range []peer.ID{peerA, peerB} {
+		score := ps.Score(p)
+		if score != expected {
+			t.Fatalf("Score for %s: %f. Expected %f", p, score, expected)
+		}
+	}
+}
+
+func TestScoreRejectIgnoredMessage(t *testing.T) {
+	mytopic := "mytopic"
+	params := defaultTestScoreParams(mytopic, &TopicScoreParams{
+		TopicWeight:                    1,
+		TimeInMeshQuantum:              time.Second,
+		InvalidMessageDeliveriesWeight: -1,
+		InvalidMessageDeliveriesDecay:  1.0,
+	})
+
+	peerA := peer.ID("A")
+
+	ps := newPeerScore(params)
+	ps.AddPeer(peerA, "myproto")
+
+	for i, reason := range []string{rejectValidationIgnored, rejectValidationThrottled, rejectValidationQueueFull, rejectBlacklistedSource} {
+		msg := makeScoreTestMessage(mytopic, i, peerA)
+		ps.ValidateMessage(msg)
+		ps.RejectMessage(msg, reason)
+		ps.DuplicateMessage(msg)
+	}
+
+	ps.refreshScores()
+	aScore := ps.Score(peerA)
+	if aScore != 0 {
+		t.Fatalf("Score: %f. Expected 0", aScore)
+	}
+}
+
+func TestScoreApplicationScore(t *testing.T) {
+	mytopic := "mytopic"
+	appScoreValue := 0.0
+	params := defaultTestScoreParams(mytopic, &TopicScoreParams{
+		TopicWeight:                   1,
+		TimeInMeshQuantum:             time.Second,
+		InvalidMessageDeliveriesDecay: 0.1,
+	})
+	params.AppSpecificScore = func(peer.ID) float64 { return appScoreValue }
+	params.AppSpecificWeight = 0.5
+
+	peerA := peer.ID("A")
+
+	ps := newPeerScore(params)
+	ps.AddPeer(peerA, "myproto")
+	ps.Graft(peerA, mytopic)
+
+	for i := -100; i < 100; i++ {
+		appScoreValue = float64(i)
+		ps.refreshScores()
+		aScore := ps.Score(peerA)
+		expected := float64(i) * params.AppSpecificWeight
+		if aScore != expected {
+			t.Errorf("expected peer score to equal app-specific score %f, got %f", expected, aScore)
+		}
+	}
+}
+
+func TestScoreBehaviourPenalty(t *testing.T) {
+	params := defaultTestScoreParams("mytopic", &TopicScoreParams{
+		TopicWeight:                   1,
+		TimeInMeshQuantum:             time.Second,
+		InvalidMessageDeliveriesDecay: 0.1,
+	})
+	params.BehaviourPenaltyWeight = -1
+	params.BehaviourPenaltyDecay = 0.99
+
+	peerA := peer.ID("A")
+
+	ps := newPeerScore(params)
+
+	// penalties for unknown peers are ignored
+	ps.AddPenalty(peerA, 1)
+	aScore := ps.Score(peerA)
+	if aScore != 0 {
+		t.Errorf("expected no penalty for unknown peer, got score %f", aScore)
+	}
+
+	ps.AddPeer(peerA, "myproto")
+	ps.AddPenalty(peerA, 1)
+	aScore = ps.Score(peerA)
+	if aScore != -1 {
+		t.Errorf("expected score of -1, got %f", aScore)
+	}
+
+	// the penalty is quadratic
+	ps.AddPenalty(peerA, 1)
+	aScore = ps.Score(peerA)
+	if aScore != -4 {
+		t.Errorf("expected score of -4, got %f", aScore)
+	}
+
+	// and decays
+	ps.refreshScores()
+	aScore = ps.Score(peerA)
+	expected := -(2 * 0.99) * (2 * 0.99)
+	if aScore != expected {
+		t.Errorf("expected score of %f, got %f", expected, aScore)
+	}
+}
+
+func TestScoreIPColocation(t *testing.T) {
+	mytopic := "mytopic"
+	params := defaultTestScoreParams(mytopic, &TopicScoreParams{
+		TopicWeight:                   1,
+		TimeInMeshQuantum:             time.Second,
+		InvalidMessageDeliveriesDecay: 0.1,
+	})
+	params.IPColocationFactorThreshold = 1
+	params.IPColocationFactorWeight = -1
+
+	peerA := peer.ID("A")
+	peerB := peer.ID("B")
+	peerC := peer.ID("C")
+	peerD := peer.ID("D")
+	peers := []peer.ID{peerA, peerB, peerC, peerD}
+
+	ps := newPeerScore(params)
+	for _, p := range peers {
+		ps.AddPeer(p, "myproto")
+		ps.Graft(p, mytopic)
+	}
+
+	// peerA should have no penalty, but B, C, and D should be penalized for sharing an IP
+	setIPsForPeer(t, ps, peerA, "1.2.3.4")
+	setIPsForPeer(t, ps, peerB, "2.3.4.5")
+	setIPsForPeer(t, ps, peerC, "2.3.4.5", "3.4.5.6")
+	setIPsForPeer(t, ps, peerD, "2.3.4.5")
+
+	ps.refreshScores()
+	aScore := ps.Score(peerA)
+	if aScore != 0 {
+		t.Errorf("expected peer A to have score 0, got %f", aScore)
+	}
+
+	nShared := 3
+	ipSurplus := nShared - params.IPColocationFactorThreshold
+	penalty := ipSurplus * ipSurplus
+	expected := params.IPColocationFactorWeight * float64(penalty)
+	for _, p := range []peer.ID{peerB, peerC, peerD} {
+		score := ps.Score(p)
+		if score != expected {
+			t.Errorf("expected peer %s to have score %f, got %f", p, expected, score)
+		}
+	}
+
+	// whitelisted subnets are not penalized
+	_, ipnet, err := net.ParseCIDR("2.3.4.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	params.IPColocationFactorWhitelist = []*net.IPNet{ipnet}
+	for _, p := range peers {
+		score := ps.Score(p)
+		if score != 0 {
+			t.Errorf("expected whitelisted peer %s to have score 0, got %f", p, score)
+		}
+	}
+}
+
+func TestScoreRetention(t *testing.T) {
+	mytopic := "mytopic"
+	params := defaultTestScoreParams(mytopic, &TopicScoreParams{
+		TopicWeight:                   1,
+		TimeInMeshQuantum:             time.Second,
+		InvalidMessageDeliveriesDecay: 0.1,
+	})
+	params.AppSpecificScore = func(peer.ID) float64 { return -1000 }
+	params.AppSpecificWeight = 1
+	params.RetainScore = time.Second
+
+	peerA := peer.ID("A")
+
+	ps := newPeerScore(params)
+	ps.AddPeer(peerA, "myproto")
+	ps.Graft(peerA, mytopic)
+
+	// score should equal -1000 (app specific score)
+	expected := float64(-1000)
+	ps.refreshScores()
+	aScore := ps.Score(peerA)
+	if aScore != expected {
+		t.Fatalf("Score: %f. Expected %f", aScore, expected)
+	}
+
+	// disconnect & wait half of RetainScore time. should still have negative score
+	ps.RemovePeer(peerA)
+	time.Sleep(params.RetainScore / 2)
+	ps.refreshScores()
+	aScore = ps.Score(peerA)
+	if aScore != expected {
+		t.Fatalf("Score: %f. Expected %f", aScore, expected)
+	}
+
+	// wait remaining time (plus a little slop) and the score should reset to zero
+	time.Sleep(params.RetainScore/2 + 50*time.Millisecond)
+	ps.refreshScores()
+	aScore = ps.Score(peerA)
+	if aScore != 0 {
+		t.Fatalf("Score: %f. Expected 0.0", aScore)
+	}
+}
+
+func TestScoreParamsValidation(t *testing.T) {
+	valid := defaultTestScoreParams("mytopic", &TopicScoreParams{
+		TopicWeight:                   1,
+		TimeInMeshQuantum:             time.Second,
+		InvalidMessageDeliveriesDecay: 0.1,
+	})
+	if err := valid.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []*PeerScoreParams{
+		{AppSpecificScore: nil, DecayInterval: time.Second, DecayToZero: 0.01},
+		{AppSpecificScore: func(peer.ID) float64 { return 0 }, DecayInterval: time.Millisecond, DecayToZero: 0.01},
+		{AppSpecificScore: func(peer.ID) float64 { return 0 }, DecayInterval: time.Second, DecayToZero: 2},
+		{AppSpecificScore: func(peer.ID) float64 { return 0 }, DecayInterval: time.Second, DecayToZero: 0.01, IPColocationFactorWeight: 1},
+		{AppSpecificScore: func(peer.ID) float64 { return 0 }, DecayInterval: time.Second, DecayToZero: 0.01, TopicScoreCap: math.NaN()},
+		{AppSpecificScore: func(peer.ID) float64 { return 0 }, DecayInterval: time.Second, DecayToZero: 0.01, BehaviourPenaltyWeight: 1},
+		{AppSpecificScore: func(peer.ID) float64 { return 0 }, DecayInterval: time.Second, DecayToZero: 0.01, BehaviourPenaltyWeight: -1, BehaviourPenaltyDecay: 2},
+		defaultTestScoreParams("mytopic", &TopicScoreParams{TopicWeight: -1, TimeInMeshQuantum: time.Second, InvalidMessageDeliveriesDecay: 0.1}),
+		defaultTestScoreParams("mytopic", &TopicScoreParams{TopicWeight: 1, InvalidMessageDeliveriesDecay: 0.1}),
+		defaultTestScoreParams("mytopic", &TopicScoreParams{TopicWeight: 1, TimeInMeshQuantum: time.Second, InvalidMessageDeliveriesWeight: 1, InvalidMessageDeliveriesDecay: 0.1}),
+	}
+	for i, params := range invalid {
+		if err := params.validate(); err == nil {
+			t.Errorf("expected invalid score params %d to fail validation", i)
+		}
+	}
+
+	thresholds := []*PeerScoreThresholds{
+		{GossipThreshold: 1},
+		{GossipThreshold: -1, PublishThreshold: 0},
+		{GossipThreshold: -1, PublishThreshold: -2, GraylistThreshold: -1},
+		{GossipThreshold: -1, PublishThreshold: -2, GraylistThreshold: -3, AcceptPXThreshold: -1},
+	}
+	for i, th := range thresholds {
+		if err := th.validate(); err == nil {
+			t.Errorf("expected invalid thresholds %d to fail validation", i)
+		}
+	}
+}
+
+func setIPsForPeer(t *testing.T, ps *peerScore, p peer.ID, ips ...string) {
+	t.Helper()
+	ps.setIPs(p, ips, []string{})
+	pstats, ok := ps.peerStats[p]
+	if !ok {
+		t.Fatal("unable to get peerStats")
+	}
+	pstats.ips = ips
+}
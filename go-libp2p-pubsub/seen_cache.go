@@ -0,0 +1,363 @@
+package pubsub
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// SeenCache is the cache of the IDs of the messages we have seen, used to drop duplicates.
+// The cache is accessed from the event loop and the validation goroutines, so implementations
+// must be safe for concurrent use.
+type SeenCache interface {
+	// Has returns whether an ID is in the cache.
+	Has(id string) bool
+	// Add adds an ID to the cache, returning false if it was already there.
+	// The check and the addition are atomic.
+	Add(id string) bool
+}
+
+// SeenStrategy determines when the IDs in a seen cache expire.
+type SeenStrategy int
+
+const (
+	// FirstSeen expires IDs a TTL after they were added to the cache.
+	FirstSeen SeenStrategy = iota
+	// LastSeen expires IDs a TTL after they were last looked up or added, so that messages
+	// that keep being propagated stay in the cache.
+	LastSeen
+)
+
+// WithSeenMessagesTTL is an option to set the TTL of the IDs in the default seen cache,
+// which is TimeCacheDuration by default.
+func WithSeenMessagesTTL(ttl time.Duration) Option {
+	return func(p *PubSub) error {
+		if ttl <= 0 {
+			return errors.New("invalid seen messages TTL; must be positive")
+		}
+		p.seenTTL = ttl
+		return nil
+	}
+}
+
+// WithSeenMessagesStrategy is an option to set the expiry strategy of the default seen cache,
+// which is FirstSeen by default.
+func WithSeenMessagesStrategy(strategy SeenStrategy) Option {
+	return func(p *PubSub) error {
+		if strategy != FirstSeen && strategy != LastSeen {
+			return errors.New("invalid seen messages strategy")
+		}
+		p.seenStrategy = strategy
+		return nil
+	}
+}
+
+// WithSeenCache is an option to set the seen cache, overriding the default time-bucketed
+// cache and the options that configure it.
+func WithSeenCache(cache SeenCache) Option {
+	return func(p *PubSub) error {
+		if cache == nil {
+			return errors.New("seen cache must not be nil")
+		}
+		p.seenMessages = cache
+		return nil
+	}
+}
+
+// seenCacheBuckets is the number of buckets spanning the TTL of a time-bucketed cache;
+// IDs expire between the TTL and the TTL plus a bucket width after their last refresh.
+const seenCacheBuckets = 16
+
+// timeBucketCache is a seen cache where IDs are grouped in buckets by the time they were
+// added, or last seen with the LastSeen strategy. Expiry drops whole buckets as time passes,
+// so the cost of expiring an ID is constant, without sweeping the cache.
+type timeBucketCache struct {
+	mx sync.Mutex
+
+	strategy SeenStrategy
+	width    int64
+
+	// buckets is a ring of the IDs of the last buckets; the bucket of time t is at the
+	// index t/width modulo the number of buckets.
+	buckets []map[string]struct{}
+	// head is the number of the current bucket, t/width
+	head int64
+	// index maps the IDs to the number of their bucket
+	index map[string]int64
+
+	now func() time.Time
+}
+
+// NewTimeBucketCache returns a seen cache where IDs expire after a TTL, with O(1) expiry.
+func NewTimeBucketCache(ttl time.Duration, strategy SeenStrategy) SeenCache {
+	return newTimeBucketCache(ttl, strategy, time.Now)
+}
+
+func newTimeBucketCache(ttl time.Duration, strategy SeenStrategy, now func() time.Time) *timeBucketCache {
+	width := int64(ttl) / seenCacheBuckets
+	if width <= 0 {
+		width = 1
+	}
+
+	c := &timeBucketCache{
+		strategy: strategy,
+		width:    width,
+		buckets:  make([]map[string]struct{}, seenCacheBuckets+1),
+		index:    make(map[string]int64),
+		now:      now,
+	}
+	for i := range c.buckets {
+		c.buckets[i] = make(map[string]struct{})
+	}
+	c.head = c.now().UnixNano() / c.width
+
+	return c
+}
+
+func (c *timeBucketCache) Has(id string) bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.rotate()
+	b, ok := c.index[id]
+	if ok && c.strategy == LastSeen {
+		c.refresh(id, b)
+	}
+	return ok
+}
+
+func (c *timeBucketCache) Add(id string) bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.rotate()
+	b, ok := c.index[id]
+	if ok {
+		if c.strategy == LastSeen {
+			c.refresh(id, b)
+		}
+		return false
+	}
+
+	c.buckets[c.slot(c.head)][id] = struct{}{}
+	c.index[id] = c.head
+	return true
+}
+
+// Len returns the number of IDs in the cache
+func (c *timeBucketCache) Len() int {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.rotate()
+	return len(c.index)
+}
+
+// refresh moves an ID from its bucket to the current bucket; the lock must be held.
+func (c *timeBucketCache) refresh(id string, b int64) {
+	if b == c.head {
+		return
+	}
+
+	delete(c.buckets[c.slot(b)], id)
+	c.buckets[c.slot(c.head)][id] = struct{}{}
+	c.index[id] = c.head
+}
+
+// rotate drops the buckets that have expired since the last rotation; the lock must be held.
+func (c *timeBucketCache) rotate() {
+	head := c.now().UnixNano() / c.width
+	if head <= c.head {
+		return
+	}
+
+	// the buckets between the old and the new head are reused, at most once each
+	first := c.head + 1
+	if head-first >= int64(len(c.buckets)) {
+		first = head - int64(len(c.buckets)) + 1
+	}
+	for b := first; b <= head; b++ {
+		slot := c.slot(b)
+		if len(c.buckets[slot]) == 0 {
+			continue
+		}
+		for id := range c.buckets[slot] {
+			delete(c.index, id)
+		}
+		c.buckets[slot] = make(map[string]struct{})
+	}
+
+	c.head = head
+}
+
+func (c *timeBucketCache) slot(b int64) int {
+	return int(b % int64(len(c.buckets)))
+}
+
+// bloomCache is a seen cache with two generations of bloom filters, bounding its memory
+// regardless of the message rate. IDs are added to the current generation, which replaces
+// the previous one every TTL, so IDs expire between one and two TTLs after their last
+// refresh. False positives make us drop unseen messages as duplicates.
+// IDs are hashed with a random key per cache, so that peers can't craft IDs that collide.
+type bloomCache struct {
+	mx sync.Mutex
+
+	key [bloomKeySize]byte
+
+	strategy SeenStrategy
+	ttl      time.Duration
+
+	current, previous *bloomFilter
+	rotated           time.Time
+
+	now func() time.Time
+}
+
+// NewBloomSeenCache returns a memory-bounded seen cache, sized for capacity IDs per TTL with
+// a rate of false positives of fpRate.
+func NewBloomSeenCache(ttl time.Duration, capacity int, fpRate float64, strategy SeenStrategy) (SeenCache, error) {
+	if ttl <= 0 {
+		return nil, errors.New("invalid TTL; must be positive")
+	}
+	if capacity <= 0 {
+		return nil, errors.New("invalid capacity; must be positive")
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		return nil, errors.New("invalid false positive rate; must be between 0 and 1")
+	}
+
+	return newBloomCache(ttl, capacity, fpRate, strategy, time.Now)
+}
+
+func newBloomCache(ttl time.Duration, capacity int, fpRate float64, strategy SeenStrategy, now func() time.Time) (*bloomCache, error) {
+	c := &bloomCache{
+		strategy: strategy,
+		ttl:      ttl,
+		current:  newBloomFilter(capacity, fpRate),
+		previous: newBloomFilter(capacity, fpRate),
+		rotated:  now(),
+		now:      now,
+	}
+
+	_, err := rand.Read(c.key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *bloomCache) Has(id string) bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.rotate()
+	h1, h2 := c.hash(id)
+	if c.current.has(h1, h2) {
+		return true
+	}
+	if !c.previous.has(h1, h2) {
+		return false
+	}
+
+	if c.strategy == LastSeen {
+		c.current.add(h1, h2)
+	}
+	return true
+}
+
+func (c *bloomCache) Add(id string) bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.rotate()
+	h1, h2 := c.hash(id)
+	if c.current.has(h1, h2) {
+		return false
+	}
+
+	seen := c.previous.has(h1, h2)
+	if !seen || c.strategy == LastSeen {
+		c.current.add(h1, h2)
+	}
+	return !seen
+}
+
+// rotate replaces the previous generation with the current one every TTL; the lock must
+// be held.
+func (c *bloomCache) rotate() {
+	now := c.now()
+	elapsed := now.Sub(c.rotated)
+	if elapsed < c.ttl {
+		return
+	}
+
+	c.previous, c.current = c.current, c.previous
+	c.current.reset()
+	if elapsed >= 2*c.ttl {
+		c.previous.reset()
+	}
+	c.rotated = now
+}
+
+// bloomFilter is a bloom filter over pairs of hashes, combined by double hashing
+type bloomFilter struct {
+	bits []uint64
+	m    uint64
+	k    uint64
+}
+
+func newBloomFilter(capacity int, fpRate float64) *bloomFilter {
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Round(float64(m) / float64(capacity) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+
+	return &bloomFilter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+func (f *bloomFilter) add(h1, h2 uint64) {
+	for i := uint64(0); i < f.k; i++ {
+		b := (h1 + i*h2) % f.m
+		f.bits[b/64] |= 1 << (b % 64)
+	}
+}
+
+func (f *bloomFilter) has(h1, h2 uint64) bool {
+	for i := uint64(0); i < f.k; i++ {
+		b := (h1 + i*h2) % f.m
+		if f.bits[b/64]&(1<<(b%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) reset() {
+	for i := range f.bits {
+		f.bits[i] = 0
+	}
+}
+
+// bloomKeySize is the size of the keys of the bloom filter hashes
+const bloomKeySize = 16
+
+// hash returns two independent hashes of an ID, the halves of its keyed SHA-256 hash
+func (c *bloomCache) hash(id string) (uint64, uint64) {
+	h := sha256.New()
+	h.Write(c.key[:])
+	h.Write([]byte(id))
+
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:16]) | 1
+}
@@ -0,0 +1,219 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock advanced manually by tests
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func TestTimeBucketCache(t *testing.T) {
+	ttl := time.Second * 16
+	width := ttl / seenCacheBuckets
+
+	for _, strategy := range []SeenStrategy{FirstSeen, LastSeen} {
+		clock := &fakeClock{t: time.Unix(1000, 0)}
+		c := newTimeBucketCache(ttl, strategy, clock.now)
+
+		if !c.Add("a") {
+			t.Fatal("expected a new ID to be added")
+		}
+		if c.Add("a") {
+			t.Fatal("expected a duplicate ID not to be added")
+		}
+		if !c.Has("a") || c.Has("b") {
+			t.Fatal("unexpected membership")
+		}
+
+		// look up the ID just before it expires
+		clock.advance(ttl - width)
+		if !c.Has("a") {
+			t.Fatal("expected the ID to be in the cache before its TTL")
+		}
+
+		clock.advance(2 * width)
+		switch strategy {
+		case FirstSeen:
+			if c.Has("a") {
+				t.Fatal("expected the ID to expire a TTL after it was added")
+			}
+		case LastSeen:
+			if !c.Has("a") {
+				t.Fatal("expected the ID to be refreshed when looked up")
+			}
+		}
+
+		// all IDs expire after a long pause
+		c.Add("b")
+		clock.advance(ttl * 10)
+		if c.Has("a") || c.Has("b") || c.Len() != 0 {
+			t.Fatal("expected all IDs to expire")
+		}
+		if !c.Add("a") {
+			t.Fatal("expected an expired ID to be added again")
+		}
+	}
+}
+
+func TestBloomSeenCache(t *testing.T) {
+	ttl := time.Second * 10
+
+	for _, strategy := range []SeenStrategy{FirstSeen, LastSeen} {
+		clock := &fakeClock{t: time.Unix(1000, 0)}
+		c, err := newBloomCache(ttl, 1000, 0.001, strategy, clock.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !c.Add("a") {
+			t.Fatal("expected a new ID to be added")
+		}
+		if c.Add("a") {
+			t.Fatal("expected a duplicate ID not to be added")
+		}
+
+		// the ID survives a rotation in the previous generation
+		clock.advance(ttl)
+		if !c.Has("a") {
+			t.Fatal("expected the ID to be in the previous generation")
+		}
+
+		clock.advance(ttl)
+		switch strategy {
+		case FirstSeen:
+			if c.Has("a") {
+				t.Fatal("expected the ID to expire after two generations")
+			}
+		case LastSeen:
+			if !c.Has("a") {
+				t.Fatal("expected the ID to be refreshed when looked up")
+			}
+		}
+
+		clock.advance(ttl * 3)
+		if c.Has("a") {
+			t.Fatal("expected the ID to expire after a long pause")
+		}
+	}
+
+	// the false positive rate is within bounds
+	c, err := newBloomCache(ttl, 1000, 0.01, FirstSeen, time.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		c.Add(fmt.Sprintf("seen %d", i))
+	}
+
+	fp := 0
+	for i := 0; i < 10000; i++ {
+		if c.Has(fmt.Sprintf("unseen %d", i)) {
+			fp++
+		}
+	}
+	if fp > 300 {
+		t.Fatalf("too many false positives: %d/10000", fp)
+	}
+
+	// the hashes are keyed per cache
+	other, err := newBloomCache(ttl, 1000, 0.01, FirstSeen, time.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h1, h2 := c.hash("a")
+	o1, o2 := other.hash("a")
+	if h1 == o1 || h2 == o2 {
+		t.Fatal("expected different hashes in different caches")
+	}
+
+	for _, args := range []struct {
+		ttl      time.Duration
+		capacity int
+		fpRate   float64
+	}{
+		{0, 1, 0.1},
+		{ttl, 0, 0.1},
+		{ttl, 1, 0},
+		{ttl, 1, 1},
+	} {
+		_, err := NewBloomSeenCache(args.ttl, args.capacity, args.fpRate, FirstSeen)
+		if err == nil {
+			t.Fatal("expected an error for invalid parameters")
+		}
+	}
+}
+
+func TestSeenCacheOptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 3)
+
+	ps := getPubsub(ctx, hosts[0], WithSeenMessagesTTL(time.Second), WithSeenMessagesStrategy(LastSeen))
+	c, ok := ps.seenMessages.(*timeBucketCache)
+	if !ok {
+		t.Fatalf("unexpected default seen cache %T", ps.seenMessages)
+	}
+	if c.strategy != LastSeen || c.width != int64(time.Second)/seenCacheBuckets {
+		t.Fatal("expected the default seen cache to be configured by the options")
+	}
+
+	bloom, err := NewBloomSeenCache(time.Second, 100, 0.01, FirstSeen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps = getPubsub(ctx, hosts[1], WithSeenCache(bloom), WithSeenMessagesTTL(time.Minute))
+	if ps.seenMessages != bloom {
+		t.Fatal("expected the seen cache option to override the default cache")
+	}
+
+	for _, opt := range []Option{
+		WithSeenMessagesTTL(0),
+		WithSeenMessagesStrategy(SeenStrategy(2)),
+		WithSeenCache(nil),
+	} {
+		_, err := NewFloodSub(ctx, hosts[2], opt)
+		if err == nil {
+			t.Fatal("expected an error for an invalid option")
+		}
+	}
+}
+
+func BenchmarkSeenCache(b *testing.B) {
+	bloom, _ := NewBloomSeenCache(time.Second, 100000, 0.001, FirstSeen)
+	for _, bc := range []struct {
+		name  string
+		cache SeenCache
+	}{
+		{"bucket", NewTimeBucketCache(time.Second, FirstSeen)},
+		{"bloom", bloom},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			ids := make([]string, 1024)
+			for i := range ids {
+				ids[i] = fmt.Sprintf("message %d", i)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				id := ids[i%len(ids)]
+				if !bc.cache.Has(id) {
+					bc.cache.Add(id)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package pubsub
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// SeqnoStore persists the seqno counter of a publisher, so that its seqnos keep increasing
+// across restarts.
+type SeqnoStore interface {
+	// Load returns the persisted counter, or false if there is none.
+	Load() (uint64, bool, error)
+	// Store persists the counter.
+	Store(counter uint64) error
+}
+
+// seqnoReservation is the number of seqnos reserved by each write to a seqno store. Seqnos
+// are only persisted once per reservation, and on Close; a crash skips the rest of the
+// current reservation.
+const seqnoReservation = 1024
+
+// WithSeqnoStore is an option to persist the seqno counter in a store. The counter resumes
+// from the store instead of being seeded from the clock, and publishing fails if the counter
+// can't be persisted.
+func WithSeqnoStore(store SeqnoStore) Option {
+	return func(p *PubSub) error {
+		if store == nil {
+			return errors.New("seqno store must not be nil")
+		}
+
+		counter, ok, err := store.Load()
+		if err != nil {
+			return fmt.Errorf("error loading the seqno counter: %s", err)
+		}
+		if ok {
+			p.counter = counter
+		}
+		p.seqnoStore = store
+		p.seqnoLimit = p.counter
+		return nil
+	}
+}
+
+// FileSeqnoStore is a seqno store persisting the counter in a file. The file is replaced
+// atomically on each write.
+type FileSeqnoStore struct {
+	path string
+}
+
+// NewFileSeqnoStore returns a seqno store persisting the counter in the file at path.
+func NewFileSeqnoStore(path string) *FileSeqnoStore {
+	return &FileSeqnoStore{path: path}
+}
+
+func (s *FileSeqnoStore) Load() (uint64, bool, error) {
+	b, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	counter, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("malformed seqno file %s: %s", s.path, err)
+	}
+	return counter, true, nil
+}
+
+func (s *FileSeqnoStore) Store(counter uint64) error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.WriteString(strconv.FormatUint(counter, 10) + "\n")
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+func (p *PubSub) nextSeqno() ([]byte, error) {
+	seqno := make([]byte, 8)
+	counter := atomic.AddUint64(&p.counter, 1)
+	if p.seqnoStore != nil {
+		err := p.reserveSeqno(counter)
+		if err != nil {
+			return nil, err
+		}
+	}
+	binary.BigEndian.PutUint64(seqno, counter)
+	return seqno, nil
+}
+
+// reserveSeqno persists a new reservation of seqnos in the store if a seqno is beyond the
+// current one.
+func (p *PubSub) reserveSeqno(counter uint64) error {
+	if counter <= atomic.LoadUint64(&p.seqnoLimit) {
+		return nil
+	}
+
+	p.seqnoMx.Lock()
+	defer p.seqnoMx.Unlock()
+
+	if counter <= p.seqnoLimit {
+		return nil
+	}
+
+	limit := counter + seqnoReservation - 1
+	err := p.seqnoStore.Store(limit)
+	if err != nil {
+		return fmt.Errorf("error persisting the seqno counter: %s", err)
+	}
+	atomic.StoreUint64(&p.seqnoLimit, limit)
+	return nil
+}
+
+// storeSeqno persists the current counter, releasing the rest of the current reservation.
+func (p *PubSub) storeSeqno() error {
+	p.seqnoMx.Lock()
+	defer p.seqnoMx.Unlock()
+
+	counter := atomic.LoadUint64(&p.counter)
+	if counter == p.seqnoLimit {
+		return nil
+	}
+
+	err := p.seqnoStore.Store(counter)
+	if err != nil {
+		return fmt.Errorf("error persisting the seqno counter: %s", err)
+	}
+	atomic.StoreUint64(&p.seqnoLimit, counter)
+	return nil
+}
+
+// GapEvent reports a range of seqnos of a publisher that were not received.
+type GapEvent struct {
+	From        peer.ID
+	First, Last uint64
+}
+
+// GapEventHandler receives the gap events of a gap tracker
+type GapEventHandler func(GapEvent)
+
+// GapTracker records the highest seqno received from each publisher and reports the seqnos
+// skipped since as gaps. The seqnos of a publisher are shared by all the topics it publishes
+// to, so messages published to topics we are not subscribed to are reported as gaps too.
+// Messages validated concurrently can be delivered out of order, so a gap may be followed
+// by the messages it reports; such late messages are ignored.
+type GapTracker struct {
+	mx      sync.Mutex
+	highest map[peer.ID]uint64
+	handler GapEventHandler
+}
+
+// NewGapTracker returns a gap tracker reporting gaps to a handler
+func NewGapTracker(handler GapEventHandler) *GapTracker {
+	return &GapTracker{
+		highest: make(map[peer.ID]uint64),
+		handler: handler,
+	}
+}
+
+// Track records a message, returning false if its seqno is not beyond the highest seqno
+// received from its publisher. The first message of a publisher sets its highest seqno,
+// without reporting a gap.
+func (t *GapTracker) Track(msg *Message) bool {
+	seqno, ok := msgSeqno(msg)
+	if !ok {
+		return false
+	}
+
+	from := msg.GetFrom()
+
+	t.mx.Lock()
+	highest, ok := t.highest[from]
+	if ok && seqno <= highest {
+		t.mx.Unlock()
+		return false
+	}
+	t.highest[from] = seqno
+	t.mx.Unlock()
+
+	if ok && seqno > highest+1 && t.handler != nil {
+		t.handler(GapEvent{From: from, First: highest + 1, Last: seqno - 1})
+	}
+	return true
+}
+
+// Highest returns the highest seqno received from a publisher, or false if none was received.
+func (t *GapTracker) Highest(pid peer.ID) (uint64, bool) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	seqno, ok := t.highest[pid]
+	return seqno, ok
+}
+
+// Forget drops the seqno of a publisher, e.g. when it is known to have restarted without
+// a seqno store.
+func (t *GapTracker) Forget(pid peer.ID) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	delete(t.highest, pid)
+}
+
+// WithGapTracker is an option to track the seqnos of the messages we deliver and forward,
+// from other publishers. The handler of the tracker is called from the event loop, so it
+// must not block.
+func WithGapTracker(t *GapTracker) Option {
+	return func(p *PubSub) error {
+		if t == nil {
+			return errors.New("gap tracker must not be nil")
+		}
+		p.gapTracker = t
+		return nil
+	}
+}
+
+// trackGaps records the seqnos of a delivered message, and of its fragments if it was
+// chunked.
+// Only called from processLoop.
+func (p *PubSub) trackGaps(msg *Message) {
+	if p.gapTracker == nil || msg.GetFrom() == p.host.ID() || msg.GetFrom() == p.signID {
+		return
+	}
+
+	p.gapTracker.Track(msg)
+	for _, frag := range msg.fragments {
+		p.gapTracker.Track(frag)
+	}
+}
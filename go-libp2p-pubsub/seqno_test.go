@@ -0,0 +1,220 @@
+package pubsub
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestFileSeqnoStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pubsub-seqno")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewFileSeqnoStore(filepath.Join(dir, "seqno"))
+	_, ok, err := store.Load()
+	if err != nil || ok {
+		t.Fatalf("expected no counter, got %t, %v", ok, err)
+	}
+
+	err = store.Store(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter, ok, err := store.Load()
+	if err != nil || !ok || counter != 42 {
+		t.Fatalf("expected counter 42, got %d, %t, %v", counter, ok, err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "seqno"), []byte("garbage"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = store.Load()
+	if err == nil {
+		t.Fatal("expected an error loading a malformed file")
+	}
+}
+
+func TestSeqnoStore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "pubsub-seqno")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "seqno")
+	err = ioutil.WriteFile(path, []byte("100\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hosts := getNetHosts(t, ctx, 2)
+
+	// publish reads the seqnos of our own messages from a subscription
+	publish := func(ps *PubSub) uint64 {
+		sub, err := ps.Subscribe("foobar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Cancel()
+
+		err = ps.Publish("foobar", []byte("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := sub.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqno, _ := msgSeqno(msg)
+		return seqno
+	}
+
+	ps := getPubsub(ctx, hosts[0], WithSeqnoStore(NewFileSeqnoStore(path)))
+	if seqno := publish(ps); seqno != 101 {
+		t.Fatalf("expected seqno 101, got %d", seqno)
+	}
+
+	// a reservation of seqnos is persisted ahead of the counter
+	counter, _, _ := NewFileSeqnoStore(path).Load()
+	if counter != 101+seqnoReservation-1 {
+		t.Fatalf("unexpected persisted counter %d", counter)
+	}
+
+	// closing persists the counter, so a restarted publisher resumes from it
+	err = ps.Close(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter, _, _ = NewFileSeqnoStore(path).Load()
+	if counter != 101 {
+		t.Fatalf("expected persisted counter 101, got %d", counter)
+	}
+
+	ps = getPubsub(ctx, hosts[1], WithSeqnoStore(NewFileSeqnoStore(path)))
+	if seqno := publish(ps); seqno != 102 {
+		t.Fatalf("expected seqno 102, got %d", seqno)
+	}
+
+	_, err = NewPubSub(ctx, hosts[1], &FloodSubRouter{}, WithSeqnoStore(NewFileSeqnoStore(dir)))
+	if err == nil {
+		t.Fatal("expected an error loading the counter from a directory")
+	}
+}
+
+func TestGapTracker(t *testing.T) {
+	var events []GapEvent
+	tracker := NewGapTracker(func(evt GapEvent) { events = append(events, evt) })
+
+	a, b := peer.ID("a"), peer.ID("b")
+	for _, msg := range []*Message{
+		makeSeqnoMessage(a, 10),
+		makeSeqnoMessage(a, 11),
+		makeSeqnoMessage(b, 3),
+		makeSeqnoMessage(a, 15),
+		makeSeqnoMessage(b, 4),
+	} {
+		if !tracker.Track(msg) {
+			t.Fatal("expected the message to be tracked")
+		}
+	}
+
+	if len(events) != 1 || events[0].From != a || events[0].First != 12 || events[0].Last != 14 {
+		t.Fatalf("unexpected gap events: %+v", events)
+	}
+
+	// late messages don't move the highest seqno
+	if tracker.Track(makeSeqnoMessage(a, 13)) {
+		t.Fatal("expected a late message not to be tracked")
+	}
+	if seqno, ok := tracker.Highest(a); !ok || seqno != 15 {
+		t.Fatalf("expected highest seqno 15, got %d", seqno)
+	}
+
+	tracker.Forget(a)
+	if _, ok := tracker.Highest(a); ok {
+		t.Fatal("expected the publisher to be forgotten")
+	}
+	if !tracker.Track(makeSeqnoMessage(a, 1)) || len(events) != 1 {
+		t.Fatal("expected a forgotten publisher to start over without a gap")
+	}
+}
+
+func TestGapTrackerDelivery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 2)
+
+	var mx sync.Mutex
+	var events []GapEvent
+	tracker := NewGapTracker(func(evt GapEvent) {
+		mx.Lock()
+		events = append(events, evt)
+		mx.Unlock()
+	})
+
+	psubs := []*PubSub{
+		getPubsub(ctx, hosts[0]),
+		getPubsub(ctx, hosts[1], WithGapTracker(tracker)),
+	}
+
+	sub, err := psubs[1].Subscribe("foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	connect(t, hosts[0], hosts[1])
+	time.Sleep(time.Millisecond * 100)
+
+	// the message published to a topic we are not subscribed to is a gap
+	for _, topic := range []string{"foobar", "other", "foobar"} {
+		err := psubs[0].Publish(topic, []byte("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+
+	var last uint64
+	for i := 0; i < 2; i++ {
+		msg, err := sub.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last, _ = msgSeqno(msg)
+	}
+
+	mx.Lock()
+	defer mx.Unlock()
+	if len(events) != 1 || events[0].From != hosts[0].ID() || events[0].First != last-1 || events[0].Last != last-1 {
+		t.Fatalf("unexpected gap events: %+v", events)
+	}
+	if seqno, _ := tracker.Highest(hosts[0].ID()); seqno != last {
+		t.Fatalf("expected highest seqno %d, got %d", last, seqno)
+	}
+
+	// our own messages are not tracked
+	err = psubs[1].Publish("foobar", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sub.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tracker.Highest(hosts[1].ID()); ok {
+		t.Fatal("expected our own messages not to be tracked")
+	}
+}
@@ -3,7 +3,7 @@ package pubsub
 import (
 	"fmt"
 
-	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
 
 	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/peer"
@@ -101,3 +101,135 @@ func signMessage(pid peer.ID, key crypto.PrivKey, m *pb.Message) error {
 func withSignPrefix(bytes []byte) []byte {
 	return append([]byte(SignPrefix), bytes...)
 }
+
+// PeerRecordSignPrefix is the prefix for the signature of peer records exchanged in PRUNE
+const PeerRecordSignPrefix = "libp2p-pubsub-peer-record:"
+
+func signPeerRecord(pid peer.ID, key crypto.PrivKey, rec *pb.PeerRecord) error {
+	rec.Signer = []byte(pid)
+	bytes, err := rec.Marshal()
+	if err != nil {
+		return err
+	}
+
+	bytes = append([]byte(PeerRecordSignPrefix), bytes...)
+
+	sig, err := key.Sign(bytes)
+	if err != nil {
+		return err
+	}
+
+	rec.Signature = sig
+
+	pk, _ := pid.ExtractPublicKey()
+	if pk == nil {
+		pubk, err := key.GetPublic().Bytes()
+		if err != nil {
+			return err
+		}
+		rec.Key = pubk
+	}
+
+	return nil
+}
+
+// verifyPeerRecord checks the signature of a peer record and returns the signer
+func verifyPeerRecord(rec *pb.PeerRecord) (peer.ID, error) {
+	signer, err := peer.IDFromBytes(rec.Signer)
+	if err != nil {
+		return "", err
+	}
+
+	var pubk crypto.PubKey
+	if rec.Key == nil {
+		pubk, err = signer.ExtractPublicKey()
+		if err != nil || pubk == nil {
+			return "", fmt.Errorf("cannot extract signing key for %s", signer)
+		}
+	} else {
+		pubk, err = crypto.UnmarshalPublicKey(rec.Key)
+		if err != nil {
+			return "", fmt.Errorf("cannot unmarshal signing key: %s", err.Error())
+		}
+
+		if !signer.MatchesPublicKey(pubk) {
+			return "", fmt.Errorf("bad signing key; signer ID %s doesn't match key", signer)
+		}
+	}
+
+	xrec := *rec
+	xrec.Signature = nil
+	xrec.Key = nil
+	bytes, err := xrec.Marshal()
+	if err != nil {
+		return "", err
+	}
+
+	bytes = append([]byte(PeerRecordSignPrefix), bytes...)
+
+	valid, err := pubk.Verify(bytes, rec.Signature)
+	if err != nil {
+		return "", err
+	}
+
+	if !valid {
+		return "", fmt.Errorf("invalid signature")
+	}
+
+	return signer, nil
+}
+
+// DelegationCertSignPrefix is the prefix for the signature of web of trust certificates
+const DelegationCertSignPrefix = "libp2p-pubsub-cert:"
+
+func signDelegationCert(key crypto.PrivKey, cert *pb.DelegationCert) error {
+	issuer, err := crypto.MarshalPublicKey(key.GetPublic())
+	if err != nil {
+		return err
+	}
+
+	cert.Issuer = issuer
+	cert.Signature = nil
+	bytes, err := cert.Marshal()
+	if err != nil {
+		return err
+	}
+
+	bytes = append([]byte(DelegationCertSignPrefix), bytes...)
+
+	sig, err := key.Sign(bytes)
+	if err != nil {
+		return err
+	}
+
+	cert.Signature = sig
+	return nil
+}
+
+// verifyDelegationCert checks the signature of a certificate and returns the issuer
+func verifyDelegationCert(cert *pb.DelegationCert) (peer.ID, error) {
+	pubk, err := crypto.UnmarshalPublicKey(cert.Issuer)
+	if err != nil {
+		return "", fmt.Errorf("cannot unmarshal issuer key: %s", err.Error())
+	}
+
+	xcert := *cert
+	xcert.Signature = nil
+	bytes, err := xcert.Marshal()
+	if err != nil {
+		return "", err
+	}
+
+	bytes = append([]byte(DelegationCertSignPrefix), bytes...)
+
+	valid, err := pubk.Verify(bytes, cert.Signature)
+	if err != nil {
+		return "", err
+	}
+
+	if !valid {
+		return "", fmt.Errorf("invalid signature")
+	}
+
+	return peer.IDFromPublicKey(pubk)
+}
@@ -17,12 +17,17 @@ type Subscription struct {
 	topic    string
 	ch       chan *Message
 	cancelCh chan<- *Subscription
+	ctx      context.Context
 	err      error
 
 	peerEvtCh chan PeerEvent
 	evtLogMx  sync.Mutex
 	evtLog    map[peer.ID]EventType
 	evtLogCh  chan struct{}
+
+	// order reorders the messages from ch to out for ordered subscriptions; nil otherwise
+	order *orderer
+	out   chan *Message
 }
 
 type PeerEvent struct {
@@ -36,8 +41,13 @@ func (sub *Subscription) Topic() string {
 
 // Next returns the next message in our subscription
 func (sub *Subscription) Next(ctx context.Context) (*Message, error) {
+	ch := sub.ch
+	if sub.order != nil {
+		ch = sub.out
+	}
+
 	select {
-	case msg, ok := <-sub.ch:
+	case msg, ok := <-ch:
 		if !ok {
 			return msg, sub.err
 		}
@@ -49,7 +59,10 @@ func (sub *Subscription) Next(ctx context.Context) (*Message, error) {
 }
 
 func (sub *Subscription) Cancel() {
-	sub.cancelCh <- sub
+	select {
+	case sub.cancelCh <- sub:
+	case <-sub.ctx.Done():
+	}
 }
 
 func (sub *Subscription) close() {
@@ -0,0 +1,213 @@
+package pubsub
+
+import (
+	"errors"
+	"regexp"
+
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ErrTooManySubscriptions is returned by subscription filters and limits when a peer
+// announces too many subscriptions.
+var ErrTooManySubscriptions = errors.New("too many subscriptions")
+
+// SubscriptionFilter restricts the topics we subscribe to and the subscription announcements
+// we track from remote peers, including the ones in the hello packet.
+type SubscriptionFilter interface {
+	// CanSubscribe returns whether a topic is of interest and we can subscribe to it.
+	CanSubscribe(topic string) bool
+
+	// FilterIncomingSubscriptions is invoked for all RPCs containing subscription announcements.
+	// It returns the announcements for the topics of interest, or an error if the RPC violates
+	// the filter (for instance because it has too many subscriptions), in which case the whole
+	// RPC is dropped.
+	FilterIncomingSubscriptions(peer.ID, []*pb.RPC_SubOpts) ([]*pb.RPC_SubOpts, error)
+}
+
+// SubscriptionPenaltyHook is a callback invoked when a peer sends an RPC that violates the
+// subscription filter or limits; the RPC is dropped.
+// The hook is invoked from the event loop and should not block.
+type SubscriptionPenaltyHook func(peer.ID, error)
+
+// WithSubscriptionFilter is an option to set a subscription filter.
+func WithSubscriptionFilter(filter SubscriptionFilter) Option {
+	return func(p *PubSub) error {
+		p.subFilter = filter
+		return nil
+	}
+}
+
+// WithPeerSubscriptionLimit is an option to limit the number of topic subscriptions
+// we track for each peer; RPCs from peers announcing more subscriptions are dropped.
+func WithPeerSubscriptionLimit(limit int) Option {
+	return func(p *PubSub) error {
+		if limit <= 0 {
+			return errors.New("invalid subscription limit; must be positive")
+		}
+		p.maxPeerSubs = limit
+		return nil
+	}
+}
+
+// WithSubscriptionPenaltyHook sets a callback that is invoked whenever a peer sends an
+// RPC that violates the subscription filter or limits.
+func WithSubscriptionPenaltyHook(hook SubscriptionPenaltyHook) Option {
+	return func(p *PubSub) error {
+		p.subPenaltyHook = hook
+		return nil
+	}
+}
+
+// NewAllowlistSubscriptionFilter creates a subscription filter that only allows
+// the given topics.
+func NewAllowlistSubscriptionFilter(topics ...string) SubscriptionFilter {
+	allow := make(map[string]struct{}, len(topics))
+	for _, topic := range topics {
+		allow[topic] = struct{}{}
+	}
+
+	return NewPredicateSubscriptionFilter(func(topic string) bool {
+		_, ok := allow[topic]
+		return ok
+	})
+}
+
+// NewRegexpSubscriptionFilter creates a subscription filter that only allows topics
+// matching a regular expression.
+// Note that the regular expression is not anchored; use ^ and $ to match whole topic names.
+func NewRegexpSubscriptionFilter(rx *regexp.Regexp) SubscriptionFilter {
+	return NewPredicateSubscriptionFilter(rx.MatchString)
+}
+
+// NewPredicateSubscriptionFilter creates a subscription filter that only allows topics
+// for which a predicate returns true.
+func NewPredicateSubscriptionFilter(allow func(topic string) bool) SubscriptionFilter {
+	return &predicateSubscriptionFilter{allow: allow}
+}
+
+type predicateSubscriptionFilter struct {
+	allow func(string) bool
+}
+
+var _ SubscriptionFilter = (*predicateSubscriptionFilter)(nil)
+
+func (f *predicateSubscriptionFilter) CanSubscribe(topic string) bool {
+	return f.allow(topic)
+}
+
+func (f *predicateSubscriptionFilter) FilterIncomingSubscriptions(from peer.ID, subs []*pb.RPC_SubOpts) ([]*pb.RPC_SubOpts, error) {
+	return filterSubscriptions(subs, f.allow), nil
+}
+
+// filterSubscriptions returns the announcements for topics accepted by a predicate.
+// When a topic is announced more than once, the last announcement wins.
+func filterSubscriptions(subs []*pb.RPC_SubOpts, allow func(string) bool) []*pb.RPC_SubOpts {
+	accept := make(map[string]*pb.RPC_SubOpts)
+
+	for _, sub := range subs {
+		topic := sub.GetTopicid()
+
+		if !allow(topic) {
+			continue
+		}
+
+		accept[topic] = sub
+	}
+
+	if len(accept) == 0 {
+		return nil
+	}
+
+	result := make([]*pb.RPC_SubOpts, 0, len(accept))
+	for _, sub := range accept {
+		result = append(result, sub)
+	}
+
+	return result
+}
+
+// WrapLimitSubscriptionFilter wraps a subscription filter, rejecting RPCs with more than
+// limit subscription announcements.
+func WrapLimitSubscriptionFilter(filter SubscriptionFilter, limit int) SubscriptionFilter {
+	return &limitSubscriptionFilter{filter: filter, limit: limit}
+}
+
+type limitSubscriptionFilter struct {
+	filter SubscriptionFilter
+	limit  int
+}
+
+var _ SubscriptionFilter = (*limitSubscriptionFilter)(nil)
+
+func (f *limitSubscriptionFilter) CanSubscribe(topic string) bool {
+	return f.filter.CanSubscribe(topic)
+}
+
+func (f *limitSubscriptionFilter) FilterIncomingSubscriptions(from peer.ID, subs []*pb.RPC_SubOpts) ([]*pb.RPC_SubOpts, error) {
+	if len(subs) > f.limit {
+		return nil, ErrTooManySubscriptions
+	}
+
+	return f.filter.FilterIncomingSubscriptions(from, subs)
+}
+
+// filterIncomingSubscriptions applies the subscription filter and the per peer limit to the
+// subscription announcements of an RPC. When a topic is announced more than once in the RPC,
+// the last announcement wins.
+// Only called from processLoop.
+func (p *PubSub) filterIncomingSubscriptions(rpc *RPC) ([]*pb.RPC_SubOpts, error) {
+	subs := rpc.GetSubscriptions()
+	if len(subs) == 0 {
+		return nil, nil
+	}
+
+	var err error
+	if p.subFilter != nil {
+		subs, err = p.subFilter.FilterIncomingSubscriptions(rpc.from, subs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	subs = dedupSubscriptions(subs)
+
+	if p.maxPeerSubs > 0 {
+		count := p.peerSubs[rpc.from]
+		for _, sub := range subs {
+			_, ok := p.topics[sub.GetTopicid()][rpc.from]
+			switch {
+			case sub.GetSubscribe() && !ok:
+				count++
+			case !sub.GetSubscribe() && ok:
+				count--
+			}
+		}
+
+		if count > p.maxPeerSubs {
+			return nil, ErrTooManySubscriptions
+		}
+	}
+
+	return subs, nil
+}
+
+// dedupSubscriptions keeps the last announcement of each topic, in order.
+func dedupSubscriptions(subs []*pb.RPC_SubOpts) []*pb.RPC_SubOpts {
+	last := make(map[string]int, len(subs))
+	for i, sub := range subs {
+		last[sub.GetTopicid()] = i
+	}
+	if len(last) == len(subs) {
+		return subs
+	}
+
+	result := make([]*pb.RPC_SubOpts, 0, len(last))
+	for i, sub := range subs {
+		if last[sub.GetTopicid()] == i {
+			result = append(result, sub)
+		}
+	}
+	return result
+}
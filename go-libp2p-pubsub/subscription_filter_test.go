@@ -0,0 +1,267 @@
+package pubsub
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestBasicSubscriptionFilter(t *testing.T) {
+	p := peer.ID("a")
+
+	topic1 := "test1"
+	topic2 := "test2"
+	topic3 := "test3"
+	yes := true
+	subs := []*pb.RPC_SubOpts{
+		{Topicid: &topic1, Subscribe: &yes},
+		{Topicid: &topic2, Subscribe: &yes},
+		{Topicid: &topic3, Subscribe: &yes},
+	}
+
+	testFilter := func(filter SubscriptionFilter) {
+		if !filter.CanSubscribe(topic1) {
+			t.Fatal("expected allowed subscription")
+		}
+		if !filter.CanSubscribe(topic2) {
+			t.Fatal("expected allowed subscription")
+		}
+		if filter.CanSubscribe(topic3) {
+			t.Fatal("expected disallowed subscription")
+		}
+		allowed, err := filter.FilterIncomingSubscriptions(p, subs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(allowed) != 2 {
+			t.Fatalf("expected 2 allowed subscriptions, got %d", len(allowed))
+		}
+		for _, sub := range allowed {
+			if sub.GetTopicid() == topic3 {
+				t.Fatal("unexpected subscription to test3")
+			}
+		}
+
+		limitFilter := WrapLimitSubscriptionFilter(filter, 2)
+		_, err = limitFilter.FilterIncomingSubscriptions(p, subs)
+		if err != ErrTooManySubscriptions {
+			t.Fatal("expected rejection because of too many subscriptions")
+		}
+	}
+
+	testFilter(NewAllowlistSubscriptionFilter(topic1, topic2))
+	testFilter(NewRegexpSubscriptionFilter(regexp.MustCompile("^test[12]$")))
+	testFilter(NewPredicateSubscriptionFilter(func(topic string) bool {
+		return topic != topic3
+	}))
+}
+
+func TestSubscriptionFilterDeduplication(t *testing.T) {
+	p := peer.ID("a")
+
+	topic1 := "test1"
+	yes := true
+	no := false
+	subs := []*pb.RPC_SubOpts{
+		{Topicid: &topic1, Subscribe: &yes},
+		{Topicid: &topic1, Subscribe: &no},
+	}
+
+	filter := NewAllowlistSubscriptionFilter(topic1)
+	allowed, err := filter.FilterIncomingSubscriptions(p, subs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allowed) != 1 || allowed[0].GetSubscribe() {
+		t.Fatal("expected the last announcement to win")
+	}
+}
+
+func TestSubscriptionFilterRPC(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 2)
+	ps1 := getPubsub(ctx, hosts[0], WithSubscriptionFilter(NewAllowlistSubscriptionFilter("test1", "test2")))
+	ps2 := getPubsub(ctx, hosts[1], WithSubscriptionFilter(NewAllowlistSubscriptionFilter("test2", "test3")))
+
+	_, err := ps1.Join("test3")
+	if err == nil {
+		t.Fatal("expected join to fail for a topic disallowed by the filter")
+	}
+
+	for _, topic := range []string{"test1", "test2"} {
+		_, err = ps1.Subscribe(topic)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, topic := range []string{"test2", "test3"} {
+		_, err = ps2.Subscribe(topic)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	connect(t, hosts[0], hosts[1])
+	time.Sleep(time.Second)
+
+	var sub1, sub2, sub3 bool
+	ready := make(chan struct{})
+
+	ps1.eval <- func() {
+		_, sub1 = ps1.topics["test1"][hosts[1].ID()]
+		_, sub2 = ps1.topics["test2"][hosts[1].ID()]
+		_, sub3 = ps1.topics["test3"][hosts[1].ID()]
+		ready <- struct{}{}
+	}
+	<-ready
+
+	if sub1 {
+		t.Fatal("expected no subscription for test1")
+	}
+	if !sub2 {
+		t.Fatal("expected subscription for test2")
+	}
+	if sub3 {
+		t.Fatal("expected no subscription for test3")
+	}
+
+	ps2.eval <- func() {
+		_, sub1 = ps2.topics["test1"][hosts[0].ID()]
+		_, sub2 = ps2.topics["test2"][hosts[0].ID()]
+		_, sub3 = ps2.topics["test3"][hosts[0].ID()]
+		ready <- struct{}{}
+	}
+	<-ready
+
+	if sub1 {
+		t.Fatal("expected no subscription for test1")
+	}
+	if !sub2 {
+		t.Fatal("expected subscription for test2")
+	}
+	if sub3 {
+		t.Fatal("expected no subscription for test3")
+	}
+}
+
+func TestPeerSubscriptionLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mx sync.Mutex
+	penalized := make(map[peer.ID]error)
+	hook := func(p peer.ID, err error) {
+		mx.Lock()
+		penalized[p] = err
+		mx.Unlock()
+	}
+
+	hosts := getNetHosts(t, ctx, 3)
+	ps := getPubsub(ctx, hosts[0], WithPeerSubscriptionLimit(2), WithSubscriptionPenaltyHook(hook))
+	psubs := getPubsubs(ctx, hosts[1:])
+
+	// host 1 announces two subscriptions in its hello packet, within the limit
+	for _, topic := range []string{"test1", "test2"} {
+		_, err := psubs[0].Subscribe(topic)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// host 2 announces three subscriptions in its hello packet, over the limit
+	for _, topic := range []string{"test1", "test2", "test3"} {
+		_, err := psubs[1].Subscribe(topic)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	connect(t, hosts[0], hosts[1])
+	connect(t, hosts[0], hosts[2])
+	time.Sleep(time.Millisecond * 100)
+
+	peers := ps.ListPeers("test1")
+	if len(peers) != 1 || peers[0] != hosts[1].ID() {
+		t.Fatalf("expected only %s in test1, got %v", hosts[1].ID(), peers)
+	}
+
+	mx.Lock()
+	_, ok1 := penalized[hosts[1].ID()]
+	err2 := penalized[hosts[2].ID()]
+	mx.Unlock()
+	if ok1 {
+		t.Fatal("unexpected penalty for peer within the limit")
+	}
+	if err2 != ErrTooManySubscriptions {
+		t.Fatalf("expected penalty for peer over the limit, got %v", err2)
+	}
+
+	// a further subscription from host 1 exceeds the limit and is dropped
+	_, err := psubs[0].Subscribe("test3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	if len(ps.ListPeers("test3")) != 0 {
+		t.Fatal("expected the subscription over the limit to be dropped")
+	}
+
+	mx.Lock()
+	err1 := penalized[hosts[1].ID()]
+	mx.Unlock()
+	if err1 != ErrTooManySubscriptions {
+		t.Fatalf("expected penalty for peer over the limit, got %v", err1)
+	}
+}
+
+func TestPeerSubscriptionLimitDuplicates(t *testing.T) {
+	p := &PubSub{
+		maxPeerSubs: 2,
+		peerSubs:    map[peer.ID]int{"peer": 1},
+		topics:      map[string]map[peer.ID]struct{}{"test1": {"peer": {}}},
+	}
+
+	yes, no := true, false
+	subopt := func(topic string, subscribe *bool) *pb.RPC_SubOpts {
+		return &pb.RPC_SubOpts{Topicid: &topic, Subscribe: subscribe}
+	}
+
+	// repeated announcements of a topic in an RPC are only counted once
+	rpc := &RPC{RPC: pb.RPC{Subscriptions: []*pb.RPC_SubOpts{
+		subopt("test2", &yes),
+		subopt("test1", &yes),
+		subopt("test2", &yes),
+	}}, from: "peer"}
+	subs, err := p.filterIncomingSubscriptions(rpc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 2 || subs[0].GetTopicid() != "test1" || subs[1].GetTopicid() != "test2" {
+		t.Fatalf("unexpected subscriptions: %v", subs)
+	}
+
+	// the last announcement of a topic wins
+	rpc = &RPC{RPC: pb.RPC{Subscriptions: []*pb.RPC_SubOpts{
+		subopt("test1", &no),
+		subopt("test2", &yes),
+		subopt("test3", &yes),
+		subopt("test3", &no),
+	}}, from: "peer"}
+	subs, err = p.filterIncomingSubscriptions(rpc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 3 || subs[2].GetTopicid() != "test3" || subs[2].GetSubscribe() {
+		t.Fatalf("unexpected subscriptions: %v", subs)
+	}
+}
@@ -0,0 +1,408 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	proto "github.com/gogo/protobuf/proto"
+)
+
+// ErrTopicClosed is returned if a Topic is utilized after it has been closed
+var ErrTopicClosed = errors.New("this Topic is closed, try opening a new one")
+
+// Topic is the handle for a pubsub topic
+type Topic struct {
+	// atomic counter for the topic seqnos of the published messages
+	// NOTE: Must be declared at the top of the struct as we perform atomic
+	// operations on this field.
+	//
+	// See: https://golang.org/pkg/sync/atomic/#pkg-note-BUG
+	counter uint64
+
+	p     *PubSub
+	topic string
+
+	// the topic descriptor and the authentication policy derived from it; immutable
+	desc *pb.TopicDescriptor
+	auth *topicAuth
+
+	// the shared keys for topics with encryption
+	keys *sharedKeyring
+
+	evtHandlerMux sync.RWMutex
+	evtHandlers   map[*TopicEventHandler]struct{}
+
+	mux    sync.RWMutex
+	closed bool
+}
+
+type addTopicReq struct {
+	topic *Topic
+	resp  chan *Topic
+}
+
+type rmTopicReq struct {
+	topic *Topic
+	resp  chan error
+}
+
+// String returns the topic associated with t
+func (t *Topic) String() string {
+	return t.topic
+}
+
+// Descriptor returns the topic descriptor the topic was joined with.
+func (t *Topic) Descriptor() *pb.TopicDescriptor {
+	return proto.Clone(t.desc).(*pb.TopicDescriptor)
+}
+
+// EventHandler creates a handle for topic specific events.
+// Multiple event handlers may be created and will operate independently of each other.
+func (t *Topic) EventHandler() (*TopicEventHandler, error) {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	if t.closed {
+		return nil, ErrTopicClosed
+	}
+
+	h := &TopicEventHandler{
+		topic:    t,
+		evtLog:   make(map[peer.ID]EventType),
+		evtLogCh: make(chan struct{}, 1),
+	}
+
+	done := make(chan struct{}, 1)
+
+	select {
+	case t.p.eval <- func() {
+		tmap := t.p.topics[t.topic]
+		for p := range tmap {
+			h.evtLog[p] = PeerJoin
+		}
+
+		t.evtHandlerMux.Lock()
+		t.evtHandlers[h] = struct{}{}
+		t.evtHandlerMux.Unlock()
+		done <- struct{}{}
+	}:
+	case <-t.p.ctx.Done():
+		return nil, t.p.closedErr()
+	}
+
+	<-done
+
+	return h, nil
+}
+
+func (t *Topic) sendNotification(evt PeerEvent) {
+	t.evtHandlerMux.RLock()
+	defer t.evtHandlerMux.RUnlock()
+
+	for h := range t.evtHandlers {
+		h.sendNotification(evt)
+	}
+}
+
+func (t *Topic) hasEventHandlers() bool {
+	t.evtHandlerMux.RLock()
+	defer t.evtHandlerMux.RUnlock()
+
+	return len(t.evtHandlers) > 0
+}
+
+// Subscribe returns a new Subscription for the topic.
+// Note that subscription is not an instanteneous operation. It may take some time
+// before the subscription is processed by the pubsub main loop and propagated to our peers.
+func (t *Topic) Subscribe(opts ...SubOpt) (*Subscription, error) {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	if t.closed {
+		return nil, ErrTopicClosed
+	}
+
+	sub := &Subscription{
+		topic: t.topic,
+
+		ch:        make(chan *Message, 32),
+		peerEvtCh: make(chan PeerEvent, 1),
+		evtLog:    make(map[peer.ID]EventType),
+		evtLogCh:  make(chan struct{}, 1),
+	}
+
+	for _, opt := range opts {
+		err := opt(sub)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	out := make(chan *Subscription, 1)
+
+	select {
+	case t.p.addSub <- &addSubReq{sub: sub, resp: out}:
+	case <-t.p.ctx.Done():
+		return nil, t.p.closedErr()
+	}
+
+	return <-out, nil
+}
+
+// Relay enables message relaying for the topic and returns a function to cancel it.
+// See PubSub.Relay for the semantics of relays.
+func (t *Topic) Relay() (RelayCancelFunc, error) {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	if t.closed {
+		return nil, ErrTopicClosed
+	}
+
+	out := make(chan struct{}, 1)
+
+	select {
+	case t.p.addRelay <- &addRelayReq{topic: t.topic, resp: out}:
+	case <-t.p.ctx.Done():
+		return nil, t.p.closedErr()
+	}
+
+	<-out
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			select {
+			case t.p.rmRelay <- t.topic:
+			case <-t.p.ctx.Done():
+			}
+		})
+	}
+
+	return cancel, nil
+}
+
+// Publish publishes data to topic.
+// It returns ErrMessageTooLarge if the message doesn't fit within the maximum message size,
+// or when chunking is enabled, if the data exceeds the maximum chunked message size.
+func (t *Topic) Publish(ctx context.Context, data []byte) error {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	if t.closed {
+		return ErrTopicClosed
+	}
+
+	if t.auth != nil {
+		if t.p.signKey == nil {
+			return fmt.Errorf("cannot publish to topic %s: the topic requires signed messages, but message signing is disabled", t.topic)
+		}
+		if !t.auth.authorized(t.p.signID) {
+			return fmt.Errorf("cannot publish to topic %s: signing key of %s is not trusted by the topic descriptor", t.topic, t.p.signID)
+		}
+	}
+
+	var certs []*pb.DelegationCert
+	if t.auth != nil && t.auth.wot != nil {
+		certs, _ = t.auth.wot.chain(t.p.signID)
+	}
+
+	if t.keys != nil {
+		var err error
+		data, err = t.keys.encrypt(data)
+		if err != nil {
+			return fmt.Errorf("cannot publish to topic %s: encryption failed: %s", t.topic, err)
+		}
+	}
+
+	seqno, err := t.p.nextSeqno()
+	if err != nil {
+		return fmt.Errorf("cannot publish to topic %s: %s", t.topic, err)
+	}
+	m := &pb.Message{
+		Data:       data,
+		TopicIDs:   []string{t.topic},
+		From:       []byte(t.p.host.ID()),
+		Seqno:      seqno,
+		TopicSeqno: proto.Uint64(atomic.AddUint64(&t.counter, 1)),
+		Certs:      certs,
+	}
+	if t.p.signKey != nil {
+		m.From = []byte(t.p.signID)
+	}
+
+	msgs, err := t.p.chunkMessage(m)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range msgs {
+		if t.p.signKey != nil {
+			err := signMessage(t.p.signID, t.p.signKey, m)
+			if err != nil {
+				return err
+			}
+		}
+
+		if rpcWithMessages(m).Size() > t.p.maxMessageSize {
+			return ErrMessageTooLarge
+		}
+	}
+
+	for _, m := range msgs {
+		select {
+		case t.p.publish <- &Message{Message: m, ReceivedFrom: t.p.host.ID()}:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.p.ctx.Done():
+			return t.p.closedErr()
+		}
+	}
+
+	return nil
+}
+
+// ListPeers returns a list of peers we are connected to in the topic.
+func (t *Topic) ListPeers() []peer.ID {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	if t.closed {
+		return []peer.ID{}
+	}
+
+	return t.p.ListPeers(t.topic)
+}
+
+// RegisterValidator registers a validator for the topic.
+// See PubSub.RegisterTopicValidator for the semantics of validators and their options.
+func (t *Topic) RegisterValidator(val interface{}, opts ...ValidatorOpt) error {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	if t.closed {
+		return ErrTopicClosed
+	}
+
+	return t.p.RegisterTopicValidator(t.topic, val, opts...)
+}
+
+// UnregisterValidator removes the validator from the topic.
+// Returns an error if there was no validator registered with the topic.
+func (t *Topic) UnregisterValidator() error {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	if t.closed {
+		return ErrTopicClosed
+	}
+
+	return t.p.UnregisterTopicValidator(t.topic)
+}
+
+// Close closes down the topic. Will return an error unless there are no active subscriptions,
+// event handlers or validators for the topic.
+// Does not error if the topic is already closed.
+func (t *Topic) Close() error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.closed {
+		return nil
+	}
+
+	req := &rmTopicReq{t, make(chan error, 1)}
+
+	select {
+	case t.p.rmTopic <- req:
+	case <-t.p.ctx.Done():
+		return t.p.closedErr()
+	}
+
+	err := <-req.resp
+
+	if err == nil {
+		t.closed = true
+	}
+
+	return err
+}
+
+// TopicEventHandler is used to manage topic specific events. No Subscription is required to receive events.
+type TopicEventHandler struct {
+	topic *Topic
+
+	evtLogMx sync.Mutex
+	evtLog   map[peer.ID]EventType
+	evtLogCh chan struct{}
+}
+
+// Cancel closes the topic event handler
+func (t *TopicEventHandler) Cancel() {
+	topic := t.topic
+
+	topic.evtHandlerMux.Lock()
+	delete(topic.evtHandlers, t)
+	topic.evtHandlerMux.Unlock()
+}
+
+func (t *TopicEventHandler) sendNotification(evt PeerEvent) {
+	t.evtLogMx.Lock()
+	defer t.evtLogMx.Unlock()
+
+	t.addToEventLog(evt)
+}
+
+// addToEventLog assumes a lock has been taken to protect the event log
+func (t *TopicEventHandler) addToEventLog(evt PeerEvent) {
+	e, ok := t.evtLog[evt.Peer]
+	if !ok {
+		t.evtLog[evt.Peer] = evt.Type
+		// send signal that an event has been added to the event log
+		select {
+		case t.evtLogCh <- struct{}{}:
+		default:
+		}
+	} else if e != evt.Type {
+		delete(t.evtLog, evt.Peer)
+	}
+}
+
+// pullFromEventLog assumes a lock has been taken to protect the event log
+func (t *TopicEventHandler) pullFromEventLog() (PeerEvent, bool) {
+	for k, v := range t.evtLog {
+		evt := PeerEvent{Peer: k, Type: v}
+		delete(t.evtLog, k)
+		return evt, true
+	}
+	return PeerEvent{}, false
+}
+
+// NextPeerEvent returns the next event regarding subscribed peers
+// Guarantees: Peer Join and Peer Leave events for a given peer will fire in order.
+// Unless a peer both Joins and Leaves before NextPeerEvent emits either event
+// all events will eventually be received from NextPeerEvent.
+func (t *TopicEventHandler) NextPeerEvent(ctx context.Context) (PeerEvent, error) {
+	for {
+		t.evtLogMx.Lock()
+		evt, ok := t.pullFromEventLog()
+		if ok {
+			// make sure an event log signal is available if there are events in the event log
+			if len(t.evtLog) > 0 {
+				select {
+				case t.evtLogCh <- struct{}{}:
+				default:
+				}
+			}
+			t.evtLogMx.Unlock()
+			return evt, nil
+		}
+		t.evtLogMx.Unlock()
+
+		select {
+		case <-t.evtLogCh:
+			continue
+		case <-ctx.Done():
+			return PeerEvent{}, ctx.Err()
+		}
+	}
+}
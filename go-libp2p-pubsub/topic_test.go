@@ -0,0 +1,332 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func getTopics(psubs []*PubSub, topicID string) []*Topic {
+	topics := make([]*Topic, len(psubs))
+
+	for i, ps := range psubs {
+		t, err := ps.Join(topicID)
+		if err != nil {
+			panic(err)
+		}
+		topics[i] = t
+	}
+
+	return topics
+}
+
+func TestTopicJoinTwice(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 1)
+	ps := getPubsub(ctx, hosts[0])
+
+	topic, err := ps.Join("foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ps.Join("foobar"); err == nil {
+		t.Fatal("expected an error joining the same topic twice")
+	}
+
+	if err := topic.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ps.Join("foobar"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTopicCloseWithOpenSubscription(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 1)
+	ps := getPubsub(ctx, hosts[0])
+
+	topic, err := ps.Join("foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := topic.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := topic.Close(); err == nil {
+		t.Fatal("expected an error closing a topic with an open subscription")
+	}
+
+	sub.Cancel()
+	time.Sleep(time.Millisecond * 100)
+
+	if err := topic.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := topic.Subscribe(); err != ErrTopicClosed {
+		t.Fatalf("expected ErrTopicClosed, got %v", err)
+	}
+	if err := topic.Publish(ctx, []byte("data")); err != ErrTopicClosed {
+		t.Fatalf("expected ErrTopicClosed, got %v", err)
+	}
+}
+
+func TestTopicCloseWithValidator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 1)
+	ps := getPubsub(ctx, hosts[0])
+
+	topic, err := ps.Join("foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = topic.RegisterValidator(func(context.Context, peer.ID, *Message) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := topic.Close(); err == nil {
+		t.Fatal("expected an error closing a topic with a registered validator")
+	}
+
+	if err := topic.UnregisterValidator(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := topic.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTopicPublishSubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 10)
+	psubs := getPubsubs(ctx, hosts)
+	topics := getTopics(psubs, "foobar")
+
+	var subs []*Subscription
+	for _, topic := range topics {
+		sub, err := topic.Subscribe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		subs = append(subs, sub)
+	}
+
+	sparseConnect(t, hosts)
+	time.Sleep(time.Millisecond * 100)
+
+	for i, topic := range topics {
+		data := []byte{byte(i)}
+		if err := topic.Publish(ctx, data); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, sub := range subs {
+			assertReceive(t, sub, data)
+		}
+	}
+
+	if len(topics[0].ListPeers()) == 0 {
+		t.Fatal("expected topic peers")
+	}
+}
+
+func TestTopicEventHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 2)
+	psubs := getPubsubs(ctx, hosts)
+	topics := getTopics(psubs, "foobar")
+
+	evts, err := topics[0].EventHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := topics[1].Subscribe(); err != nil {
+		t.Fatal(err)
+	}
+
+	connect(t, hosts[0], hosts[1])
+
+	tctx, tcancel := context.WithTimeout(ctx, time.Second*5)
+	defer tcancel()
+
+	evt, err := evts.NextPeerEvent(tctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evt.Type != PeerJoin || evt.Peer != hosts[1].ID() {
+		t.Fatalf("unexpected event %v", evt)
+	}
+
+	if err := topics[0].Close(); err == nil {
+		t.Fatal("expected an error closing a topic with an active event handler")
+	}
+
+	evts.Cancel()
+
+	if err := topics[0].Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTopicRelay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a line of hosts; the host in the middle relays without subscribing
+	hosts := getNetHosts(t, ctx, 3)
+	psubs := getPubsubs(ctx, hosts)
+
+	sub0, err := psubs[0].Subscribe("foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub2, err := psubs[2].Subscribe("foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel1, err := psubs[1].Relay("foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel2, err := psubs[1].Relay("foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connect(t, hosts[0], hosts[1])
+	connect(t, hosts[1], hosts[2])
+	time.Sleep(time.Millisecond * 100)
+
+	if len(psubs[1].GetTopics()) != 0 {
+		t.Fatal("expected the relay not to be a subscription")
+	}
+
+	err = psubs[0].Publish("foobar", []byte("relayed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertReceive(t, sub0, []byte("relayed"))
+	assertReceive(t, sub2, []byte("relayed"))
+
+	// relays are reference counted
+	cancel1()
+	cancel1()
+	time.Sleep(time.Millisecond * 100)
+
+	err = psubs[0].Publish("foobar", []byte("still relayed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertReceive(t, sub0, []byte("still relayed"))
+	assertReceive(t, sub2, []byte("still relayed"))
+
+	// relays coexist with subscriptions
+	sub1, err := psubs[1].Subscribe("foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel2()
+	time.Sleep(time.Millisecond * 100)
+
+	err = psubs[0].Publish("foobar", []byte("subscribed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertReceive(t, sub1, []byte("subscribed"))
+	assertReceive(t, sub2, []byte("subscribed"))
+
+	// once everything is cancelled, messages are no longer relayed
+	sub1.Cancel()
+	time.Sleep(time.Millisecond * 100)
+
+	if len(psubs[0].ListPeers("foobar")) != 0 {
+		t.Fatal("expected the relay to have left the topic")
+	}
+
+	err = psubs[0].Publish("foobar", []byte("dropped"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx2, cancel3 := context.WithTimeout(ctx, time.Millisecond*100)
+	defer cancel3()
+	_, err = sub2.Next(ctx2)
+	if err == nil {
+		t.Fatal("expected no message without a relay")
+	}
+}
+
+func TestTopicRelayGossipsub(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 3)
+	psubs := getGossipsubs(ctx, hosts)
+
+	sub0, err := psubs[0].Subscribe("foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub2, err := psubs[2].Subscribe("foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topic, err := psubs[1].Join("foobar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	relayCancel, err := topic.Relay()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = topic.Close()
+	if err == nil {
+		t.Fatal("expected an error closing a topic with an outstanding relay")
+	}
+
+	connect(t, hosts[0], hosts[1])
+	connect(t, hosts[1], hosts[2])
+
+	// wait for heartbeats to build the mesh
+	time.Sleep(time.Second * 2)
+
+	err = psubs[0].Publish("foobar", []byte("relayed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertReceive(t, sub0, []byte("relayed"))
+	assertReceive(t, sub2, []byte("relayed"))
+
+	relayCancel()
+	time.Sleep(time.Millisecond * 100)
+
+	err = topic.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,251 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	ggio "github.com/gogo/protobuf/io"
+)
+
+type testTracer struct {
+	mx     sync.Mutex
+	events map[pb.TraceEvent_Type]int
+	reject map[string]int
+}
+
+func newTestTracer() *testTracer {
+	return &testTracer{
+		events: make(map[pb.TraceEvent_Type]int),
+		reject: make(map[string]int),
+	}
+}
+
+func (t *testTracer) Trace(evt *pb.TraceEvent) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.events[evt.GetType()]++
+	if evt.GetType() == pb.TraceEvent_REJECT_MESSAGE {
+		t.reject[evt.GetRejectMessage().GetReason()]++
+	}
+}
+
+func (t *testTracer) count(typ pb.TraceEvent_Type) int {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	return t.events[typ]
+}
+
+func testWithTracer(t *testing.T, tracers ...EventTracer) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hosts := getNetHosts(t, ctx, len(tracers))
+
+	var psubs []*PubSub
+	for i, tr := range tracers {
+		ps, err := NewGossipSub(ctx, hosts[i], WithEventTracer(tr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		psubs = append(psubs, ps)
+	}
+
+	// the last peer rejects everything
+	err := psubs[len(psubs)-1].RegisterTopicValidator("test", func(context.Context, peer.ID, *Message) bool {
+		return false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var subs []*Subscription
+	for _, ps := range psubs {
+		sub, err := ps.Subscribe("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		subs = append(subs, sub)
+	}
+
+	denseConnect(t, hosts)
+
+	// wait for heartbeats to build mesh
+	time.Sleep(time.Second * 2)
+
+	for i := 0; i < 10; i++ {
+		msg := []byte(fmt.Sprintf("message %d", i))
+		psubs[i%(len(psubs)-1)].Publish("test", msg)
+
+		for _, sub := range subs[:len(subs)-1] {
+			_, err := sub.Next(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// leave the topic and disconnect to trace the rest of the events
+	subs[0].Cancel()
+	time.Sleep(time.Millisecond * 100)
+	hosts[0].Close()
+	time.Sleep(time.Second)
+}
+
+func TestEventTracer(t *testing.T) {
+	var tracers []*testTracer
+	var etracers []EventTracer
+	for i := 0; i < 5; i++ {
+		tr := newTestTracer()
+		tracers = append(tracers, tr)
+		etracers = append(etracers, tr)
+	}
+
+	testWithTracer(t, etracers...)
+
+	for _, typ := range []pb.TraceEvent_Type{
+		pb.TraceEvent_PUBLISH_MESSAGE,
+		pb.TraceEvent_DELIVER_MESSAGE,
+		pb.TraceEvent_DUPLICATE_MESSAGE,
+		pb.TraceEvent_ADD_PEER,
+		pb.TraceEvent_RECV_RPC,
+		pb.TraceEvent_SEND_RPC,
+		pb.TraceEvent_JOIN,
+		pb.TraceEvent_GRAFT,
+	} {
+		if tracers[0].count(typ) == 0 {
+			t.Errorf("expected %s events", typ)
+		}
+	}
+
+	if tracers[0].count(pb.TraceEvent_LEAVE) != 1 {
+		t.Error("expected a LEAVE event")
+	}
+	if tracers[0].count(pb.TraceEvent_PRUNE) == 0 {
+		t.Error("expected PRUNE events")
+	}
+	if tracers[1].count(pb.TraceEvent_REMOVE_PEER) == 0 {
+		t.Error("expected REMOVE_PEER events")
+	}
+
+	rejecter := tracers[len(tracers)-1]
+	if rejecter.reject[rejectValidationFailed] == 0 {
+		t.Error("expected messages rejected by validation")
+	}
+}
+
+func TestJSONTracer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pubsub-trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var tracers []*JSONTracer
+	var etracers []EventTracer
+	for i := 0; i < 5; i++ {
+		tr, err := NewJSONTracer(filepath.Join(dir, fmt.Sprintf("trace%d.json", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tracers = append(tracers, tr)
+		etracers = append(etracers, tr)
+	}
+
+	testWithTracer(t, etracers...)
+
+	for _, tr := range tracers {
+		tr.Close()
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	f, err := os.Open(filepath.Join(dir, "trace0.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	counts := make(map[pb.TraceEvent_Type]int)
+	dec := json.NewDecoder(f)
+	for {
+		var evt pb.TraceEvent
+		err := dec.Decode(&evt)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[evt.GetType()]++
+	}
+
+	if counts[pb.TraceEvent_PUBLISH_MESSAGE] != 3 {
+		t.Errorf("expected 3 PUBLISH_MESSAGE events, got %d", counts[pb.TraceEvent_PUBLISH_MESSAGE])
+	}
+	if counts[pb.TraceEvent_DELIVER_MESSAGE] != 10 {
+		t.Errorf("expected 10 DELIVER_MESSAGE events, got %d", counts[pb.TraceEvent_DELIVER_MESSAGE])
+	}
+}
+
+func TestPBTracer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pubsub-trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var tracers []*PBTracer
+	var etracers []EventTracer
+	for i := 0; i < 5; i++ {
+		tr, err := NewPBTracer(filepath.Join(dir, fmt.Sprintf("trace%d.pb", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tracers = append(tracers, tr)
+		etracers = append(etracers, tr)
+	}
+
+	testWithTracer(t, etracers...)
+
+	for _, tr := range tracers {
+		tr.Close()
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	f, err := os.Open(filepath.Join(dir, "trace0.pb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	counts := make(map[pb.TraceEvent_Type]int)
+	r := ggio.NewDelimitedReader(f, 1<<20)
+	for {
+		var evt pb.TraceEvent
+		err := r.ReadMsg(&evt)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[evt.GetType()]++
+	}
+
+	if counts[pb.TraceEvent_PUBLISH_MESSAGE] != 3 {
+		t.Errorf("expected 3 PUBLISH_MESSAGE events, got %d", counts[pb.TraceEvent_PUBLISH_MESSAGE])
+	}
+	if counts[pb.TraceEvent_DELIVER_MESSAGE] != 10 {
+		t.Errorf("expected 10 DELIVER_MESSAGE events, got %d", counts[pb.TraceEvent_DELIVER_MESSAGE])
+	}
+}
@@ -0,0 +1,177 @@
+package pubsub
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
+
+	ggio "github.com/gogo/protobuf/io"
+)
+
+// TraceBufferSize is the maximum number of trace events buffered by the file tracers;
+// when the buffer is full, events are dropped rather than blocking the pubsub system.
+var TraceBufferSize = 1 << 16 // 64K ought to be enough for everyone; famous last words.
+
+// basicTracer accumulates trace events in a buffer, which is drained by a writer goroutine
+type basicTracer struct {
+	ch     chan struct{}
+	mx     sync.Mutex
+	buf    []*pb.TraceEvent
+	closed bool
+	logger Logger
+}
+
+func newBasicTracer() basicTracer {
+	return basicTracer{ch: make(chan struct{}, 1), logger: newGoLogger(log)}
+}
+
+func (t *basicTracer) setLogger(l Logger) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.logger = l
+}
+
+func (t *basicTracer) Trace(evt *pb.TraceEvent) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if t.closed {
+		return
+	}
+
+	if len(t.buf) >= TraceBufferSize {
+		t.logger.Warnw("trace buffer overflow; dropping trace event")
+	} else {
+		t.buf = append(t.buf, evt)
+	}
+
+	select {
+	case t.ch <- struct{}{}:
+	default:
+	}
+}
+
+// Close closes the tracer; buffered events are written out before the underlying
+// file is closed.
+func (t *basicTracer) Close() {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if !t.closed {
+		t.closed = true
+		close(t.ch)
+	}
+}
+
+// drain runs the writer loop, handing batches of buffered events to write
+// until the tracer is closed.
+func (t *basicTracer) drain(write func([]*pb.TraceEvent)) {
+	var buf []*pb.TraceEvent
+	for {
+		_, ok := <-t.ch
+
+		// deadlock avoidance; swap buffers so that the lock is not held while writing
+		t.mx.Lock()
+		tmp := t.buf
+		t.buf = buf[:0]
+		buf = tmp
+		t.mx.Unlock()
+
+		write(buf)
+
+		for i := range buf {
+			buf[i] = nil
+		}
+
+		if !ok {
+			return
+		}
+	}
+}
+
+// JSONTracer is a tracer that writes events to a file, encoded in ndjson.
+type JSONTracer struct {
+	basicTracer
+	w io.WriteCloser
+}
+
+var _ EventTracer = (*JSONTracer)(nil)
+
+// NewJSONTracer creates a new JSONTracer writing traces to file.
+func NewJSONTracer(file string) (*JSONTracer, error) {
+	return OpenJSONTracer(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+}
+
+// OpenJSONTracer creates a new JSONTracer, with explicit control of OpenFile flags and permissions.
+func OpenJSONTracer(file string, flags int, perm os.FileMode) (*JSONTracer, error) {
+	f, err := os.OpenFile(file, flags, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &JSONTracer{w: f, basicTracer: newBasicTracer()}
+	go tr.doWrite()
+
+	return tr, nil
+}
+
+func (t *JSONTracer) doWrite() {
+	enc := json.NewEncoder(t.w)
+	t.drain(func(buf []*pb.TraceEvent) {
+		for _, evt := range buf {
+			err := enc.Encode(evt)
+			if err != nil {
+				t.logger.Warnw("error writing event trace", "error", err)
+			}
+		}
+	})
+	t.w.Close()
+}
+
+// PBTracer is a tracer that writes events to a file, as delimited protobufs.
+type PBTracer struct {
+	basicTracer
+	w io.WriteCloser
+}
+
+var _ EventTracer = (*PBTracer)(nil)
+
+// NewPBTracer creates a new PBTracer writing traces to file.
+func NewPBTracer(file string) (*PBTracer, error) {
+	return OpenPBTracer(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+}
+
+// OpenPBTracer creates a new PBTracer, with explicit control of OpenFile flags and permissions.
+func OpenPBTracer(file string, flags int, perm os.FileMode) (*PBTracer, error) {
+	f, err := os.OpenFile(file, flags, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &PBTracer{w: f, basicTracer: newBasicTracer()}
+	go tr.doWrite()
+
+	return tr, nil
+}
+
+func (t *PBTracer) doWrite() {
+	bufw := bufio.NewWriter(t.w)
+	w := ggio.NewDelimitedWriter(bufw)
+	t.drain(func(buf []*pb.TraceEvent) {
+		for _, evt := range buf {
+			err := w.WriteMsg(evt)
+			if err != nil {
+				t.logger.Warnw("error writing event trace", "error", err)
+			}
+		}
+
+		err := bufw.Flush()
+		if err != nil {
+			t.logger.Warnw("error flushing event trace", "error", err)
+		}
+	})
+	t.w.Close()
+}
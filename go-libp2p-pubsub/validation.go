@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/libp2p/go-libp2p-core/peer"
@@ -17,6 +18,34 @@ const (
 // Validator is a function that validates a message.
 type Validator func(context.Context, peer.ID, *Message) bool
 
+// ValidatorEx is an extended validation function that validates a message and returns
+// a ValidationResult.
+type ValidatorEx func(context.Context, peer.ID, *Message) ValidationResult
+
+// ValidationResult represents the decision of an extended validator.
+type ValidationResult int
+
+const (
+	// ValidationAccept indicates a valid message that should be delivered to the
+	// application and forwarded to the network.
+	ValidationAccept = ValidationResult(0)
+	// ValidationReject indicates an invalid message that should be neither delivered
+	// nor forwarded. The peer that forwarded the message is penalized.
+	ValidationReject = ValidationResult(1)
+	// ValidationIgnore indicates a message that should be silently dropped: it is
+	// neither delivered nor forwarded, but the peer that forwarded it is not penalized.
+	ValidationIgnore = ValidationResult(2)
+	// internal; validation was throttled, which has the effect of an ignore
+	validationThrottled = ValidationResult(-1)
+)
+
+// PeerPenaltyHook is a callback invoked when a peer forwards a message that is
+// rejected by validation, either because of a bad signature or because a topic
+// validator returned ValidationReject.
+// The hook is invoked from the validation goroutines; it must be safe for
+// concurrent use and should not block.
+type PeerPenaltyHook func(peer.ID, *Message)
+
 // ValidatorOpt is an option for RegisterTopicValidator.
 type ValidatorOpt func(addVal *addValReq) error
 
@@ -42,28 +71,35 @@ type validation struct {
 
 	// this is the number of synchronous validation workers
 	validateWorkers int
+
+	// workers tracks the validation goroutines, so that they can be awaited on shutdown
+	workers sync.WaitGroup
 }
 
 // validation requests
 type validateReq struct {
-	vals []*topicVal
-	src  peer.ID
-	msg  *Message
+	vals   []*topicVal
+	topics []*Topic
+	src    peer.ID
+	msg    *Message
 }
 
 // representation of topic validators
 type topicVal struct {
 	topic            string
-	validate         Validator
+	validate         ValidatorEx
 	validateTimeout  time.Duration
 	validateThrottle chan struct{}
 	validateInline   bool
+
+	logger  Logger
+	metrics Metrics
 }
 
 // async request to add a topic validators
 type addValReq struct {
 	topic    string
-	validate Validator
+	validate interface{}
 	timeout  time.Duration
 	throttle int
 	inline   bool
@@ -90,6 +126,7 @@ func newValidation() *validation {
 // workers
 func (v *validation) Start(p *PubSub) {
 	v.p = p
+	v.workers.Add(v.validateWorkers)
 	for i := 0; i < v.validateWorkers; i++ {
 		go v.validateWorker()
 	}
@@ -105,12 +142,38 @@ func (v *validation) AddValidator(req *addValReq) {
 		return
 	}
 
+	makeValidatorEx := func(v Validator) ValidatorEx {
+		return func(ctx context.Context, p peer.ID, msg *Message) ValidationResult {
+			if v(ctx, p, msg) {
+				return ValidationAccept
+			}
+			return ValidationReject
+		}
+	}
+
+	var validator ValidatorEx
+	switch v := req.validate.(type) {
+	case func(ctx context.Context, p peer.ID, msg *Message) bool:
+		validator = makeValidatorEx(Validator(v))
+	case Validator:
+		validator = makeValidatorEx(v)
+	case func(ctx context.Context, p peer.ID, msg *Message) ValidationResult:
+		validator = ValidatorEx(v)
+	case ValidatorEx:
+		validator = v
+	default:
+		req.resp <- fmt.Errorf("Unknown validator type for topic %s; must be an instance of Validator or ValidatorEx", topic)
+		return
+	}
+
 	val := &topicVal{
 		topic:            topic,
-		validate:         req.validate,
+		validate:         validator,
 		validateTimeout:  0,
 		validateThrottle: make(chan struct{}, defaultValidateConcurrency),
 		validateInline:   req.inline,
+		logger:           v.p.logger,
+		metrics:          v.p.metrics,
 	}
 
 	if req.timeout > 0 {
@@ -141,18 +204,57 @@ func (v *validation) RemoveValidator(req *rmValReq) {
 // Push pushes a message into the validation pipeline.
 // It returns true if the message can be forwarded immediately without validation.
 func (v *validation) Push(src peer.ID, msg *Message) bool {
+	req := v.newRequest(src, msg)
+	if req == nil {
+		return true
+	}
+
+	v.enqueue(req)
+	return false
+}
+
+// newRequest returns the validation request of a message, or nil if the message can be
+// forwarded without validation.
+func (v *validation) newRequest(src peer.ID, msg *Message) *validateReq {
 	vals := v.getValidators(msg)
+	topics := v.getSecureTopics(msg)
 
-	if len(vals) > 0 || msg.Signature != nil {
-		select {
-		case v.validateQ <- &validateReq{vals, src, msg}:
-		default:
-			log.Warningf("message validation throttled; dropping message from %s", src)
-		}
-		return false
+	// fragments are validated by the user validators once reassembled
+	if v.p.isFragment(msg) {
+		vals = nil
 	}
 
-	return true
+	if len(vals) == 0 && len(topics) == 0 && msg.Signature == nil {
+		return nil
+	}
+
+	return &validateReq{vals, topics, src, msg}
+}
+
+// enqueue queues a request in the validation pipeline, dropping the message if the
+// pipeline is full.
+func (v *validation) enqueue(req *validateReq) {
+	select {
+	case v.validateQ <- req:
+		v.p.tracer.ValidateMessage(req.msg)
+	default:
+		v.p.logger.Warnw("message validation throttled; dropping message", v.p.msgFields(req.msg)...)
+		v.p.rejectMessage(req.msg, rejectValidationQueueFull)
+	}
+}
+
+// enqueueWait queues a request in the validation pipeline, waiting for room in the pipeline
+// instead of dropping the message. It must not be called from the event loop.
+func (v *validation) enqueueWait(ctx context.Context, req *validateReq) error {
+	select {
+	case v.validateQ <- req:
+		v.p.tracer.ValidateMessage(req.msg)
+		return nil
+	case <-v.p.ctx.Done():
+		return v.p.closedErr()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // getValidators returns all validators that apply to a given message
@@ -171,12 +273,34 @@ func (v *validation) getValidators(msg *Message) []*topicVal {
 	return vals
 }
 
+// getSecureTopics returns the topics of a message that use web of trust authentication or
+// encryption, which require processing in the validation pipeline
+func (v *validation) getSecureTopics(msg *Message) []*Topic {
+	var topics []*Topic
+
+	for _, topic := range msg.GetTopicIDs() {
+		t, ok := v.p.myTopics[topic]
+		if !ok {
+			continue
+		}
+
+		if t.keys == nil && (t.auth == nil || t.auth.wot == nil) {
+			continue
+		}
+
+		topics = append(topics, t)
+	}
+
+	return topics
+}
+
 // validateWorker is an active goroutine performing inline validation
 func (v *validation) validateWorker() {
+	defer v.workers.Done()
 	for {
 		select {
 		case req := <-v.validateQ:
-			v.validate(req.vals, req.src, req.msg)
+			v.validate(req.vals, req.topics, req.src, req.msg)
 		case <-v.p.ctx.Done():
 			return
 		}
@@ -184,21 +308,64 @@ func (v *validation) validateWorker() {
 }
 
 // validate performs validation and only sends the message if all validators succeed
-// signature validation is performed synchronously, while user validators are invoked
-// asynchronously, throttled by the global validation throttle.
-func (v *validation) validate(vals []*topicVal, src peer.ID, msg *Message) {
+// signature validation, web of trust authentication and decryption are performed synchronously,
+// while user validators are invoked asynchronously, throttled by the global validation throttle.
+func (v *validation) validate(vals []*topicVal, topics []*Topic, src peer.ID, msg *Message) {
 	if msg.Signature != nil {
 		if !v.validateSignature(msg) {
-			log.Warningf("message signature validation failed; dropping message from %s", src)
+			v.p.logger.Warnw("message signature validation failed; dropping message", v.p.msgFields(msg)...)
+			v.p.rejectMessage(msg, rejectInvalidSignature)
+			v.p.penalizePeer(src, msg)
 			return
 		}
 	}
 
 	// we can mark the message as seen now that we have verified the signature
-	// and avoid invoking user validators more than once
-	id := msgID(msg.Message)
-	if !v.p.markSeen(id) {
-		return
+	// and avoid invoking user validators more than once; reassembled messages
+	// are unique, as their fragments have been marked
+	if msg.fragments == nil {
+		id := v.p.msgID(msg.Message)
+		if !v.p.markSeen(id) {
+			v.p.duplicateMessage(msg)
+			return
+		}
+	}
+
+	// check the certificate chain of the publisher in web of trust topics
+	var keys []*sharedKeyring
+	for _, t := range topics {
+		if t.keys != nil {
+			keys = append(keys, t.keys)
+		}
+
+		if t.auth == nil || t.auth.wot == nil || msg.fragments != nil {
+			continue
+		}
+
+		err := t.auth.wot.authorizeMsg(msg)
+		if err != nil {
+			v.p.logger.Debugw("message authentication failed; dropping message", append(v.p.msgFields(msg), "error", err)...)
+			v.p.rejectMessage(msg, rejectUnauthorizedPublisher)
+			v.p.penalizePeer(src, msg)
+			return
+		}
+	}
+
+	// decrypt the payload before invoking user validators, which see the decrypted message;
+	// chunked messages are decrypted once reassembled
+	if len(keys) > 0 && !v.p.isFragment(msg) {
+		err := decryptMessage(keys, msg)
+		switch {
+		case err == errUnknownSharedKey:
+			v.p.logger.Debugw("message encrypted with unknown shared key; dropping message", v.p.msgFields(msg)...)
+			v.p.rejectMessage(msg, rejectUnknownSharedKey)
+			return
+		case err != nil:
+			v.p.logger.Warnw("message decryption failed; dropping message", append(v.p.msgFields(msg), "error", err)...)
+			v.p.rejectMessage(msg, rejectDecryptionFailed)
+			v.p.penalizePeer(src, msg)
+			return
+		}
 	}
 
 	var inline, async []*topicVal
@@ -211,57 +378,95 @@ func (v *validation) validate(vals []*topicVal, src peer.ID, msg *Message) {
 	}
 
 	// apply inline (synchronous) validators
+	result := ValidationAccept
+loop:
 	for _, val := range inline {
-		if !val.validateMsg(v.p.ctx, src, msg) {
-			log.Debugf("message validation failed; dropping message from %s", src)
-			return
+		switch val.validateMsg(v.p.ctx, src, msg) {
+		case ValidationAccept:
+		case ValidationReject:
+			result = ValidationReject
+			break loop
+		case ValidationIgnore:
+			result = ValidationIgnore
 		}
 	}
 
+	if result == ValidationReject {
+		v.p.logger.Debugw("message validation failed; dropping message", v.p.msgFields(msg)...)
+		v.p.rejectMessage(msg, rejectValidationFailed)
+		v.p.penalizePeer(src, msg)
+		return
+	}
+
 	// apply async validators
 	if len(async) > 0 {
 		select {
 		case v.validateThrottle <- struct{}{}:
+			v.workers.Add(1)
 			go func() {
-				v.doValidateTopic(async, src, msg)
+				defer v.workers.Done()
+				v.doValidateTopic(async, src, msg, result)
 				<-v.validateThrottle
 			}()
 		default:
-			log.Warningf("message validation throttled; dropping message from %s", src)
+			v.p.logger.Warnw("message validation throttled; dropping message", v.p.msgFields(msg)...)
+			v.p.rejectMessage(msg, rejectValidationThrottled)
 		}
 		return
 	}
 
-	// no async validators, send the message
-	v.p.sendMsg <- &sendReq{
-		from: src,
-		msg:  msg,
+	if result == ValidationIgnore {
+		v.p.logger.Debugw("message validation ignored; dropping message", v.p.msgFields(msg)...)
+		v.p.rejectMessage(msg, rejectValidationIgnored)
+		return
+	}
+
+	// no async validators, accepted message, send it
+	v.sendMsg(src, msg)
+}
+
+// sendMsg hands a validated message to the event loop for delivery and forwarding
+func (v *validation) sendMsg(src peer.ID, msg *Message) {
+	select {
+	case v.p.sendMsg <- &sendReq{from: src, msg: msg}:
+	case <-v.p.ctx.Done():
 	}
 }
 
 func (v *validation) validateSignature(msg *Message) bool {
 	err := verifyMessageSignature(msg.Message)
 	if err != nil {
-		log.Debugf("signature verification error: %s", err.Error())
+		v.p.logger.Debugw("signature verification error", append(v.p.msgFields(msg), "error", err)...)
 		return false
 	}
 
 	return true
 }
 
-func (v *validation) doValidateTopic(vals []*topicVal, src peer.ID, msg *Message) {
-	if !v.validateTopic(vals, src, msg) {
-		log.Warningf("message validation failed; dropping message from %s", src)
-		return
+func (v *validation) doValidateTopic(vals []*topicVal, src peer.ID, msg *Message, r ValidationResult) {
+	result := v.validateTopic(vals, src, msg)
+
+	if result == ValidationAccept && r != ValidationAccept {
+		result = r
 	}
 
-	v.p.sendMsg <- &sendReq{
-		from: src,
-		msg:  msg,
+	switch result {
+	case ValidationAccept:
+		v.sendMsg(src, msg)
+	case ValidationReject:
+		v.p.logger.Warnw("message validation failed; dropping message", v.p.msgFields(msg)...)
+		v.p.rejectMessage(msg, rejectValidationFailed)
+		v.p.penalizePeer(src, msg)
+	case ValidationIgnore:
+		v.p.logger.Debugw("message validation ignored; dropping message", v.p.msgFields(msg)...)
+		v.p.rejectMessage(msg, rejectValidationIgnored)
+	case validationThrottled:
+		v.p.logger.Debugw("message validation throttled; dropping message", v.p.msgFields(msg)...)
+		v.p.rejectMessage(msg, rejectValidationThrottled)
 	}
 }
 
-func (v *validation) validateTopic(vals []*topicVal, src peer.ID, msg *Message) bool {
+func (v *validation) validateTopic(vals []*topicVal, src peer.ID, msg *Message) ValidationResult {
 	if len(vals) == 1 {
 		return v.validateSingleTopic(vals[0], src, msg)
 	}
@@ -269,11 +474,9 @@ func (v *validation) validateTopic(vals []*topicVal, src peer.ID, msg *Message)
 	ctx, cancel := context.WithCancel(v.p.ctx)
 	defer cancel()
 
-	rch := make(chan bool, len(vals))
+	rch := make(chan ValidationResult, len(vals))
 	rcount := 0
-	throttle := false
 
-loop:
 	for _, val := range vals {
 		rcount++
 
@@ -285,28 +488,35 @@ loop:
 			}(val)
 
 		default:
-			log.Debugf("validation throttled for topic %s", val.topic)
-			throttle = true
-			break loop
+			v.p.logger.Debugw("validation throttled", "topic", val.topic)
+			rch <- validationThrottled
 		}
 	}
 
-	if throttle {
-		return false
-	}
-
+	result := ValidationAccept
+loop:
 	for i := 0; i < rcount; i++ {
-		valid := <-rch
-		if !valid {
-			return false
+		switch <-rch {
+		case ValidationAccept:
+		case ValidationReject:
+			result = ValidationReject
+			break loop
+		case ValidationIgnore:
+			// throttled validation has the same effect, but takes precedence over Ignore as
+			// it is not known whether the throttled validator would have signaled rejection.
+			if result != validationThrottled {
+				result = ValidationIgnore
+			}
+		case validationThrottled:
+			result = validationThrottled
 		}
 	}
 
-	return true
+	return result
 }
 
 // fast path for single topic validation that avoids the extra goroutine
-func (v *validation) validateSingleTopic(val *topicVal, src peer.ID, msg *Message) bool {
+func (v *validation) validateSingleTopic(val *topicVal, src peer.ID, msg *Message) ValidationResult {
 	select {
 	case val.validateThrottle <- struct{}{}:
 		res := val.validateMsg(v.p.ctx, src, msg)
@@ -315,24 +525,34 @@ func (v *validation) validateSingleTopic(val *topicVal, src peer.ID, msg *Messag
 		return res
 
 	default:
-		log.Debugf("validation throttled for topic %s", val.topic)
-		return false
+		v.p.logger.Debugw("validation throttled", "topic", val.topic)
+		return validationThrottled
 	}
 }
 
-func (val *topicVal) validateMsg(ctx context.Context, src peer.ID, msg *Message) bool {
+func (val *topicVal) validateMsg(ctx context.Context, src peer.ID, msg *Message) ValidationResult {
 	if val.validateTimeout > 0 {
 		var cancel func()
 		ctx, cancel = context.WithTimeout(ctx, val.validateTimeout)
 		defer cancel()
 	}
 
-	valid := val.validate(ctx, src, msg)
-	if !valid {
-		log.Debugf("validation failed for topic %s", val.topic)
+	start := time.Now()
+	r := val.validate(ctx, src, msg.view())
+	val.metrics.ValidationLatency(val.topic, time.Since(start))
+	switch r {
+	case ValidationAccept:
+		return r
+	case ValidationReject:
+		val.logger.Debugw("validation failed", "peer", src, "topic", val.topic)
+		return r
+	case ValidationIgnore:
+		val.logger.Debugw("validation ignored message", "peer", src, "topic", val.topic)
+		return r
+	default:
+		val.logger.Warnw("unexpected result from validator; ignoring message", "peer", src, "topic", val.topic, "result", r)
+		return ValidationIgnore
 	}
-
-	return valid
 }
 
 /// Options
@@ -0,0 +1,392 @@
+package pubsub
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// NewDelegationCert creates a web of trust certificate, signed by the issuer key, that allows
+// subject to publish in a topic with WOT authentication.
+// The subject may in turn delegate to other peers, up to depth levels; a zero depth forbids
+// further delegation. A zero expiry time creates a certificate that does not expire.
+func NewDelegationCert(topic string, issuer crypto.PrivKey, subject peer.ID, expiry time.Time, depth int) (*pb.DelegationCert, error) {
+	if depth < 0 {
+		return nil, fmt.Errorf("invalid delegation depth; must be non-negative")
+	}
+
+	cert := &pb.DelegationCert{
+		Topic:   &topic,
+		Subject: []byte(subject),
+		Depth:   newUint32(uint32(depth)),
+	}
+
+	if !expiry.IsZero() {
+		exp := expiry.Unix()
+		cert.Expiry = &exp
+	}
+
+	err := signDelegationCert(issuer, cert)
+	if err != nil {
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+func newUint32(v uint32) *uint32 {
+	return &v
+}
+
+// MaxDelegationChain is the maximum number of certificates in a chain from a root key.
+const MaxDelegationChain = 8
+
+const (
+	// maxWotCerts is the maximum number of certificates stored per topic
+	maxWotCerts = 4096
+	// maxPendingCerts is the maximum number of stored certificates whose issuer is not
+	// trusted yet; the oldest are dropped first.
+	maxPendingCerts = 256
+)
+
+// delegationCert is a certificate whose signature has been verified
+type delegationCert struct {
+	id      string
+	cert    *pb.DelegationCert
+	issuer  peer.ID
+	subject peer.ID
+	seq     uint64 // insertion order
+	rooted  bool   // the issuer is trusted to delegate
+}
+
+func (c *delegationCert) expired(now time.Time) bool {
+	exp := c.cert.GetExpiry()
+	return exp != 0 && now.Unix() >= exp
+}
+
+// delegationCertID returns the ID of a certificate, which is used for revocation.
+func delegationCertID(cert *pb.DelegationCert) (string, error) {
+	bytes, err := cert.Marshal()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.Sum256(bytes)
+	return string(h[:]), nil
+}
+
+// wotLink is the trust granted to a peer: the remaining delegation depth and the chain
+// of certificates from a root key.
+type wotLink struct {
+	depth int
+	chain []*pb.DelegationCert
+}
+
+// wotTrust is the web of trust of a topic with WOT authentication.
+// The root keys of the topic descriptor are trusted to publish and to delegate; a peer is
+// trusted if there is a chain of at most MaxDelegationChain valid certificates from a root
+// key to the peer, with every link within the delegation depth allowed by the previous link.
+// Certificates are learned from the messages they are attached to, and added locally
+// through the Topic handle.
+// The trusted peers are computed from the roots when certificates are added, revoked or
+// expire, and cached until then. Certificates whose issuer is not trusted are kept pending,
+// as their issuer may be trusted by a later certificate, up to maxPendingCerts.
+type wotTrust struct {
+	topic string
+	roots map[peer.ID]struct{}
+	now   func() time.Time
+
+	mx         sync.Mutex
+	certs      map[string]*delegationCert
+	revoked    map[string]struct{}
+	seq        uint64
+	trust      map[peer.ID]*wotLink
+	dirty      bool
+	nextExpiry int64 // the earliest expiry of the stored certificates, or 0
+}
+
+func newWotTrust(td *pb.TopicDescriptor, roots map[peer.ID]struct{}) *wotTrust {
+	return &wotTrust{
+		topic:   td.GetName(),
+		roots:   roots,
+		now:     time.Now,
+		certs:   make(map[string]*delegationCert),
+		revoked: make(map[string]struct{}),
+		dirty:   true,
+	}
+}
+
+// addCerts verifies and stores certificates, returning the IDs of the new certificates.
+func (w *wotTrust) addCerts(certs []*pb.DelegationCert) ([]string, error) {
+	var vcerts []*delegationCert
+	for _, cert := range certs {
+		if cert.GetTopic() != w.topic {
+			return nil, fmt.Errorf("certificate for topic %s, expected %s", cert.GetTopic(), w.topic)
+		}
+
+		issuer, err := verifyDelegationCert(cert)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate: %s", err)
+		}
+
+		subject, err := peer.IDFromBytes(cert.GetSubject())
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate subject: %s", err)
+		}
+
+		id, err := delegationCertID(cert)
+		if err != nil {
+			return nil, err
+		}
+
+		vcerts = append(vcerts, &delegationCert{id: id, cert: cert, issuer: issuer, subject: subject})
+	}
+
+	w.mx.Lock()
+	defer w.mx.Unlock()
+
+	now := w.now()
+	w.refresh(now)
+
+	var added []string
+	for _, c := range vcerts {
+		if _, ok := w.certs[c.id]; ok {
+			continue
+		}
+		if _, ok := w.revoked[c.id]; ok {
+			continue
+		}
+		if c.expired(now) {
+			continue
+		}
+		if len(w.certs) >= maxWotCerts && !w.evictPending() {
+			w.remove(added)
+			return nil, fmt.Errorf("too many certificates in topic %s", w.topic)
+		}
+
+		w.seq++
+		c.seq = w.seq
+		w.certs[c.id] = c
+		added = append(added, c.id)
+		w.dirty = true
+	}
+
+	return added, nil
+}
+
+// remove drops certificates; the lock must be held.
+func (w *wotTrust) remove(ids []string) {
+	for _, id := range ids {
+		delete(w.certs, id)
+	}
+	if len(ids) > 0 {
+		w.dirty = true
+	}
+}
+
+// evictPending drops the oldest pending certificate, returning false if there is none.
+// The lock must be held.
+func (w *wotTrust) evictPending() bool {
+	var oldest *delegationCert
+	for _, c := range w.certs {
+		if !c.rooted && (oldest == nil || c.seq < oldest.seq) {
+			oldest = c
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+
+	delete(w.certs, oldest.id)
+	w.dirty = true
+	return true
+}
+
+// revoke revokes a certificate; a revoked certificate is never trusted again.
+func (w *wotTrust) revoke(cert *pb.DelegationCert) error {
+	id, err := delegationCertID(cert)
+	if err != nil {
+		return err
+	}
+
+	w.mx.Lock()
+	defer w.mx.Unlock()
+
+	w.revoked[id] = struct{}{}
+	w.remove([]string{id})
+
+	return nil
+}
+
+// chain returns the certificates that link a peer to a root key, or false if the
+// peer is not trusted. The chain is empty for root keys.
+func (w *wotTrust) chain(p peer.ID) ([]*pb.DelegationCert, bool) {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+
+	w.refresh(w.now())
+
+	link, ok := w.trust[p]
+	if !ok {
+		return nil, false
+	}
+	return link.chain, true
+}
+
+// authorized returns whether a peer is trusted to publish.
+func (w *wotTrust) authorized(p peer.ID) bool {
+	_, ok := w.chain(p)
+	return ok
+}
+
+// refresh recomputes the trusted peers if certificates were added or removed, or if a
+// certificate has expired since the last computation. The lock must be held.
+func (w *wotTrust) refresh(now time.Time) {
+	if !w.dirty && (w.nextExpiry == 0 || now.Unix() < w.nextExpiry) {
+		return
+	}
+
+	w.nextExpiry = 0
+	for id, c := range w.certs {
+		if c.expired(now) {
+			delete(w.certs, id)
+			continue
+		}
+
+		exp := c.cert.GetExpiry()
+		if exp != 0 && (w.nextExpiry == 0 || exp < w.nextExpiry) {
+			w.nextExpiry = exp
+		}
+	}
+
+	w.trust = w.resolve()
+
+	var pending []*delegationCert
+	for _, c := range w.certs {
+		link, ok := w.trust[c.issuer]
+		c.rooted = ok && link.depth > 0 && len(link.chain) < MaxDelegationChain
+		if !c.rooted {
+			pending = append(pending, c)
+		}
+	}
+
+	if len(pending) > maxPendingCerts {
+		sort.Slice(pending, func(i, j int) bool { return pending[i].seq < pending[j].seq })
+		for _, c := range pending[:len(pending)-maxPendingCerts] {
+			delete(w.certs, c.id)
+		}
+	}
+
+	w.dirty = false
+}
+
+// resolve computes the trusted peers, finding for each peer the chain that grants the
+// largest delegation depth. Round n extends the chains of round n-1 by one certificate,
+// so that no chain is longer than MaxDelegationChain. The lock must be held.
+func (w *wotTrust) resolve() map[peer.ID]*wotLink {
+	trust := make(map[peer.ID]*wotLink, len(w.roots))
+	for p := range w.roots {
+		trust[p] = &wotLink{depth: math.MaxInt32}
+	}
+
+	for round := 0; round < MaxDelegationChain; round++ {
+		next := make(map[peer.ID]*wotLink, len(trust))
+		for p, link := range trust {
+			next[p] = link
+		}
+
+		changed := false
+		for _, c := range w.certs {
+			issuer, ok := trust[c.issuer]
+			if !ok || issuer.depth < 1 {
+				continue
+			}
+
+			// the issuer can't grant more than its own remaining depth
+			depth := issuer.depth - 1
+			if int(c.cert.GetDepth()) < depth {
+				depth = int(c.cert.GetDepth())
+			}
+
+			if link, ok := next[c.subject]; ok && link.depth >= depth {
+				continue
+			}
+
+			chain := append(append([]*pb.DelegationCert(nil), issuer.chain...), c.cert)
+			next[c.subject] = &wotLink{depth: depth, chain: chain}
+			changed = true
+		}
+
+		trust = next
+		if !changed {
+			break
+		}
+	}
+
+	return trust
+}
+
+// authorizeMsg checks the publisher of a message against the web of trust, after learning the
+// certificates attached to the message. The message signature must have been verified.
+func (w *wotTrust) authorizeMsg(msg *Message) error {
+	var added []string
+	if len(msg.Certs) > 0 {
+		var err error
+		added, err = w.addCerts(msg.Certs)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !w.authorized(msg.GetFrom()) {
+		// the certificates of rejected messages are not retained
+		w.mx.Lock()
+		w.remove(added)
+		w.mx.Unlock()
+		return fmt.Errorf("publisher %s is not trusted in topic %s", msg.GetFrom(), w.topic)
+	}
+
+	return nil
+}
+
+// AddCerts adds web of trust certificates to a topic with WOT authentication.
+// The certificates are used to validate messages, and the certificates that link the author
+// of our messages to a root key are attached to the messages we publish.
+func (t *Topic) AddCerts(certs ...*pb.DelegationCert) error {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	if t.closed {
+		return ErrTopicClosed
+	}
+
+	if t.auth == nil || t.auth.wot == nil {
+		return fmt.Errorf("topic %s does not use web of trust authentication", t.topic)
+	}
+
+	_, err := t.auth.wot.addCerts(certs)
+	return err
+}
+
+// RevokeCert revokes a web of trust certificate in a topic with WOT authentication; messages
+// from peers that are only trusted through the certificate will be rejected.
+func (t *Topic) RevokeCert(cert *pb.DelegationCert) error {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	if t.closed {
+		return ErrTopicClosed
+	}
+
+	if t.auth == nil || t.auth.wot == nil {
+		return fmt.Errorf("topic %s does not use web of trust authentication", t.topic)
+	}
+
+	return t.auth.wot.revoke(cert)
+}
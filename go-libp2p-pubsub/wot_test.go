@@ -0,0 +1,285 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/0xbunyip/libp2p-learn/go-libp2p-pubsub/pb"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func wotTopicDescriptor(t *testing.T, topic string, roots ...host.Host) *pb.TopicDescriptor {
+	td := keyTopicDescriptor(t, topic, roots...)
+	mode := pb.TopicDescriptor_AuthOpts_WOT
+	td.Auth.Mode = &mode
+	return td
+}
+
+func makeDelegationCert(t *testing.T, topic string, issuer, subject host.Host, expiry time.Time, depth int) *pb.DelegationCert {
+	cert, err := NewDelegationCert(topic, issuer.Peerstore().PrivKey(issuer.ID()), subject.ID(), expiry, depth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestWebOfTrust(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 4)
+	psubs := getPubsubs(ctx, hosts)
+
+	// host 0 is the root; it delegates to host 1 with depth 1, which delegates to host 2,
+	// which can't delegate any further to host 3
+	td := wotTopicDescriptor(t, "foobar", hosts[0])
+	cert1 := makeDelegationCert(t, "foobar", hosts[0], hosts[1], time.Time{}, 1)
+	cert2 := makeDelegationCert(t, "foobar", hosts[1], hosts[2], time.Now().Add(time.Hour), 0)
+	cert3 := makeDelegationCert(t, "foobar", hosts[2], hosts[3], time.Time{}, 0)
+
+	var topics []*Topic
+	var subs []*Subscription
+	for _, ps := range psubs {
+		topic, err := ps.JoinByTopicDescriptor(td)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sub, err := topic.Subscribe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		topics = append(topics, topic)
+		subs = append(subs, sub)
+	}
+
+	// each peer only knows its own chain
+	err := topics[1].AddCerts(cert1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = topics[2].AddCerts(cert1, cert2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = topics[3].AddCerts(cert1, cert2, cert3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connectAll(t, hosts)
+	time.Sleep(time.Millisecond * 100)
+
+	// the certificates travel with the messages
+	err = topics[2].Publish(ctx, []byte("delegated"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sub := range subs {
+		assertReceive(t, sub, []byte("delegated"))
+	}
+
+	// the delegation depth is exceeded
+	err = topics[3].Publish(ctx, []byte("too deep"))
+	if err == nil {
+		t.Fatal("expected publishing beyond the delegation depth to fail")
+	}
+
+	// revoked certificates are rejected
+	for _, topic := range topics[:2] {
+		err = topic.RevokeCert(cert2)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = topics[2].Publish(ctx, []byte("revoked"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sub := range subs[:2] {
+		assertNoReceive(t, ctx, sub)
+	}
+
+	// the root can always publish
+	err = topics[0].Publish(ctx, []byte("root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertReceive(t, subs[0], []byte("root"))
+	assertReceive(t, subs[1], []byte("root"))
+}
+
+func TestWebOfTrustChain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := getNetHosts(t, ctx, 4)
+	td := wotTopicDescriptor(t, "foobar", hosts[0])
+
+	auth, err := newTopicAuth(td)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := auth.wot
+
+	// expired certificates are not trusted
+	expired := makeDelegationCert(t, "foobar", hosts[0], hosts[1], time.Now().Add(-time.Minute), 1)
+	_, err = w.addCerts([]*pb.DelegationCert{expired})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.authorized(hosts[1].ID()) {
+		t.Fatal("expected expired certificate to be rejected")
+	}
+
+	// certificates for other topics are rejected
+	other := makeDelegationCert(t, "barfoo", hosts[0], hosts[1], time.Time{}, 1)
+	_, err = w.addCerts([]*pb.DelegationCert{other})
+	if err == nil {
+		t.Fatal("expected certificate for another topic to be rejected")
+	}
+
+	// forged certificates are rejected
+	forged := makeDelegationCert(t, "foobar", hosts[0], hosts[1], time.Time{}, 1)
+	forged.Subject = []byte(hosts[2].ID())
+	_, err = w.addCerts([]*pb.DelegationCert{forged})
+	if err == nil {
+		t.Fatal("expected forged certificate to be rejected")
+	}
+
+	// certificates from untrusted issuers don't grant trust
+	untrusted := makeDelegationCert(t, "foobar", hosts[3], hosts[2], time.Time{}, 1)
+	_, err = w.addCerts([]*pb.DelegationCert{untrusted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.authorized(hosts[2].ID()) {
+		t.Fatal("expected certificate from untrusted issuer to be rejected")
+	}
+
+	// the delegation depth limits the chain
+	cert1 := makeDelegationCert(t, "foobar", hosts[0], hosts[1], time.Time{}, 0)
+	cert2 := makeDelegationCert(t, "foobar", hosts[1], hosts[2], time.Time{}, 0)
+	_, err = w.addCerts([]*pb.DelegationCert{cert1, cert2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !w.authorized(hosts[1].ID()) {
+		t.Fatal("expected delegate to be trusted")
+	}
+	if w.authorized(hosts[2].ID()) {
+		t.Fatal("expected delegation beyond depth to be rejected")
+	}
+
+	// a direct certificate from the root trusts the peer
+	cert3 := makeDelegationCert(t, "foobar", hosts[0], hosts[2], time.Time{}, 0)
+	_, err = w.addCerts([]*pb.DelegationCert{cert3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, ok := w.chain(hosts[2].ID())
+	if !ok || len(chain) != 1 {
+		t.Fatal("expected a direct chain from the root")
+	}
+}
+
+func makeWotKey(t *testing.T) (crypto.PrivKey, peer.ID) {
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, pid
+}
+
+func TestWebOfTrustLimits(t *testing.T) {
+	topic := "foobar"
+	rootKey, root := makeWotKey(t)
+	w := newWotTrust(&pb.TopicDescriptor{Name: &topic}, map[peer.ID]struct{}{root: {}})
+	clock := &fakeClock{t: time.Now()}
+	w.now = clock.now
+
+	addCert := func(issuer crypto.PrivKey, subject peer.ID, expiry time.Time, depth int) *pb.DelegationCert {
+		cert, err := NewDelegationCert(topic, issuer, subject, expiry, depth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.addCerts([]*pb.DelegationCert{cert})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+
+	// chains are limited in length, whatever the delegation depth
+	issuer := rootKey
+	var pids []peer.ID
+	for i := 0; i <= MaxDelegationChain; i++ {
+		priv, pid := makeWotKey(t)
+		addCert(issuer, pid, time.Time{}, 100)
+		issuer = priv
+		pids = append(pids, pid)
+	}
+	if chain, ok := w.chain(pids[MaxDelegationChain-1]); !ok || len(chain) != MaxDelegationChain {
+		t.Fatal("expected a chain of the maximum length to be trusted")
+	}
+	if w.authorized(pids[MaxDelegationChain]) {
+		t.Fatal("expected a chain beyond the maximum length to be rejected")
+	}
+
+	// pending certificates are trusted once their issuer is
+	issuerKey, issuerID := makeWotKey(t)
+	_, subject := makeWotKey(t)
+	addCert(issuerKey, subject, time.Time{}, 0)
+	if w.authorized(subject) {
+		t.Fatal("expected certificate from untrusted issuer to be rejected")
+	}
+	addCert(rootKey, issuerID, clock.now().Add(time.Minute), 1)
+	if !w.authorized(subject) {
+		t.Fatal("expected pending certificate to be trusted")
+	}
+
+	// expired certificates are dropped, along with the trust they granted
+	count := len(w.certs)
+	clock.advance(time.Minute)
+	if w.authorized(issuerID) || w.authorized(subject) {
+		t.Fatal("expected expired certificate to be rejected")
+	}
+	if len(w.certs) != count-1 {
+		t.Fatalf("expected the expired certificate to be dropped, got %d certificates", len(w.certs))
+	}
+
+	// pending certificates are bounded
+	for i := 0; i < maxPendingCerts+10; i++ {
+		addCert(issuerKey, subject, time.Time{}, i)
+	}
+	w.authorized(subject)
+	if len(w.certs) != MaxDelegationChain+maxPendingCerts {
+		t.Fatalf("expected pending certificates to be bounded, got %d certificates", len(w.certs))
+	}
+
+	// the certificates of rejected messages are not retained
+	cert, err := NewDelegationCert(topic, issuerKey, pids[0], time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &Message{Message: &pb.Message{From: []byte(subject), Certs: []*pb.DelegationCert{cert}}}
+	err = w.authorizeMsg(msg)
+	if err == nil {
+		t.Fatal("expected message from untrusted publisher to be rejected")
+	}
+	id, err := delegationCertID(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := w.certs[id]; ok {
+		t.Fatal("expected the certificates of the rejected message to be dropped")
+	}
+}
@@ -5,6 +5,7 @@ go 1.12
 require (
 	github.com/gogo/protobuf v1.2.1
 	github.com/golang/protobuf v1.3.1
+	github.com/golang/snappy v0.0.1
 	github.com/hashicorp/golang-lru v0.5.1
 	github.com/ipfs/go-log v0.0.1
 	github.com/libp2p/go-libp2p v0.3.1
@@ -14,11 +15,9 @@ require (
 	github.com/libp2p/go-libp2p-host v0.1.0 // indirect
 	github.com/libp2p/go-libp2p-net v0.1.0 // indirect
 	github.com/libp2p/go-libp2p-protocol v0.1.0
-	github.com/libp2p/go-libp2p-pubsub v0.1.1
 	github.com/libp2p/go-libp2p-swarm v0.2.1
 	github.com/multiformats/go-multiaddr v0.0.4
 	github.com/multiformats/go-multistream v0.1.0
 	github.com/paralin/go-libp2p-grpc v0.0.0-20171228081709-3d5d33466aef
-	github.com/whyrusleeping/timecache v0.0.0-20160911033111-cfcb2f1abfee
 	google.golang.org/grpc v1.19.0
 )
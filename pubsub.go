@@ -82,12 +82,12 @@ func createHosts(n int) ([]host.Host, error) {
 	return hosts, nil
 }
 
-func createPubSubs(hosts []host.Host) ([]*pubsub.PubSub, error) {
+func createPubSubs(hosts []host.Host, opts ...pubsub.Option) ([]*pubsub.PubSub, error) {
 	ps := []*pubsub.PubSub{}
 	ctx := context.Background()
 	for _, h := range hosts {
 		// p, err := pubsub.NewGossipSub(ctx, h)
-		p, err := pubsub.NewFloodSub(ctx, h)
+		p, err := pubsub.NewFloodSub(ctx, h, opts...)
 		if err != nil {
 			return nil, err
 		}